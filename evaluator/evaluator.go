@@ -2,94 +2,529 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sync"
 
 	"simlang/types"
+	"simlang/util"
 )
 
+// EvalError reports a runtime failure together with the source span of the
+// expression that caused it.
+type EvalError struct {
+	types.Span
+	Err error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Start, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt attaches the span of node to err, unless err already points at a
+// more specific location.
+func errorAt(node types.Spanned, err error) error {
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		return err
+	}
+	return &EvalError{Span: node.SourceSpan(), Err: err}
+}
+
+// wrapEval adds that evaluating what failed to err, unless err already
+// points at the failing expression. Without that, a failure deep in a
+// recursion would carry one "failed to eval call" for every frame above it.
+func wrapEval(what string, err error) error {
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		return err
+	}
+	return fmt.Errorf("failed to eval %s: %w", what, err)
+}
+
+// Env is a scope. The scope of a closure call keeps the arguments in a slice
+// next to the parameters naming them, which is much cheaper to build than a
+// map; EnvMap holds the other bindings, like those of define and let.
 type Env struct {
-	EnvMap map[string]any
+	EnvMap map[string]Value
+	params []*types.SymbolNode
+	args   []Value
 	parent *Env
 }
 
-func (e *Env) Get(name string) any {
-	if val, ok := e.EnvMap[name]; ok {
-		return val
+// Lookup finds name in e or its parents. ok is false when name is unbound.
+func (e *Env) Lookup(name string) (value Value, ok bool) {
+	for env := e; env != nil; env = env.parent {
+		// a define in the body of a closure shadows its parameter
+		if value, ok := env.EnvMap[name]; ok {
+			return value, true
+		}
+		for i, param := range env.params {
+			if param.Name == name {
+				return env.args[i], true
+			}
+		}
 	}
-	if e.parent != nil {
-		return e.parent.Get(name)
+	return nil, false
+}
+
+// define binds name in e itself.
+func (e *Env) define(name string, value Value) {
+	if e.EnvMap == nil {
+		e.EnvMap = make(map[string]Value)
 	}
-	return nil
+	e.EnvMap[name] = value
 }
 
-func Eval(ast *types.AST) (any, error) {
-	defaultEnv := &Env{EnvMap: make(map[string]any)}
-	defaultEnv.EnvMap["+"] = func(args []any) (any, error) {
-		resultSum := 0.0
-		for _, arg := range args {
-			if num, ok := arg.(float64); ok {
-				resultSum += num
+// set changes the binding of name in e or its parents. ok is false when name
+// is unbound.
+func (e *Env) set(name string, value Value) (ok bool) {
+	for env := e; env != nil; env = env.parent {
+		if _, ok := env.EnvMap[name]; ok {
+			env.EnvMap[name] = value
+			return true
+		}
+		for i, param := range env.params {
+			if param.Name == name {
+				env.args[i] = value
+				return true
 			}
 		}
-		return resultSum, nil
 	}
+	return false
+}
+
+// names lists every name visible from e, inner scopes first.
+func (e *Env) names() []string {
+	var names []string
+	for env := e; env != nil; env = env.parent {
+		for name := range env.EnvMap {
+			names = append(names, name)
+		}
+		for _, param := range env.params {
+			names = append(names, param.Name)
+		}
+	}
+	return names
+}
+
+// UnboundError reports a reference to a name that is not defined. Suggestion
+// is a similarly spelled visible name, if there is one.
+type UnboundError struct {
+	Name       string
+	Suggestion string
+}
+
+func (e *UnboundError) Error() string {
+	if e.Suggestion == "" {
+		return fmt.Sprintf("unbound variable `%s`", e.Name)
+	}
+	return fmt.Sprintf("unbound variable `%s`, did you mean `%s`?", e.Name, e.Suggestion)
+}
 
-	if result, err := evalSingle(ast.Root, defaultEnv); err != nil {
-		return nil, fmt.Errorf("failed to eval: %w, original input is %+v", err, ast)
+func unboundError(name string, env *Env) *UnboundError {
+	suggestion, _ := util.Suggest(name, env.names())
+	return &UnboundError{Name: name, Suggestion: suggestion}
+}
+
+func newDefaultEnv() *Env {
+	defaultEnv := &Env{EnvMap: make(map[string]Value, len(stdlib))}
+	for _, builtin := range stdlib {
+		defaultEnv.EnvMap[builtin.Name] = builtin
+	}
+	return defaultEnv
+}
+
+// numericComparison builds a builtin that checks holds(compareNumbers(a, b))
+// for every pair of neighbouring arguments, e.g. (< 1 2 3). Comparisons
+// involving NaN are false.
+func numericComparison(name string, holds func(c int) bool) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		var buf [4]Number
+		nums, err := appendNumberArgs(buf[:0], name, args)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i < len(nums); i++ {
+			if c, ok := compareNumbers(nums[i-1].num, nums[i].num); !ok || !holds(c) {
+				return Bool(false), nil
+			}
+		}
+		return Bool(true), nil
+	}
+}
+
+// isTruthy follows Scheme: every value except #f counts as true.
+func isTruthy(value Value) bool {
+	b, ok := value.(Bool)
+	return !ok || bool(b)
+}
+
+// Session owns a root environment that outlives a single evaluation, so
+// names defined by one Eval stay visible to the following ones. It is safe
+// for concurrent use; evaluations are serialized.
+type Session struct {
+	mu     sync.Mutex
+	root   *Env
+	macros map[string]macro
+}
+
+func NewSession() *Session {
+	return &Session{root: newDefaultEnv(), macros: make(map[string]macro)}
+}
+
+func (s *Session) Eval(ast *types.AST) (Value, error) {
+	return s.EvalWithOptions(context.Background(), ast, Limits{})
+}
+
+// EvalWithOptions expands the macros in ast and evaluates it within limits.
+// It stops with a *LimitExceeded error when a limit is reached or ctx is
+// done; definitions made before that stay in the session.
+func (s *Session) EvalWithOptions(ctx context.Context, ast *types.AST, limits Limits) (Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return newEvaluation(ctx, limits, s.macros).expandAndEval(ast.Root, s.root)
+}
+
+// Expand returns ast with every macro use expanded, as EvalWithOptions would
+// evaluate it.
+func (s *Session) Expand(ast *types.AST) (*types.AST, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expanded, err := newEvaluation(context.Background(), Limits{}, s.macros).expand(ast.Root); err != nil {
+		return nil, fmt.Errorf("failed to expand: %w", err)
+	} else {
+		return &types.AST{Root: expanded}, nil
+	}
+}
+
+// EvalProgram evaluates the forms of program in order and returns the value of
+// the last one. It stops at the first error.
+func (s *Session) EvalProgram(program *types.Program) (Value, error) {
+	return s.EvalProgramWithOptions(context.Background(), program, Limits{})
+}
+
+// EvalProgramWithOptions is EvalProgram within limits, which apply to the
+// program as a whole.
+func (s *Session) EvalProgramWithOptions(ctx context.Context, program *types.Program, limits Limits) (Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ev := newEvaluation(ctx, limits, s.macros)
+	var result Value = Void{}
+	for _, form := range program.Forms {
+		value, err := ev.expandAndEval(form, s.root)
+		if err != nil {
+			return nil, err
+		}
+		result = value
+	}
+	return result, nil
+}
+
+// Define binds name to value in the session, like a top-level define.
+func (s *Session) Define(name string, value Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.root.define(name, value)
+}
+
+// Lookup returns the value name is bound to in the session.
+func (s *Session) Lookup(name string) (Value, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.root.Lookup(name)
+}
+
+// Apply calls the procedure f with args within limits.
+func (s *Session) Apply(ctx context.Context, f Value, args []Value, limits Limits) (Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a closure keeps its arguments, which must not change under it
+	if result, err := newEvaluation(ctx, limits, s.macros).apply(f, slices.Clone(args)); err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %w", f, err)
 	} else {
 		return result, nil
 	}
 }
 
-func evalSingle(item types.ASTNode, env *Env) (any, error) {
-	switch v := item.(type) {
-	case *types.NumberNode:
-		return v.Value, nil
-	case *types.SymbolNode:
-		return env.Get(v.Name), nil
-	case *types.CallNode:
-		switch function := v.Function.(type) {
+// Eval evaluates ast in a fresh environment.
+func Eval(ast *types.AST) (Value, error) {
+	return NewSession().Eval(ast)
+}
+
+// EvalWithOptions evaluates ast in a fresh environment within limits.
+func EvalWithOptions(ctx context.Context, ast *types.AST, limits Limits) (Value, error) {
+	return NewSession().EvalWithOptions(ctx, ast, limits)
+}
+
+// eval evaluates item in env. Expressions in tail position (let and lambda
+// bodies, the chosen branch of if and cond, the last argument of and and or,
+// the last expression of begin) are evaluated by the loop of evalTail
+// instead of a recursive call, so tail calls run in constant Go stack and do
+// not count towards MaxDepth.
+func (ev *evaluation) eval(item types.ASTNode, env *Env) (Value, error) {
+	if err := ev.enter(); err != nil {
+		return nil, errorAt(item, err)
+	}
+	// not deferred, eval is called for every argument of every call
+	value, err := ev.evalTail(item, env)
+	ev.leave()
+	return value, err
+}
+
+func (ev *evaluation) evalTail(item types.ASTNode, env *Env) (Value, error) {
+	for {
+		if err := ev.step(); err != nil {
+			return nil, errorAt(item, err)
+		}
+		switch v := item.(type) {
+		case *types.NumberNode:
+			return numberFromLiteral(v), nil
+		case *types.BoolNode:
+			return Bool(v.Value), nil
+		case *types.StringNode:
+			return String(v.Value), nil
 		case *types.SymbolNode:
-			evalutedArgs := make([]any, 0)
+			value, ok := env.Lookup(v.Name)
+			if !ok {
+				return nil, errorAt(v, unboundError(v.Name, env))
+			}
+			return value, nil
+		case *types.CallNode:
+			f, err := ev.eval(v.Function, env)
+			if err != nil {
+				return nil, wrapEval("call", err)
+			}
+			switch f.(type) {
+			case *Builtin, *Closure:
+			default:
+				return nil, errorAt(v.Function, fmt.Errorf("not a function: %s", f))
+			}
+
+			closure, ok := f.(*Closure)
+			if !ok {
+				// builtins do not keep their arguments, which can live on the
+				// argument stack of the evaluation
+				base := len(ev.argStack)
+				for _, arg := range v.Args {
+					evaluatedArg, err := ev.eval(arg, env)
+					if err != nil {
+						ev.popArgs(base)
+						return nil, wrapEval("call", err)
+					}
+					ev.argStack = append(ev.argStack, evaluatedArg)
+				}
+				ev.site = v.Span
+				result, err := ev.apply(f, ev.argStack[base:len(ev.argStack):len(ev.argStack)])
+				ev.popArgs(base)
+				if err != nil {
+					return nil, errorAt(v, err)
+				}
+				return result, nil
+			}
+
+			evalutedArgs := make([]Value, 0, len(v.Args))
 			for _, arg := range v.Args {
-				evaluatedArg, err := evalSingle(arg, env)
+				evaluatedArg, err := ev.eval(arg, env)
 				if err != nil {
-					return nil, fmt.Errorf("failed to eval call: %w", err)
+					return nil, wrapEval("call", err)
 				}
 				evalutedArgs = append(evalutedArgs, evaluatedArg)
 			}
-
-			f := env.Get(function.Name)
-			if f == nil {
-				return nil, fmt.Errorf("failed to eval call, function %s not found", function.Name)
+			callEnv, err := ev.bind(closure, evalutedArgs)
+			if err != nil {
+				return nil, errorAt(v, err)
 			}
-
-			return f.(func([]any) (any, error))(evalutedArgs)
-		default:
-			return item, nil
-		}
-	case *types.LetNode:
-		executedEnv := make(map[string]any)
-		for key, value := range v.LetEnv {
-			evalResult, err := evalSingle(value, &Env{EnvMap: executedEnv, parent: env})
+			item, env = closure.Body, callEnv
+		case *types.LetNode:
+			if err := ev.charge(envBytes + bindingBytes*int64(len(v.Bindings))); err != nil {
+				return nil, errorAt(v, err)
+			}
+			letEnv := &Env{EnvMap: make(map[string]Value), parent: env}
+			// let evaluates every value in the outer env, let* and letrec in the
+			// new one so values can see earlier (let*) or all (letrec) bindings.
+			valueEnv := letEnv
+			if v.Kind == types.LET {
+				valueEnv = env
+			}
+			for _, binding := range v.Bindings {
+				evalResult, err := ev.eval(binding.Value, valueEnv)
+				if err != nil {
+					return nil, wrapEval("let value "+binding.Name.Name, err)
+				}
+				letEnv.EnvMap[binding.Name.Name] = evalResult
+			}
+			item, env = v.Body, letEnv
+		case *types.IfNode:
+			cond, err := ev.eval(v.Cond, env)
 			if err != nil {
-				return nil, fmt.Errorf("failed to eval let value: %w", err)
+				return nil, wrapEval("if condition", err)
 			}
-			executedEnv[key] = evalResult
-		}
-		return evalSingle(v.Body, &Env{EnvMap: executedEnv, parent: env})
-	case *types.LambdaNode:
-		return func(args []any) (any, error) {
-			if len(args) != len(v.Args) {
-				return nil, fmt.Errorf("expected %d arguments, got %d, args: %+v", len(v.Args), len(args), args)
+			if isTruthy(cond) {
+				item = v.Then
+			} else if v.Else != nil {
+				item = v.Else
+			} else {
+				return Void{}, nil
+			}
+		case *types.CondNode:
+			var body types.ASTNode
+			for _, clause := range v.Clauses {
+				test, err := ev.eval(clause.Test, env)
+				if err != nil {
+					return nil, wrapEval("cond test", err)
+				}
+				if isTruthy(test) {
+					body = clause.Body
+					break
+				}
 			}
-			executedEnv := make(map[string]any)
-			for i, arg := range args {
-				executedEnv[v.Args[i].Name] = arg
+			if body == nil {
+				body = v.Else
 			}
-			return evalSingle(v.Body, &Env{EnvMap: executedEnv, parent: env})
-		}, nil
+			if body == nil {
+				return Void{}, nil
+			}
+			item = body
+		case *types.LogicalNode:
+			// (and) is #t and (or) is #f, otherwise the value that decided the result
+			if len(v.Args) == 0 {
+				return Bool(v.Op == types.AND), nil
+			}
+			last := v.Args[len(v.Args)-1]
+			for _, arg := range v.Args[:len(v.Args)-1] {
+				value, err := ev.eval(arg, env)
+				if err != nil {
+					return nil, wrapEval("logical arg", err)
+				}
+				if isTruthy(value) == (v.Op == types.OR) {
+					return value, nil
+				}
+			}
+			item = last
+		case *types.DefineNode:
+			value, err := ev.eval(v.Value, env)
+			if err != nil {
+				return nil, wrapEval("define of "+v.Name.Name, err)
+			}
+			if closure, ok := value.(*Closure); ok && closure.Name == "" {
+				closure.Name = v.Name.Name
+			}
+			if err := ev.charge(bindingBytes); err != nil {
+				return nil, errorAt(v, err)
+			}
+			env.define(v.Name.Name, value)
+			return Void{}, nil
+		case *types.SetNode:
+			value, err := ev.eval(v.Value, env)
+			if err != nil {
+				return nil, wrapEval("set! of "+v.Name.Name, err)
+			}
+			if !env.set(v.Name.Name, value) {
+				return nil, errorAt(v.Name, unboundError(v.Name.Name, env))
+			}
+			return Void{}, nil
+		case *types.BeginNode:
+			if len(v.Body) == 0 {
+				return Void{}, nil
+			}
+			for _, expr := range v.Body[:len(v.Body)-1] {
+				if _, err := ev.eval(expr, env); err != nil {
+					return nil, wrapEval("begin", err)
+				}
+			}
+			item = v.Body[len(v.Body)-1]
+		case *types.LambdaNode:
+			if err := ev.charge(closureBytes); err != nil {
+				return nil, errorAt(v, err)
+			}
+			return &Closure{Params: v.Args, Body: v.Body, Env: env}, nil
+		case *types.QuoteNode:
+			value := datumToValue(v.Datum)
+			if err := ev.chargeResult(value, nil); err != nil {
+				return nil, errorAt(v, err)
+			}
+			return value, nil
+		case *types.QuasiquoteNode:
+			value, err := ev.quasiquote(v.Template, env)
+			if err != nil {
+				return nil, wrapEval("quasiquote", err)
+			}
+			return value, nil
+		case *types.DefmacroNode:
+			if err := ev.charge(closureBytes); err != nil {
+				return nil, errorAt(v, err)
+			}
+			ev.macros[v.Name.Name] = &procedureMacro{&Closure{Name: v.Name.Name, Params: v.Params, Body: v.Body, Env: env}}
+			return Void{}, nil
+		case *types.DefineSyntaxNode:
+			rules, err := newSyntaxRules(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to eval define-syntax of %s: %w", v.Name.Name, err)
+			}
+			if err := ev.charge(closureBytes); err != nil {
+				return nil, errorAt(v, err)
+			}
+			ev.macros[v.Name.Name] = rules
+			return Void{}, nil
+		default:
+			return nil, errorAt(v, fmt.Errorf("cannot evaluate %s", v))
+		}
+	}
+}
+
+// bind creates the environment a call to c evaluates its body in. The
+// environment keeps args, so callers pass a slice they do not reuse.
+func (ev *evaluation) bind(c *Closure, args []Value) (*Env, error) {
+	if len(args) != len(c.Params) {
+		return nil, fmt.Errorf("%s expects %s, got %d", c, pluralArguments(len(c.Params)), len(args))
+	}
+	if err := ev.charge(envBytes + bindingBytes*int64(len(args))); err != nil {
+		return nil, err
+	}
+	return &Env{params: c.Params, args: args, parent: c.Env}, nil
+}
+
+// apply calls a Builtin or a Closure.
+func (ev *evaluation) apply(f Value, args []Value) (Value, error) {
+	switch function := f.(type) {
+	case *Builtin:
+		if err := function.CheckArity(len(args)); err != nil {
+			return nil, err
+		}
+		var result Value
+		var err error
+		if function.call != nil {
+			result, err = function.call(ev, args)
+		} else {
+			result, err = function.Fn(args)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ev.chargeResult(result, args); err != nil {
+			return nil, err
+		}
+		return result, nil
+	case *Closure:
+		callEnv, err := ev.bind(function, args)
+		if err != nil {
+			return nil, err
+		}
+		return ev.eval(function.Body, callEnv)
 	default:
-		return v, nil
+		return nil, fmt.Errorf("not a function: %s", f)
 	}
 }
@@ -0,0 +1,248 @@
+package evaluator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"simlang/lexer"
+	"simlang/parser"
+)
+
+// run evaluates the program src in a new session and returns the value of
+// its last expression.
+func run(t *testing.T, src string) (Value, error) {
+	t.Helper()
+	program, err := parser.ParseProgram(lexer.Toknize(src))
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", src, err)
+	}
+	return NewSession().EvalProgram(program)
+}
+
+// evalTest is a program and either the printed value of its last expression
+// or a part of the error it fails with.
+type evalTest struct {
+	name    string
+	src     string
+	want    string
+	wantErr string
+}
+
+// runTests runs every test in a new session.
+func runTests(t *testing.T, tests []evalTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := run(t, tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%s: got %v, %v, want an error containing %q", tt.src, result, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s failed: %v", tt.src, err)
+			}
+			if got := result.String(); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTailCallLoop checks that a tail-recursive loop runs in constant
+// stack, however many times it iterates.
+func TestTailCallLoop(t *testing.T) {
+	if testing.Short() {
+		t.Skip("ten million iterations take a few seconds")
+	}
+	result, err := run(t, `
+		(define (loop n) (if (= n 0) 'done (loop (- n 1))))
+		(loop 10000000)`)
+	if err != nil {
+		t.Fatalf("(loop 10000000) failed: %v", err)
+	}
+	if got := result.String(); got != "done" {
+		t.Errorf("(loop 10000000) = %s, want done", got)
+	}
+}
+
+// TestDefaultDepthLimit checks that deep recursion without explicit limits
+// stops with a depth error instead of overflowing the Go stack, and that the
+// error is not wrapped once per frame.
+func TestDefaultDepthLimit(t *testing.T) {
+	_, err := run(t, `
+		(define (count n) (if (= n 0) 0 (+ 1 (count (- n 1)))))
+		(count 1000000)`)
+	var limitErr *LimitExceeded
+	if !errors.As(err, &limitErr) || limitErr.Limit != "depth" || limitErr.Max != DefaultMaxDepth {
+		t.Fatalf("(count 1000000) = %v, want the depth limit of %d", err, DefaultMaxDepth)
+	}
+	if n := strings.Count(err.Error(), "failed to eval"); n > 1 {
+		t.Errorf("error has %d \"failed to eval\" prefixes: %.200s", n, err)
+	}
+}
+
+// TestSetAndBegin checks that set! changes existing bindings of every kind
+// and that begin sequences expressions, its last one in tail position.
+func TestSetAndBegin(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "set! a global", src: `(define x 1) (set! x 2) x`, want: "2"},
+		{name: "set! a parameter", src: `((lambda (n) (begin (set! n (+ n 1)) n)) 41)`, want: "42"},
+		{name: "set! a let binding", src: `(let ((n 1)) in (begin (set! n 5) n))`, want: "5"},
+		{
+			name: "set! a captured binding",
+			src: `
+				(define counter (let ((n 0)) in (lambda () (begin (set! n (+ n 1)) n))))
+				(counter) (counter)`,
+			want: "2",
+		},
+		{name: "set! the innermost binding", src: `(define x 1) (let ((x 2)) in (set! x 3)) x`, want: "1"},
+		{name: "set! gives no value", src: `(define x 1) (set! x 2)`, want: "#<void>"},
+		{name: "set! an unbound name", src: `(set! nope 1)`, wantErr: "unbound variable `nope`"},
+		{name: "begin gives its last value", src: `(begin 1 2 3)`, want: "3"},
+		{name: "empty begin", src: `(begin)`, want: "#<void>"},
+		{name: "begin runs in order", src: `(define x 1) (begin (set! x (* x 10)) (set! x (+ x 1)) x)`, want: "11"},
+		{
+			name: "last expression of begin is a tail call",
+			src: `
+				(define (loop n) (if (= n 0) 'done (begin 'ignored (loop (- n 1)))))
+				(loop 200000)`,
+			want: "done",
+		},
+	})
+}
+
+// TestLet checks the scope of let, let* and letrec bindings.
+func TestLet(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "let", src: `(let ((x 1) (y 2)) in (+ x y))`, want: "3"},
+		{name: "single binding form", src: `(let (x 10) in x)`, want: "10"},
+		{name: "let binds in the outer scope", src: `(define x 1) (let ((x 2) (y x)) in y)`, want: "1"},
+		{name: "let* binds in sequence", src: `(define x 1) (let* ((x 2) (y x)) in y)`, want: "2"},
+		{name: "let* chain", src: `(let* ((x 1) (y (+ x 1)) (z (* y 2))) in z)`, want: "4"},
+		{name: "empty let*", src: `(let* () in 1)`, want: "1"},
+		{name: "inner let shadows", src: `(let ((x 1)) in (let ((x 2)) in x))`, want: "2"},
+		{name: "shadowing ends with the body", src: `(let ((x 1)) in (+ (let ((x 2)) in x) x))`, want: "3"},
+		{name: "closures capture let bindings", src: `(define (make) (let ((n 5)) in (lambda () n))) ((make))`, want: "5"},
+		{
+			name:    "let lambdas cannot recurse",
+			src:     `(let ((f (lambda (n) (if (= n 0) 1 (* n (f (- n 1))))))) in (f 5))`,
+			wantErr: "unbound variable `f`",
+		},
+		{name: "letrec recursion", src: `(letrec ((f (lambda (n) (if (= n 0) 1 (* n (f (- n 1))))))) in (f 5))`, want: "120"},
+		{
+			name: "letrec mutual recursion",
+			src: `
+				(letrec ((even (lambda (n) (if (= n 0) #t (odd (- n 1)))))
+				         (odd (lambda (n) (if (= n 0) #f (even (- n 1))))))
+				  in (even 10))`,
+			want: "#t",
+		},
+		{name: "letrec initializes in order", src: `(letrec ((x 1) (y (+ x 1))) in y)`, want: "2"},
+		{name: "letrec value used before it is bound", src: `(letrec ((a b) (b 1)) in a)`, wantErr: "unbound variable `b`"},
+	})
+}
+
+// TestApplication checks that any expression that evaluates to a procedure
+// can be called.
+func TestApplication(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "lambda called in place", src: `((lambda (x) (* x 2)) 21)`, want: "42"},
+		{name: "curried lambda", src: `(((lambda (x) (lambda (y) (+ x y))) 1) 2)`, want: "3"},
+		{name: "procedure chosen by if", src: `((if #f + -) 5 3)`, want: "2"},
+		{name: "procedure taken from a list", src: `((car (list + -)) 1 2)`, want: "3"},
+		{name: "procedure returned by a let", src: `((let ((n 10)) in (lambda (x) (+ x n))) 5)`, want: "15"},
+		{name: "builtin bound to a name", src: `(define f +) (f 1 2 3)`, want: "6"},
+		{name: "procedure as an argument", src: `(define (twice f x) (f (f x))) (twice (lambda (n) (* n n)) 3)`, want: "81"},
+		{name: "composition", src: `(define (compose f g) (lambda (x) (f (g x)))) ((compose car cdr) '(1 2 3))`, want: "2"},
+		{name: "builtins as values", src: `(map (lambda (f) (f 9)) (list sqrt -))`, want: "(3 -9)"},
+		{name: "number in call position", src: `(1 2)`, wantErr: "not a function: 1"},
+		{name: "string in call position", src: `("f" 1)`, wantErr: `not a function: "f"`},
+		{name: "symbol in call position", src: `('a)`, wantErr: "not a function: a"},
+		{name: "too few arguments", src: `((lambda (x) x))`, wantErr: "expects 1 argument, got 0"},
+		{name: "too many arguments", src: `((lambda (x) x) 1 2)`, wantErr: "expects 1 argument, got 2"},
+		{name: "no parameters", src: `((lambda () 1) 2)`, wantErr: "expects no arguments, got 1"},
+	})
+}
+
+// TestConditionals checks booleans, if, cond, and, or and the numeric
+// comparisons. Like in Scheme only #f is false.
+func TestConditionals(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "true literal", src: `#t`, want: "#t"},
+		{name: "false literal", src: `#f`, want: "#f"},
+		{name: "zero is true", src: `(if 0 'yes 'no)`, want: "yes"},
+		{name: "empty list is true", src: `(if '() 'yes 'no)`, want: "yes"},
+		{name: "empty string is true", src: `(if "" 'yes 'no)`, want: "yes"},
+		{name: "if without else", src: `(if #f 'yes)`, want: "#<void>"},
+		{name: "cond picks the first true clause", src: `(cond ((= 1 1) 'first) ((= 1 1) 'second))`, want: "first"},
+		{name: "cond falls through", src: `(cond ((> 1 2) 'a) ((< 1 2) 'b) (else 'c))`, want: "b"},
+		{name: "cond else", src: `(cond (#f 1) (else 3))`, want: "3"},
+		{name: "cond without a match", src: `(cond (#f 1))`, want: "#<void>"},
+		{name: "empty and", src: `(and)`, want: "#t"},
+		{name: "empty or", src: `(or)`, want: "#f"},
+		{name: "and gives its last value", src: `(and 1 2 3)`, want: "3"},
+		{name: "and stops at #f", src: `(and 1 #f 3)`, want: "#f"},
+		{name: "or gives the first true value", src: `(or #f 2 3)`, want: "2"},
+		{name: "or of falses", src: `(or #f #f)`, want: "#f"},
+		{name: "and short-circuits", src: `(and #f (car '()))`, want: "#f"},
+		{name: "or short-circuits", src: `(or 1 (car '()))`, want: "1"},
+		{name: "chained <", src: `(< 1 2 3)`, want: "#t"},
+		{name: "chained < fails", src: `(< 1 3 2)`, want: "#f"},
+		{name: "chained >=", src: `(>= 3 3 2)`, want: "#t"},
+		{name: "NaN is not equal", src: `(= (- (/ 1.0 0.0) (/ 1.0 0.0)) 1)`, want: "#f"},
+		{name: "comparing a symbol", src: `(< 1 'a)`, wantErr: "< expects numbers but got a"},
+		{
+			name: "cond clauses are tail positions",
+			src:  `(define (loop n) (cond ((= n 0) 'done) (else (loop (- n 1))))) (loop 200000)`,
+			want: "done",
+		},
+		{
+			name: "last and operand is a tail position",
+			src:  `(define (loop n) (and #t (if (= n 0) 'done (loop (- n 1))))) (loop 200000)`,
+			want: "done",
+		},
+	})
+}
+
+// TestUnboundSuggestion checks the did-you-mean hint of unbound variables,
+// which considers builtins, globals and local bindings.
+func TestUnboundSuggestion(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        string
+		unbound    string
+		suggestion string
+	}{
+		{name: "misspelled builtin", src: `(lenght '(1))`, unbound: "lenght", suggestion: "length"},
+		{name: "missing letter", src: `(revers '(1))`, unbound: "revers", suggestion: "reverse"},
+		{name: "extra letter", src: `(car2 '(1))`, unbound: "car2", suggestion: "car"},
+		{name: "global", src: `(define counter 1) (countr)`, unbound: "countr", suggestion: "counter"},
+		{name: "parameter", src: `(define (f alpha) (+ alph 1)) (f 1)`, unbound: "alph", suggestion: "alpha"},
+		{name: "too far from any name", src: `(xyzzy)`, unbound: "xyzzy"},
+		{name: "transposition of a short name", src: `(let ((value 1)) in valeu)`, unbound: "valeu"},
+		{name: "short names get no hint", src: `(ab -1)`, unbound: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := run(t, tt.src)
+			var unbound *UnboundError
+			if !errors.As(err, &unbound) {
+				t.Fatalf("%s: got %v, %v, want an unbound variable error", tt.src, result, err)
+			}
+			if unbound.Name != tt.unbound || unbound.Suggestion != tt.suggestion {
+				t.Errorf("%s: got %q with suggestion %q, want %q with suggestion %q",
+					tt.src, unbound.Name, unbound.Suggestion, tt.unbound, tt.suggestion)
+			}
+			want := "unbound variable `" + tt.unbound + "`"
+			if tt.suggestion != "" {
+				want += ", did you mean `" + tt.suggestion + "`?"
+			}
+			if !strings.HasSuffix(err.Error(), want) {
+				t.Errorf("%s: got %q, want it to end with %q", tt.src, err, want)
+			}
+		})
+	}
+}
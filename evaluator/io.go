@@ -0,0 +1,25 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// (display value ...) prints its arguments, strings without quotes.
+func display(args []Value) (Value, error) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if str, ok := arg.(String); ok {
+			parts[i] = string(str)
+		} else {
+			parts[i] = arg.String()
+		}
+	}
+	fmt.Print(strings.Join(parts, " "))
+	return Void{}, nil
+}
+
+func newline(args []Value) (Value, error) {
+	fmt.Println()
+	return Void{}, nil
+}
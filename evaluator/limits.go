@@ -0,0 +1,166 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"simlang/types"
+)
+
+// Limits bounds the resources of one evaluation. A zero field means no limit,
+// except for MaxDepth.
+type Limits struct {
+	// MaxSteps bounds the number of expressions evaluated.
+	MaxSteps int64
+	// MaxDepth bounds how deeply evaluations nest. Tail calls do not nest.
+	// Zero means DefaultMaxDepth, as nesting without bound would overflow the
+	// Go stack, which crashes the program.
+	MaxDepth int
+	// MaxAllocBytes bounds an estimate of the memory allocated for
+	// environments, closures, strings, numbers and lists.
+	MaxAllocBytes int64
+}
+
+// LimitExceeded is returned when an evaluation runs past one of its Limits,
+// or when its context is cancelled or times out. For the context case Limit
+// is "context" and Err is the context's error.
+type LimitExceeded struct {
+	Limit string // "steps", "depth", "alloc" or "context"
+	Max   int64
+	Err   error
+}
+
+func (e *LimitExceeded) Error() string {
+	switch e.Limit {
+	case "context":
+		return fmt.Sprintf("evaluation stopped: %s", e.Err)
+	case "alloc":
+		return fmt.Sprintf("evaluation exceeded the allocation limit of %d bytes", e.Max)
+	default:
+		return fmt.Sprintf("evaluation exceeded the %s limit of %d", e.Limit, e.Max)
+	}
+}
+
+func (e *LimitExceeded) Unwrap() error {
+	return e.Err
+}
+
+// DefaultMaxDepth is the depth evaluations are bounded to when the Limits
+// leave MaxDepth zero. A nested evaluation takes a few KB of Go stack, so it
+// stays well below the 1GB Go allows by default.
+const DefaultMaxDepth = 100_000
+
+// contextCheckInterval is how many steps pass between checks of the context,
+// which are much more expensive than counting.
+const contextCheckInterval = 1024
+
+// rough sizes used for MaxAllocBytes accounting
+const (
+	envBytes     = 48
+	bindingBytes = 32
+	closureBytes = 64
+	pairBytes    = 32
+)
+
+// evaluation is the state of one Eval: its context, limits and usage so far,
+// and the macros of its session.
+type evaluation struct {
+	ctx       context.Context
+	limits    Limits
+	steps     int64
+	depth     int
+	allocated int64
+	macros    map[string]macro
+	// site is the span of the builtin call being applied, for builtins like
+	// macroexpand that build code.
+	site types.Span
+	// argStack holds the arguments of the builtin calls being evaluated
+	argStack []Value
+}
+
+func newEvaluation(ctx context.Context, limits Limits, macros map[string]macro) *evaluation {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	return &evaluation{ctx: ctx, limits: limits, macros: macros}
+}
+
+// step counts one evaluated expression.
+func (ev *evaluation) step() error {
+	ev.steps++
+	if ev.limits.MaxSteps > 0 && ev.steps > ev.limits.MaxSteps {
+		return &LimitExceeded{Limit: "steps", Max: ev.limits.MaxSteps}
+	}
+	if ev.steps%contextCheckInterval == 0 {
+		if err := ev.ctx.Err(); err != nil {
+			return &LimitExceeded{Limit: "context", Err: err}
+		}
+	}
+	return nil
+}
+
+// enter starts a nested evaluation; every successful enter must be paired
+// with a leave.
+func (ev *evaluation) enter() error {
+	if ev.depth >= ev.limits.MaxDepth {
+		return &LimitExceeded{Limit: "depth", Max: int64(ev.limits.MaxDepth)}
+	}
+	ev.depth++
+	return nil
+}
+
+func (ev *evaluation) leave() {
+	ev.depth--
+}
+
+// charge records bytes allocated by the evaluation.
+func (ev *evaluation) charge(bytes int64) error {
+	ev.allocated += bytes
+	if ev.limits.MaxAllocBytes > 0 && ev.allocated > ev.limits.MaxAllocBytes {
+		return &LimitExceeded{Limit: "alloc", Max: ev.limits.MaxAllocBytes}
+	}
+	return nil
+}
+
+// chargeResult charges the memory a builtin allocated for result. Only the
+// new part of a list is counted: the walk stops at pairs the builtin got as
+// arguments, like the tail passed to cons or append.
+func (ev *evaluation) chargeResult(result Value, args []Value) error {
+	var bytes int64
+	for {
+		switch v := result.(type) {
+		case String:
+			bytes += int64(len(v))
+		case Number:
+			switch n := v.num.(type) {
+			case *big.Int:
+				bytes += int64(len(n.Bits())) * 8
+			case *big.Rat:
+				bytes += int64(len(n.Num().Bits())+len(n.Denom().Bits())) * 8
+			}
+		case *Pair:
+			if !isArgument(v, args) {
+				bytes += pairBytes
+				result = v.Cdr
+				continue
+			}
+		}
+		return ev.charge(bytes)
+	}
+}
+
+func isArgument(pair *Pair, args []Value) bool {
+	for _, arg := range args {
+		if argPair, ok := arg.(*Pair); ok && argPair == pair {
+			return true
+		}
+	}
+	return false
+}
+
+// popArgs drops the arguments pushed on argStack since it had length base.
+func (ev *evaluation) popArgs(base int) {
+	clear(ev.argStack[base:])
+	ev.argStack = ev.argStack[:base]
+}
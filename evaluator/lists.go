@@ -0,0 +1,226 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"simlang/types"
+)
+
+// datumToValue turns a quoted datum into the value it denotes.
+func datumToValue(datum types.ASTNode) Value {
+	switch v := datum.(type) {
+	case *types.SymbolNode:
+		return Symbol(v.Name)
+	case *types.NumberNode:
+		return numberFromLiteral(v)
+	case *types.StringNode:
+		return String(v.Value)
+	case *types.BoolNode:
+		return Bool(v.Value)
+	case *types.ListNode:
+		var tail Value = Nil{}
+		if v.Tail != nil {
+			tail = datumToValue(v.Tail)
+		}
+		elements := make([]Value, len(v.Elements))
+		for i, element := range v.Elements {
+			elements[i] = datumToValue(element)
+		}
+		return sliceToList(elements, tail)
+	default:
+		panic(fmt.Sprintf("datumToValue: %T is not a datum", datum))
+	}
+}
+
+// sliceToList builds a list of values ending in tail, which is Nil for a
+// proper list.
+func sliceToList(values []Value, tail Value) Value {
+	list := tail
+	for i := len(values) - 1; i >= 0; i-- {
+		list = &Pair{Car: values[i], Cdr: list}
+	}
+	return list
+}
+
+// listToSlice returns the elements of a proper list, or ok=false for any
+// other value.
+func listToSlice(list Value) (values []Value, ok bool) {
+	for {
+		switch v := list.(type) {
+		case Nil:
+			return values, true
+		case *Pair:
+			values = append(values, v.Car)
+			list = v.Cdr
+		default:
+			return nil, false
+		}
+	}
+}
+
+// listArg checks that args[i] is a proper list.
+func listArg(name string, args []Value, i int) ([]Value, error) {
+	values, ok := listToSlice(args[i])
+	if !ok {
+		return nil, fmt.Errorf("%s expects a list but got %s", name, args[i])
+	}
+	return values, nil
+}
+
+func pairArg(name string, args []Value) (*Pair, error) {
+	pair, ok := args[0].(*Pair)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a pair but got %s", name, args[0])
+	}
+	return pair, nil
+}
+
+func cons(args []Value) (Value, error) {
+	return &Pair{Car: args[0], Cdr: args[1]}, nil
+}
+
+func car(args []Value) (Value, error) {
+	pair, err := pairArg("car", args)
+	if err != nil {
+		return nil, err
+	}
+	return pair.Car, nil
+}
+
+func cdr(args []Value) (Value, error) {
+	pair, err := pairArg("cdr", args)
+	if err != nil {
+		return nil, err
+	}
+	return pair.Cdr, nil
+}
+
+func list(args []Value) (Value, error) {
+	return sliceToList(args, Nil{}), nil
+}
+
+func isNullPredicate(args []Value) (Value, error) {
+	_, ok := args[0].(Nil)
+	return Bool(ok), nil
+}
+
+func isPairPredicate(args []Value) (Value, error) {
+	_, ok := args[0].(*Pair)
+	return Bool(ok), nil
+}
+
+func length(args []Value) (Value, error) {
+	values, err := listArg("length", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Number{int64(len(values))}, nil
+}
+
+// (append list ... tail) copies every list but the last, which becomes the
+// tail of the result and may be any value.
+func appendLists(args []Value) (Value, error) {
+	if len(args) == 0 {
+		return Nil{}, nil
+	}
+	var values []Value
+	for i := range args[:len(args)-1] {
+		list, err := listArg("append", args, i)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, list...)
+	}
+	return sliceToList(values, args[len(args)-1]), nil
+}
+
+func reverse(args []Value) (Value, error) {
+	values, err := listArg("reverse", args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var result Value = Nil{}
+	for _, value := range values {
+		result = &Pair{Car: value, Cdr: result}
+	}
+	return result, nil
+}
+
+// listsArgs checks that args[from:] are all proper lists and returns them
+// cut to the length of the shortest one.
+func listsArgs(name string, args []Value, from int) ([][]Value, error) {
+	lists := make([][]Value, 0, len(args)-from)
+	shortest := -1
+	for i := from; i < len(args); i++ {
+		values, err := listArg(name, args, i)
+		if err != nil {
+			return nil, err
+		}
+		if shortest == -1 || len(values) < shortest {
+			shortest = len(values)
+		}
+		lists = append(lists, values)
+	}
+	for i := range lists {
+		lists[i] = lists[i][:shortest]
+	}
+	return lists, nil
+}
+
+// (map f list ...) calls f with the i-th element of every list, up to the
+// end of the shortest list.
+func mapLists(ev *evaluation, args []Value) (Value, error) {
+	lists, err := listsArgs("map", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Value, len(lists[0]))
+	for i := range results {
+		callArgs := make([]Value, len(lists))
+		for j, values := range lists {
+			callArgs[j] = values[i]
+		}
+		if results[i], err = ev.apply(args[0], callArgs); err != nil {
+			return nil, err
+		}
+	}
+	return sliceToList(results, Nil{}), nil
+}
+
+func filter(ev *evaluation, args []Value) (Value, error) {
+	values, err := listArg("filter", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	var kept []Value
+	for _, value := range values {
+		keep, err := ev.apply(args[0], []Value{value})
+		if err != nil {
+			return nil, err
+		}
+		if isTruthy(keep) {
+			kept = append(kept, value)
+		}
+	}
+	return sliceToList(kept, Nil{}), nil
+}
+
+// (fold kons knil list ...) calls (kons element ... accumulator) from the
+// left, starting with knil, e.g. (fold cons '() lst) reverses lst.
+func fold(ev *evaluation, args []Value) (Value, error) {
+	lists, err := listsArgs("fold", args, 2)
+	if err != nil {
+		return nil, err
+	}
+	accumulator := args[1]
+	for i := range lists[0] {
+		callArgs := make([]Value, 0, len(lists)+1)
+		for _, values := range lists {
+			callArgs = append(callArgs, values[i])
+		}
+		if accumulator, err = ev.apply(args[0], append(callArgs, accumulator)); err != nil {
+			return nil, err
+		}
+	}
+	return accumulator, nil
+}
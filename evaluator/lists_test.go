@@ -0,0 +1,41 @@
+package evaluator
+
+import "testing"
+
+// TestPairsAndQuote checks quoted data, pairs and the list builtins.
+func TestPairsAndQuote(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "quoted symbol", src: `'a`, want: "a"},
+		{name: "quoted list", src: `'(1 2 3)`, want: "(1 2 3)"},
+		{name: "quoted dotted pair", src: `'(a . b)`, want: "(a . b)"},
+		{name: "dotted list prints as a list", src: `'(1 . (2 . (3 . ())))`, want: "(1 2 3)"},
+		{name: "empty list", src: `'()`, want: "()"},
+		{name: "nested quote", src: `(quote (a 'b))`, want: "(a (quote b))"},
+		{name: "quoted quote", src: `''a`, want: "(quote a)"},
+		{name: "quoted atoms", src: `'(1 "a" #f 1/2)`, want: `(1 "a" #f 1/2)`},
+		{name: "cons makes a pair", src: `(cons 1 2)`, want: "(1 . 2)"},
+		{name: "cons onto a list", src: `(cons 1 '(2 3))`, want: "(1 2 3)"},
+		{name: "car", src: `(car '(1 2))`, want: "1"},
+		{name: "cdr", src: `(cdr '(1 2))`, want: "(2)"},
+		{name: "cdr of a single element list", src: `(cdr '(1))`, want: "()"},
+		{name: "car of the empty list", src: `(car '())`, wantErr: "car expects a pair but got ()"},
+		{name: "cdr of a number", src: `(cdr 5)`, wantErr: "cdr expects a pair but got 5"},
+		{name: "list evaluates its arguments", src: `(list 1 (+ 1 1) 'c)`, want: "(1 2 c)"},
+		{name: "empty list call", src: `(list)`, want: "()"},
+		{name: "null?", src: `(null? '())`, want: "#t"},
+		{name: "null? of a list", src: `(null? '(1))`, want: "#f"},
+		{name: "pair?", src: `(pair? '(1))`, want: "#t"},
+		{name: "the empty list is not a pair", src: `(pair? '())`, want: "#f"},
+		{name: "length", src: `(length '(1 2 3))`, want: "3"},
+		{name: "length of an improper list", src: `(length (cons 1 2))`, wantErr: "length expects a list but got (1 . 2)"},
+		{name: "append", src: `(append '(1) '(2 3) '())`, want: "(1 2 3)"},
+		{name: "append an atom", src: `(append '(1) 2)`, want: "(1 . 2)"},
+		{name: "append nothing", src: `(append)`, want: "()"},
+		{name: "reverse", src: `(reverse '(1 2 3))`, want: "(3 2 1)"},
+		{name: "map", src: `(map (lambda (x) (* x x)) '(1 2 3))`, want: "(1 4 9)"},
+		{name: "map stops at the shortest list", src: `(map + '(1 2) '(10 20 30))`, want: "(11 22)"},
+		{name: "filter", src: `(filter odd? '(1 2 3 4 5))`, want: "(1 3 5)"},
+		{name: "fold", src: `(fold + 0 '(1 2 3))`, want: "6"},
+		{name: "fold passes the element first", src: `(fold cons '() '(1 2 3))`, want: "(3 2 1)"},
+	})
+}
@@ -0,0 +1,582 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"simlang/parser"
+	"simlang/types"
+)
+
+// Macros are expanded before a form is evaluated: each call whose head names
+// a macro is replaced by the code the macro computes from its unevaluated
+// arguments, and the result is expanded again until no macro uses are left.
+// defmacro and define-syntax take effect when they are evaluated, so a macro
+// can be used by the forms after its definition but not by the form defining
+// it. A local binding of a macro's name hides the macro.
+
+// expandAndEval expands the macros in form and evaluates the result.
+func (ev *evaluation) expandAndEval(form types.ASTNode, env *Env) (Value, error) {
+	expanded, err := ev.expand(form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand: %w", err)
+	}
+	result, err := ev.eval(expanded, env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval: %w", err)
+	}
+	return result, nil
+}
+
+// macro turns a use of a macro keyword into the code that replaces it.
+type macro interface {
+	transform(x *expansion, call *types.CallNode, sc *scope) (types.ASTNode, error)
+}
+
+// procedureMacro is a macro defined with defmacro: a procedure from the
+// arguments of a use, as data, to the code that replaces it.
+type procedureMacro struct {
+	procedure *Closure
+}
+
+func (m *procedureMacro) transform(x *expansion, call *types.CallNode, sc *scope) (types.ASTNode, error) {
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = datumToValue(parser.Unparse(arg))
+	}
+	result, err := x.ev.apply(m.procedure, args)
+	if err != nil {
+		return nil, err
+	}
+	datum, err := valueToDatum(result, call.Span)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseDatum(datum)
+}
+
+// expansion is the state of expanding one form.
+//
+// Hygiene works by renaming: each symbol a syntax-rules template introduces
+// becomes an alias, a name that cannot be written in source, so the expander
+// can tell it from the symbols of the macro use. Every symbol in code is
+// resolved to the local binding it refers to, if any, by its exact name, so
+// an alias only refers to bindings made by the same template, and a free alias
+// refers to the global of its original name. Once the whole form is expanded,
+// finish names every local binding: bindings made by templates, and bindings
+// that would hide a global a template refers to, get fresh names.
+type expansion struct {
+	ev      *evaluation
+	aliases map[string]string // alias -> the name it was made from
+	// globals are the names of the globals referred to by free aliases.
+	globals map[string]bool
+	uses    []symbolUse
+}
+
+// scope holds the names bound by one lambda, let or defmacro.
+type scope struct {
+	bindings map[string]*binding
+	parent   *scope
+}
+
+// binding is a local variable of the form being expanded.
+type binding struct {
+	name       string // without aliases
+	introduced bool   // bound by an alias
+	final      string // set by finish
+}
+
+// symbolUse is a symbol in the expanded code, and the local binding it binds
+// or refers to, or nil for a global.
+type symbolUse struct {
+	symbol  *types.SymbolNode
+	binding *binding
+}
+
+// expand returns node with every macro use in it expanded. node itself is
+// never modified.
+func (ev *evaluation) expand(node types.ASTNode) (types.ASTNode, error) {
+	x := &expansion{ev: ev, aliases: make(map[string]string), globals: make(map[string]bool)}
+	expanded, err := x.expand(node, nil)
+	if err != nil {
+		return nil, err
+	}
+	x.finish()
+	return expanded, nil
+}
+
+func (x *expansion) expand(node types.ASTNode, sc *scope) (types.ASTNode, error) {
+	if err := x.ev.enter(); err != nil {
+		return nil, errorAt(node, err)
+	}
+	defer x.ev.leave()
+
+	// a loop rather than recursion, so that an endless expansion runs into
+	// MaxSteps instead of growing the stack
+	for {
+		expansion, ok, err := x.transform(node, sc)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		node = expansion
+	}
+
+	var err error
+	switch v := node.(type) {
+	case *types.SymbolNode:
+		return x.reference(v, sc), nil
+	case *types.CallNode:
+		expanded := *v
+		if expanded.Function, err = x.expand(v.Function, sc); err != nil {
+			return nil, err
+		}
+		if expanded.Args, err = x.expandAll(v.Args, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.LetNode:
+		// let values are in the outer scope, let* values see the bindings
+		// before them and letrec values see all of them
+		expanded := *v
+		expanded.Bindings = make([]types.LetBinding, len(v.Bindings))
+		letScope := sc.extend()
+		if v.Kind == types.LETREC {
+			for i, binding := range v.Bindings {
+				expanded.Bindings[i].Name = x.bind(binding.Name, letScope)
+			}
+		}
+		for i, binding := range v.Bindings {
+			valueScope := letScope
+			if v.Kind == types.LET {
+				valueScope = sc
+			}
+			if expanded.Bindings[i].Value, err = x.expand(binding.Value, valueScope); err != nil {
+				return nil, err
+			}
+			if v.Kind != types.LETREC {
+				expanded.Bindings[i].Name = x.bind(binding.Name, letScope)
+			}
+		}
+		if expanded.Body, err = x.expand(v.Body, letScope); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.IfNode:
+		expanded := *v
+		if expanded.Cond, err = x.expand(v.Cond, sc); err != nil {
+			return nil, err
+		}
+		if expanded.Then, err = x.expand(v.Then, sc); err != nil {
+			return nil, err
+		}
+		if expanded.Else, err = x.expandOptional(v.Else, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.CondNode:
+		expanded := *v
+		expanded.Clauses = make([]types.CondClause, len(v.Clauses))
+		for i, clause := range v.Clauses {
+			if expanded.Clauses[i].Test, err = x.expand(clause.Test, sc); err != nil {
+				return nil, err
+			}
+			if expanded.Clauses[i].Body, err = x.expand(clause.Body, sc); err != nil {
+				return nil, err
+			}
+		}
+		if expanded.Else, err = x.expandOptional(v.Else, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.LogicalNode:
+		expanded := *v
+		if expanded.Args, err = x.expandAll(v.Args, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.DefineNode:
+		// define assigns a local it names, and otherwise makes a global
+		expanded := *v
+		expanded.Name = x.reference(v.Name, sc)
+		if expanded.Value, err = x.expand(v.Value, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.SetNode:
+		expanded := *v
+		expanded.Name = x.reference(v.Name, sc)
+		if expanded.Value, err = x.expand(v.Value, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.BeginNode:
+		expanded := *v
+		if expanded.Body, err = x.expandAll(v.Body, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.LambdaNode:
+		expanded := *v
+		expanded.Args, expanded.Body, err = x.expandProcedure(v.Args, v.Body, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.DefmacroNode:
+		expanded := *v
+		expanded.Name = x.reference(v.Name, nil)
+		expanded.Params, expanded.Body, err = x.expandProcedure(v.Params, v.Body, sc)
+		if err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.DefineSyntaxNode:
+		expanded := *v
+		expanded.Name = x.reference(v.Name, nil)
+		expanded.Literals = make([]*types.SymbolNode, len(v.Literals))
+		for i, literal := range v.Literals {
+			expanded.Literals[i] = x.data(literal).(*types.SymbolNode)
+		}
+		expanded.Rules = make([]types.SyntaxRule, len(v.Rules))
+		for i, rule := range v.Rules {
+			expanded.Rules[i] = types.SyntaxRule{Pattern: x.data(rule.Pattern), Template: x.data(rule.Template)}
+		}
+		return &expanded, nil
+	case *types.QuoteNode:
+		expanded := *v
+		expanded.Datum = x.data(v.Datum)
+		return &expanded, nil
+	case *types.QuasiquoteNode:
+		expanded := *v
+		if expanded.Template, err = x.template(v.Template, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	default:
+		return node, nil
+	}
+}
+
+// transform replaces node once if it is a macro use. ok is false otherwise.
+func (x *expansion) transform(node types.ASTNode, sc *scope) (expansion types.ASTNode, ok bool, err error) {
+	call, ok := node.(*types.CallNode)
+	if !ok {
+		return nil, false, nil
+	}
+	symbol, ok := call.Function.(*types.SymbolNode)
+	if !ok || sc.lookup(symbol.Name) != nil {
+		return nil, false, nil
+	}
+	name := x.original(symbol.Name)
+	macro, ok := x.ev.macros[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err := x.ev.step(); err != nil {
+		return nil, false, errorAt(call, err)
+	}
+	if expansion, err = macro.transform(x, call, sc); err != nil {
+		return nil, false, errorAt(call, fmt.Errorf("failed to expand %s: %w", name, err))
+	}
+	return expansion, true, nil
+}
+
+func (x *expansion) expandAll(nodes []types.ASTNode, sc *scope) ([]types.ASTNode, error) {
+	expanded := make([]types.ASTNode, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if expanded[i], err = x.expand(node, sc); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+func (x *expansion) expandOptional(node types.ASTNode, sc *scope) (types.ASTNode, error) {
+	if node == nil {
+		return nil, nil
+	}
+	return x.expand(node, sc)
+}
+
+// expandProcedure expands the body of a lambda or defmacro in a scope binding
+// its params.
+func (x *expansion) expandProcedure(params []*types.SymbolNode, body types.ASTNode, sc *scope) ([]*types.SymbolNode, types.ASTNode, error) {
+	procedureScope := sc.extend()
+	bound := make([]*types.SymbolNode, len(params))
+	for i, param := range params {
+		bound[i] = x.bind(param, procedureScope)
+	}
+	expanded, err := x.expand(body, procedureScope)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bound, expanded, nil
+}
+
+// template expands the unquotes of a quasiquote template.
+func (x *expansion) template(node types.ASTNode, sc *scope) (types.ASTNode, error) {
+	var err error
+	switch v := node.(type) {
+	case *types.UnquoteNode:
+		expanded := *v
+		if expanded.Expr, err = x.expand(v.Expr, sc); err != nil {
+			return nil, err
+		}
+		return &expanded, nil
+	case *types.ListNode:
+		expanded := *v
+		expanded.Elements = make([]types.ASTNode, len(v.Elements))
+		for i, element := range v.Elements {
+			if expanded.Elements[i], err = x.template(element, sc); err != nil {
+				return nil, err
+			}
+		}
+		if v.Tail != nil {
+			if expanded.Tail, err = x.template(v.Tail, sc); err != nil {
+				return nil, err
+			}
+		}
+		return &expanded, nil
+	default:
+		return x.data(node), nil
+	}
+}
+
+// data returns a copy of datum with aliases turned back into the names they
+// were made from, so that a quoted symbol reads the same wherever it is.
+func (x *expansion) data(datum types.ASTNode) types.ASTNode {
+	switch v := datum.(type) {
+	case *types.SymbolNode:
+		return &types.SymbolNode{Span: v.Span, Name: x.original(v.Name)}
+	case *types.ListNode:
+		copied := &types.ListNode{Span: v.Span, Elements: make([]types.ASTNode, len(v.Elements))}
+		for i, element := range v.Elements {
+			copied.Elements[i] = x.data(element)
+		}
+		if v.Tail != nil {
+			copied.Tail = x.data(v.Tail)
+		}
+		return copied
+	default:
+		return datum
+	}
+}
+
+// alias returns a new alias for name.
+func (x *expansion) alias(name string) string {
+	// a comma ends a symbol in source, so no symbol written there is an alias
+	alias := fmt.Sprintf("%s,%d", name, len(x.aliases)+1)
+	x.aliases[alias] = name
+	return alias
+}
+
+// original returns the name the alias name was made from, or name itself if
+// it is not an alias.
+func (x *expansion) original(name string) string {
+	if original, ok := x.aliases[name]; ok {
+		return original
+	}
+	return name
+}
+
+// bind adds a binding for symbol to sc and returns the copy of symbol that
+// makes it.
+func (x *expansion) bind(symbol *types.SymbolNode, sc *scope) *types.SymbolNode {
+	_, introduced := x.aliases[symbol.Name]
+	b := &binding{name: x.original(symbol.Name), introduced: introduced}
+	sc.bindings[symbol.Name] = b
+	return x.use(symbol, b)
+}
+
+// reference returns a copy of symbol that refers to whatever it names in sc.
+func (x *expansion) reference(symbol *types.SymbolNode, sc *scope) *types.SymbolNode {
+	b := sc.lookup(symbol.Name)
+	if b == nil {
+		if original, ok := x.aliases[symbol.Name]; ok {
+			x.globals[original] = true
+		}
+	}
+	return x.use(symbol, b)
+}
+
+func (x *expansion) use(symbol *types.SymbolNode, b *binding) *types.SymbolNode {
+	copied := *symbol
+	x.uses = append(x.uses, symbolUse{symbol: &copied, binding: b})
+	return &copied
+}
+
+// finish gives every symbol of the expanded code its final name.
+func (x *expansion) finish() {
+	taken := make(map[string]bool)
+	for _, use := range x.uses {
+		if use.binding != nil {
+			taken[use.binding.name] = true
+		} else {
+			taken[x.original(use.symbol.Name)] = true
+		}
+	}
+
+	for _, use := range x.uses {
+		b := use.binding
+		if b == nil {
+			use.symbol.Name = x.original(use.symbol.Name)
+			continue
+		}
+		if b.final == "" {
+			b.final = b.name
+			if b.introduced || x.globals[b.name] {
+				b.final = freshName(b.name, taken)
+			}
+		}
+		use.symbol.Name = b.final
+	}
+}
+
+// freshName returns name.1, name.2 or the first such name not yet taken, and
+// takes it.
+func freshName(name string, taken map[string]bool) string {
+	for i := 1; ; i++ {
+		fresh := fmt.Sprintf("%s.%d", name, i)
+		if !taken[fresh] {
+			taken[fresh] = true
+			return fresh
+		}
+	}
+}
+
+func (sc *scope) extend() *scope {
+	return &scope{bindings: make(map[string]*binding), parent: sc}
+}
+
+// lookup returns the binding name refers to in sc, or nil for a global.
+func (sc *scope) lookup(name string) *binding {
+	for s := sc; s != nil; s = s.parent {
+		if b, ok := s.bindings[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// valueToDatum is the inverse of datumToValue. Values that cannot be written
+// in source, like procedures, are an error. Every node gets span.
+func valueToDatum(value Value, span types.Span) (types.ASTNode, error) {
+	switch v := value.(type) {
+	case Number:
+		if f, ok := v.num.(float64); ok {
+			return &types.NumberNode{Span: span, Value: f}, nil
+		}
+		return &types.NumberNode{Span: span, Value: toFloat(v.num), Exact: toRat(v.num)}, nil
+	case String:
+		return &types.StringNode{Span: span, Value: string(v)}, nil
+	case Bool:
+		return &types.BoolNode{Span: span, Value: bool(v)}, nil
+	case Symbol:
+		return &types.SymbolNode{Span: span, Name: string(v)}, nil
+	case Nil:
+		return &types.ListNode{Span: span}, nil
+	case *Pair:
+		list := &types.ListNode{Span: span}
+		var rest Value = v
+		for {
+			pair, ok := rest.(*Pair)
+			if !ok {
+				break
+			}
+			element, err := valueToDatum(pair.Car, span)
+			if err != nil {
+				return nil, err
+			}
+			list.Elements = append(list.Elements, element)
+			rest = pair.Cdr
+		}
+		if _, ok := rest.(Nil); !ok {
+			tail, err := valueToDatum(rest, span)
+			if err != nil {
+				return nil, err
+			}
+			list.Tail = tail
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("cannot use %s as code", value)
+	}
+}
+
+// quasiquote builds the value of a quasiquote template, evaluating its
+// unquotes in env.
+func (ev *evaluation) quasiquote(template types.ASTNode, env *Env) (Value, error) {
+	switch v := template.(type) {
+	case *types.UnquoteNode:
+		if v.Splicing {
+			return nil, errorAt(v, errors.New("unquote-splicing is only allowed in a list"))
+		}
+		return ev.eval(v.Expr, env)
+	case *types.ListNode:
+		values := make([]Value, 0, len(v.Elements))
+		for _, element := range v.Elements {
+			if unquote, ok := element.(*types.UnquoteNode); ok && unquote.Splicing {
+				spliced, err := ev.eval(unquote.Expr, env)
+				if err != nil {
+					return nil, err
+				}
+				list, ok := listToSlice(spliced)
+				if !ok {
+					return nil, errorAt(unquote, fmt.Errorf("unquote-splicing expects a list but got %s", spliced))
+				}
+				values = append(values, list...)
+				continue
+			}
+			value, err := ev.quasiquote(element, env)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		var tail Value = Nil{}
+		if v.Tail != nil {
+			var err error
+			if tail, err = ev.quasiquote(v.Tail, env); err != nil {
+				return nil, err
+			}
+		}
+		if err := ev.charge(pairBytes * int64(len(values))); err != nil {
+			return nil, errorAt(v, err)
+		}
+		return sliceToList(values, tail), nil
+	default:
+		value := datumToValue(template)
+		if err := ev.chargeResult(value, nil); err != nil {
+			return nil, errorAt(template, err)
+		}
+		return value, nil
+	}
+}
+
+// (macroexpand 'form) returns form with every macro use in it expanded.
+func macroexpand(ev *evaluation, args []Value) (Value, error) {
+	datum, err := valueToDatum(args[0], ev.site)
+	if err != nil {
+		return nil, err
+	}
+	code, err := parser.ParseDatum(datum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s as code: %w", args[0], err)
+	}
+	expanded, err := ev.expand(code)
+	if err != nil {
+		return nil, err
+	}
+	return datumToValue(parser.Unparse(expanded)), nil
+}
+
+// Quote returns code as the list it is written as, e.g. to print the
+// expansion of a macro use.
+func Quote(node types.ASTNode) Value {
+	return datumToValue(parser.Unparse(node))
+}
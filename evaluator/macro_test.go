@@ -0,0 +1,41 @@
+package evaluator
+
+import "testing"
+
+// TestDefmacro checks quasiquote templates and defmacro expansion. Unlike
+// syntax-rules, defmacro is not hygienic.
+func TestDefmacro(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "quasiquote without unquotes", src: "`(1 2 3)", want: "(1 2 3)"},
+		{name: "quasiquoted symbol", src: "`a", want: "a"},
+		{name: "unquote", src: "(define x 5) `(a ,x)", want: "(a 5)"},
+		{name: "unquote alone", src: "(define x 1) `,x", want: "1"},
+		{name: "unquote-splicing", src: "(define xs '(1 2)) `(a ,@xs b)", want: "(a 1 2 b)"},
+		{name: "splicing nothing", src: "`(a ,@'() b)", want: "(a b)"},
+		{name: "mixed unquotes", src: "`(1 ,(+ 1 1) ,@(list 3 4))", want: "(1 2 3 4)"},
+		{name: "unquote in a dotted tail", src: "`(a . ,(+ 1 2))", want: "(a . 3)"},
+		{name: "splicing before a dotted tail", src: "`(,@'(1 2) . 3)", want: "(1 2 . 3)"},
+		{name: "nested quasiquote", src: "`(a `(b ,(c ,(+ 1 2))))", want: "(a (quasiquote (b (unquote (c 3)))))"},
+		{name: "splicing a non-list", src: "`(a ,@5)", wantErr: "unquote-splicing expects a list but got 5"},
+		{name: "macro", src: "(defmacro unless (c body) `(if ,c #f ,body)) (unless #f 'ran)", want: "ran"},
+		{
+			name: "macro arguments are not evaluated",
+			src:  "(defmacro swap (a b) `(let ((tmp ,a)) in (begin (set! ,a ,b) (set! ,b tmp)))) (define p 1) (define q 2) (swap p q) (list p q)",
+			want: "(2 1)",
+		},
+		{name: "arguments are evaluated where they appear", src: "(defmacro twice (e) `(begin ,e ,e)) (define n 0) (twice (set! n (+ n 1))) n", want: "2"},
+		{name: "defmacro captures names", src: "(defmacro my-or (a b) `(let ((t ,a)) in (if t t ,b))) (define t 5) (my-or #f t)", want: "#f"},
+		{name: "macro inside a procedure", src: "(defmacro m (x) `(+ ,x 1)) (define (f y) (m y)) (f 2)", want: "3"},
+		{
+			name: "macro defining a loop",
+			src: "(defmacro while (c body) `(letrec ((loop (lambda () (if ,c (begin ,body (loop)) #f)))) in (loop)))" +
+				" (define i 0) (while (< i 5) (set! i (+ i 1))) i",
+			want: "5",
+		},
+		{name: "macroexpand", src: "(defmacro m (x) `(+ ,x 1)) (macroexpand '(m 2))", want: "(+ 2 1)"},
+		{name: "macros apply after their definition", src: "(define (f) (m 1)) (defmacro m (x) x) (f)", wantErr: "unbound variable `m`"},
+		{name: "too few macro arguments", src: "(defmacro m (x) x) (m)", wantErr: "failed to expand m: #<procedure m> expects 1 argument, got 0"},
+		{name: "too many macro arguments", src: "(defmacro m (x) x) (m 1 2)", wantErr: "expects 1 argument, got 2"},
+		{name: "error in a macro body", src: "(defmacro m (x) (car 5)) (m 1)", wantErr: "car expects a pair but got 5"},
+	})
+}
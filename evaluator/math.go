@@ -0,0 +1,317 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// maxExptBits bounds the size of exact expt results so a typo like
+// (expt 10 1000000000) fails instead of exhausting memory.
+const maxExptBits = 1 << 24
+
+// errDomain is what math functions give for arguments outside their domain,
+// like (sqrt -4) or (log -1), the same error as in tcllike expressions.
+var errDomain = errors.New("domain error: argument not in valid range")
+
+// checkDomain rejects the NaN a math function gives for arguments it is not
+// defined for. As in Tcl, infinities like the -inf.0 of (log 0) are results
+// like any other, and so is the NaN that a NaN argument gives.
+func checkDomain(result float64, args ...Number) (Value, error) {
+	if !math.IsNaN(result) {
+		return Number{result}, nil
+	}
+	for _, arg := range args {
+		if f, ok := arg.num.(float64); ok && math.IsNaN(f) {
+			return Number{result}, nil
+		}
+	}
+	return nil, errDomain
+}
+
+func isInteger(num Number) bool {
+	switch v := num.num.(type) {
+	case int64, *big.Int:
+		return true
+	case float64:
+		return !math.IsInf(v, 0) && v == math.Trunc(v)
+	default:
+		return false
+	}
+}
+
+// integerArgs checks that every argument is an integer, exact or not.
+func integerArgs(name string, args []Value) ([]Number, error) {
+	nums, err := numberArgs(name, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, num := range nums {
+		if !isInteger(num) {
+			return nil, fmt.Errorf("%s expects integers but got %s", name, num)
+		}
+	}
+	return nums, nil
+}
+
+// sign returns -1, 0 or 1, or ok=false for NaN.
+func sign(num Number) (int, bool) {
+	return compareNumbers(num.num, int64(0))
+}
+
+// integerDivision builds quotient, remainder and modulo. Exact arguments give
+// an exact result; an inexact integer like 4.0 makes the result inexact.
+func integerDivision(name string, exact func(a, b *big.Int) *big.Int, inexact func(a, b float64) float64) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		nums, err := integerArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if c, _ := sign(nums[1]); c == 0 {
+			return nil, errDivisionByZero
+		}
+		if isExact(nums[0].num) && isExact(nums[1].num) {
+			return Number{normalizeInt(exact(toBigInt(nums[0].num), toBigInt(nums[1].num)))}, nil
+		}
+		return Number{inexact(toFloat(nums[0].num), toFloat(nums[1].num))}, nil
+	}
+}
+
+// quotient truncates towards zero.
+func quotientExact(a, b *big.Int) *big.Int {
+	return new(big.Int).Quo(a, b)
+}
+
+func quotientInexact(a, b float64) float64 {
+	return (a - math.Mod(a, b)) / b
+}
+
+// remainder has the sign of the dividend.
+func remainderExact(a, b *big.Int) *big.Int {
+	return new(big.Int).Rem(a, b)
+}
+
+func remainderInexact(a, b float64) float64 {
+	return math.Mod(a, b)
+}
+
+// modulo has the sign of the divisor.
+func moduloExact(a, b *big.Int) *big.Int {
+	m := new(big.Int).Rem(a, b)
+	if m.Sign() != 0 && m.Sign() != b.Sign() {
+		m.Add(m, b)
+	}
+	return m
+}
+
+func moduloInexact(a, b float64) float64 {
+	m := math.Mod(a, b)
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return m
+}
+
+func abs(args []Value) (Value, error) {
+	num, err := numberArg("abs", args)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := sign(num); ok && c < 0 {
+		return subOp.apply(Number{int64(0)}, num), nil
+	}
+	return num, nil
+}
+
+// extremum builds min (want -1) and max (want 1). Like the other operations
+// the result is inexact if any argument is.
+func extremum(name string, want int) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		nums, err := numberArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		result, exact := nums[0], isExact(nums[0].num)
+		for _, num := range nums[1:] {
+			exact = exact && isExact(num.num)
+			c, ok := compareNumbers(num.num, result.num)
+			if !ok {
+				return Number{math.NaN()}, nil
+			}
+			if c == want {
+				result = num
+			}
+		}
+		if !exact {
+			return Number{toFloat(result.num)}, nil
+		}
+		return result, nil
+	}
+}
+
+// expt is exact when the base is exact and the exponent an exact integer,
+// e.g. (expt 2 100) or (expt 2/3 -2).
+func expt(args []Value) (Value, error) {
+	nums, err := numberArgs("expt", args)
+	if err != nil {
+		return nil, err
+	}
+	base, exponent := nums[0], nums[1]
+	if !isExact(base.num) || !isExact(exponent.num) || !isInteger(exponent) {
+		return checkDomain(math.Pow(toFloat(base.num), toFloat(exponent.num)), base, exponent)
+	}
+
+	r := toRat(base.num)
+	n := toBigInt(exponent.num)
+	if n.Sign() == 0 {
+		return Number{int64(1)}, nil
+	}
+	negative := n.Sign() < 0
+	if negative && r.Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+	// 0, 1 and -1 stay small whatever the exponent
+	if r.Sign() == 0 || r.IsInt() && r.Num().CmpAbs(big.NewInt(1)) == 0 {
+		if r.Sign() < 0 && n.Bit(0) == 0 {
+			return Number{int64(1)}, nil
+		}
+		return base, nil
+	}
+
+	e := new(big.Int).Abs(n)
+	bits := max(r.Num().BitLen(), r.Denom().BitLen())
+	if !e.IsInt64() || e.Int64() > maxExptBits/int64(bits) {
+		return nil, fmt.Errorf("expt result is too large: %s^%s", base, exponent)
+	}
+	num := new(big.Int).Exp(r.Num(), e, nil)
+	denom := new(big.Int).Exp(r.Denom(), e, nil)
+	if negative {
+		num, denom = denom, num
+	}
+	return Number{normalizeRat(new(big.Rat).SetFrac(num, denom))}, nil
+}
+
+// sqrt is exact for exact perfect squares, e.g. (sqrt 16) or (sqrt 1/4).
+func sqrt(args []Value) (Value, error) {
+	num, err := numberArg("sqrt", args)
+	if err != nil {
+		return nil, err
+	}
+	if isExact(num.num) && toRat(num.num).Sign() >= 0 {
+		r := toRat(num.num)
+		numRoot := new(big.Int).Sqrt(r.Num())
+		denomRoot := new(big.Int).Sqrt(r.Denom())
+		if new(big.Int).Mul(numRoot, numRoot).Cmp(r.Num()) == 0 && new(big.Int).Mul(denomRoot, denomRoot).Cmp(r.Denom()) == 0 {
+			return Number{normalizeRat(new(big.Rat).SetFrac(numRoot, denomRoot))}, nil
+		}
+	}
+	return checkDomain(math.Sqrt(toFloat(num.num)), num)
+}
+
+// floatFunction builds a one-argument builtin with an inexact result, which
+// fails with errDomain outside the domain of f.
+func floatFunction(name string, f func(float64) float64) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		num, err := numberArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return checkDomain(f(toFloat(num.num)), num)
+	}
+}
+
+// (log z) is the natural logarithm, (log z base) the logarithm in base.
+func logarithm(args []Value) (Value, error) {
+	nums, err := numberArgs("log", args)
+	if err != nil {
+		return nil, err
+	}
+	result := math.Log(toFloat(nums[0].num))
+	if len(nums) == 2 {
+		result /= math.Log(toFloat(nums[1].num))
+	}
+	return checkDomain(result, nums...)
+}
+
+// rounding builds floor, ceiling, round and truncate. Integers are returned
+// unchanged, rationals become exact integers and floats stay inexact.
+func rounding(name string, exact func(r *big.Rat) *big.Int, inexact func(float64) float64) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		num, err := numberArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		switch v := num.num.(type) {
+		case *big.Rat:
+			return Number{normalizeInt(exact(v))}, nil
+		case float64:
+			return Number{inexact(v)}, nil
+		default:
+			return num, nil
+		}
+	}
+}
+
+// floorRat relies on big.Int.Div being Euclidean and rational denominators
+// being positive.
+func floorRat(r *big.Rat) *big.Int {
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+func ceilingRat(r *big.Rat) *big.Int {
+	return new(big.Int).Neg(floorRat(new(big.Rat).Neg(r)))
+}
+
+func truncateRat(r *big.Rat) *big.Int {
+	return new(big.Int).Quo(r.Num(), r.Denom())
+}
+
+// roundRat rounds to the nearest integer, ties to even like Scheme's round.
+func roundRat(r *big.Rat) *big.Int {
+	shifted := new(big.Rat).Add(r, big.NewRat(1, 2))
+	rounded := floorRat(shifted)
+	if shifted.IsInt() && rounded.Bit(0) == 1 {
+		rounded.Sub(rounded, big.NewInt(1))
+	}
+	return rounded
+}
+
+func isNumberPredicate(args []Value) (Value, error) {
+	_, ok := args[0].(Number)
+	return Bool(ok), nil
+}
+
+func isIntegerPredicate(args []Value) (Value, error) {
+	num, ok := args[0].(Number)
+	return Bool(ok && isInteger(num)), nil
+}
+
+// signPredicate builds zero?, positive? and negative?, which are all false
+// for NaN.
+func signPredicate(name string, holds func(c int) bool) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		num, err := numberArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := sign(num)
+		return Bool(ok && holds(c)), nil
+	}
+}
+
+func parityPredicate(name string, wantEven bool) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		nums, err := integerArgs(name, args)
+		if err != nil {
+			return nil, err
+		}
+		var even bool
+		if f, ok := nums[0].num.(float64); ok {
+			even = math.Mod(f, 2) == 0
+		} else {
+			even = toBigInt(nums[0].num).Bit(0) == 0
+		}
+		return Bool(even == wantEven), nil
+	}
+}
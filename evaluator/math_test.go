@@ -0,0 +1,85 @@
+package evaluator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestMathStdlib checks the math builtins, which keep exact arguments exact
+// where the result can be.
+func TestMathStdlib(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "abs", src: `(abs -5)`, want: "5"},
+		{name: "min", src: `(min 3 1 2)`, want: "1"},
+		{name: "max of rationals", src: `(max 1/2 1/3)`, want: "1/2"},
+		{name: "exact expt", src: `(expt 2/3 -2)`, want: "9/4"},
+		{name: "zero exponent", src: `(expt 0 0)`, want: "1"},
+		{name: "inexact expt", src: `(expt 2.0 0.5)`, want: "1.4142135623730951"},
+		{name: "exact sqrt", src: `(sqrt 1/4)`, want: "1/2"},
+		{name: "inexact sqrt", src: `(sqrt 2)`, want: "1.4142135623730951"},
+		{name: "exp", src: `(exp 0)`, want: "1.0"},
+		{name: "log", src: `(log 1)`, want: "0.0"},
+		{name: "log with a base", src: `(log 100 10)`, want: "2.0"},
+		{name: "sin", src: `(sin 0)`, want: "0.0"},
+		{name: "cos", src: `(cos 0)`, want: "1.0"},
+		{name: "tan", src: `(tan 0)`, want: "0.0"},
+		{name: "floor of a float", src: `(floor -3.5)`, want: "-4.0"},
+		{name: "ceiling of a rational", src: `(ceiling 7/2)`, want: "4"},
+		{name: "round to even", src: `(round -7/2)`, want: "-4"},
+		{name: "truncate a rational", src: `(truncate -7/2)`, want: "-3"},
+		{name: "zero?", src: `(zero? 0.0)`, want: "#t"},
+		{name: "positive?", src: `(positive? -1)`, want: "#f"},
+		{name: "negative?", src: `(negative? -1/2)`, want: "#t"},
+		{name: "odd?", src: `(odd? 3)`, want: "#t"},
+		{name: "even? of an integral float", src: `(even? 2.0)`, want: "#t"},
+		{name: "even? of a fraction", src: `(even? 1.5)`, wantErr: "even? expects integers but got 1.5"},
+		{name: "not a number", src: `(sqrt 'a)`, wantErr: "sqrt expects a number but got a"},
+		{name: "too few arguments", src: `(expt 2)`, wantErr: "expt expects 2 arguments, got 1"},
+		{name: "too many arguments", src: `(abs 1 2)`, wantErr: "abs expects 1 argument, got 2"},
+		{name: "optional argument", src: `(log)`, wantErr: "log expects 1 or 2 arguments, got 0"},
+		{name: "variadic", src: `(min)`, wantErr: "min expects at least 1 argument, got 0"},
+	})
+}
+
+// TestMathDomain checks that math functions fail with the domain error of
+// tcllike expressions for arguments outside their domain, and still give
+// infinities, and NaN for a NaN argument.
+func TestMathDomain(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{src: `(sqrt -4)`, wantErr: true},
+		{src: `(sqrt -4.0)`, wantErr: true},
+		{src: `(sqrt -1/4)`, wantErr: true},
+		{src: `(log -1)`, wantErr: true},
+		{src: `(log 1 1)`, wantErr: true},
+		{src: `(expt -8 1/3)`, wantErr: true},
+		{src: `(sin (/ 1.0 0.0))`, wantErr: true},
+		{src: `(log 0)`, want: "-inf.0"},
+		{src: `(log 8 1)`, want: "+inf.0"},
+		{src: `(exp 1000)`, want: "+inf.0"},
+		{src: `(sqrt (- (/ 1.0 0.0) (/ 1.0 0.0)))`, want: "+nan.0"},
+		{src: `(sqrt 16)`, want: "4"},
+		{src: `(expt -8 3)`, want: "-512"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			result, err := run(t, tt.src)
+			if tt.wantErr {
+				if !errors.Is(err, errDomain) || !strings.HasSuffix(err.Error(), "domain error: argument not in valid range") {
+					t.Fatalf("got %v, %v, want a domain error", result, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if got := result.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
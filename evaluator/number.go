@@ -0,0 +1,447 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"simlang/types"
+)
+
+// Numbers are exact or inexact. A Number holds one of four representations.
+// Exact numbers are int64, promoted to *big.Int
+// when a result overflows, or *big.Rat for fractions produced by /. Inexact
+// numbers are float64 and only appear when a literal or an operation asks for
+// them; any operation with an inexact argument has an inexact result.
+//
+// Exact results are always normalized: a *big.Int that fits is an int64 and a
+// *big.Rat with denominator 1 is an integer, so each value has one
+// representation.
+
+const (
+	rankNotNumber = iota
+	rankInt64
+	rankBigInt
+	rankRat
+	rankFloat
+)
+
+func numberRank(value any) int {
+	switch value.(type) {
+	case int64:
+		return rankInt64
+	case *big.Int:
+		return rankBigInt
+	case *big.Rat:
+		return rankRat
+	case float64:
+		return rankFloat
+	default:
+		return rankNotNumber
+	}
+}
+
+func isExact(value any) bool {
+	rank := numberRank(value)
+	return rank != rankNotNumber && rank != rankFloat
+}
+
+func numberFromLiteral(node *types.NumberNode) Number {
+	if node.Exact != nil {
+		return Number{normalizeRat(node.Exact)}
+	}
+	return Number{node.Value}
+}
+
+func normalizeInt(n *big.Int) any {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	return n
+}
+
+func normalizeRat(r *big.Rat) any {
+	if r.IsInt() && r.Num().IsInt64() {
+		// the common case, without copying the numerator
+		return r.Num().Int64()
+	}
+	if r.IsInt() {
+		return normalizeInt(new(big.Int).Set(r.Num()))
+	}
+	return r
+}
+
+// Int, Float, BigInt and Rat build numbers from Go values, for instance when
+// embedding the evaluator. Big values are normalized like any result.
+func Int(n int64) Number {
+	return Number{n}
+}
+
+func Float(f float64) Number {
+	return Number{f}
+}
+
+func BigInt(n *big.Int) Number {
+	return Number{normalizeInt(new(big.Int).Set(n))}
+}
+
+func Rat(r *big.Rat) Number {
+	return Number{normalizeRat(new(big.Rat).Set(r))}
+}
+
+// IsExact reports whether n is an exact number.
+func (n Number) IsExact() bool {
+	return isExact(n.num)
+}
+
+// Int64 returns n if it is an exact integer that fits in an int64.
+func (n Number) Int64() (int64, bool) {
+	i, ok := n.num.(int64)
+	return i, ok
+}
+
+// Float64 returns the float64 nearest to n.
+func (n Number) Float64() float64 {
+	return toFloat(n.num)
+}
+
+// toBigInt converts an exact integer.
+func toBigInt(value any) *big.Int {
+	switch v := value.(type) {
+	case int64:
+		return big.NewInt(v)
+	case *big.Int:
+		return v
+	default:
+		panic(fmt.Sprintf("toBigInt: not an exact integer: %T", value))
+	}
+}
+
+// toRat converts an exact number.
+func toRat(value any) *big.Rat {
+	switch v := value.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(v)
+	case *big.Int:
+		return new(big.Rat).SetInt(v)
+	case *big.Rat:
+		return v
+	default:
+		panic(fmt.Sprintf("toRat: not an exact number: %T", value))
+	}
+}
+
+func toFloat(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f
+	case *big.Rat:
+		f, _ := v.Float64()
+		return f
+	case float64:
+		return v
+	default:
+		panic(fmt.Sprintf("toFloat: not a number: %T", value))
+	}
+}
+
+// numericOp is a binary operation with one implementation per representation.
+// apply picks the widest representation of its arguments and widens further
+// when a narrower implementation gives up: int64 returns ok=false on
+// overflow, and a nil bigInt goes straight to rationals.
+type numericOp struct {
+	int64  func(a, b int64) (result int64, ok bool)
+	bigInt func(a, b *big.Int) *big.Int
+	rat    func(a, b *big.Rat) *big.Rat
+	float  func(a, b float64) float64
+}
+
+func (op numericOp) apply(a, b Number) Number {
+	return Number{op.applyTo(a.num, b.num)}
+}
+
+func (op numericOp) applyTo(a, b any) any {
+	switch max(numberRank(a), numberRank(b)) {
+	case rankInt64:
+		if result, ok := op.int64(a.(int64), b.(int64)); ok {
+			return result
+		}
+		fallthrough
+	case rankBigInt:
+		if op.bigInt != nil {
+			return normalizeInt(op.bigInt(toBigInt(a), toBigInt(b)))
+		}
+		fallthrough
+	case rankRat:
+		return normalizeRat(op.rat(toRat(a), toRat(b)))
+	default:
+		return op.float(toFloat(a), toFloat(b))
+	}
+}
+
+var addOp = numericOp{
+	int64: func(a, b int64) (int64, bool) {
+		sum := a + b
+		return sum, (sum > a) == (b > 0)
+	},
+	bigInt: func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) },
+	rat:    func(a, b *big.Rat) *big.Rat { return new(big.Rat).Add(a, b) },
+	float:  func(a, b float64) float64 { return a + b },
+}
+
+var subOp = numericOp{
+	int64: func(a, b int64) (int64, bool) {
+		diff := a - b
+		return diff, (diff < a) == (b > 0)
+	},
+	bigInt: func(a, b *big.Int) *big.Int { return new(big.Int).Sub(a, b) },
+	rat:    func(a, b *big.Rat) *big.Rat { return new(big.Rat).Sub(a, b) },
+	float:  func(a, b float64) float64 { return a - b },
+}
+
+var mulOp = numericOp{
+	int64: func(a, b int64) (int64, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		return product, product/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+	},
+	bigInt: func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, b) },
+	rat:    func(a, b *big.Rat) *big.Rat { return new(big.Rat).Mul(a, b) },
+	float:  func(a, b float64) float64 { return a * b },
+}
+
+// divOp expects callers to have rejected an exact zero divisor.
+var divOp = numericOp{
+	int64: func(a, b int64) (int64, bool) {
+		if a%b != 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	},
+	rat:   func(a, b *big.Rat) *big.Rat { return new(big.Rat).Quo(a, b) },
+	float: func(a, b float64) float64 { return a / b },
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+func isExactZero(value any) bool {
+	return isExact(value) && toRat(value).Sign() == 0
+}
+
+// compareNumbers returns -1, 0 or 1, or ok=false when the numbers are
+// unordered because one of them is NaN. Finite floats are compared with exact
+// numbers exactly rather than after rounding the exact side.
+func compareNumbers(a, b any) (result int, ok bool) {
+	if x, isInt := a.(int64); isInt {
+		if y, isInt := b.(int64); isInt {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+	if numberRank(a) == rankFloat || numberRank(b) == rankFloat {
+		x, y := toFloat(a), toFloat(b)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			return 0, false
+		}
+		if math.IsInf(x, 0) || math.IsInf(y, 0) {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+		return exactRat(a).Cmp(exactRat(b)), true
+	}
+	return toRat(a).Cmp(toRat(b)), true
+}
+
+// exactRat converts any finite number to an exact rational.
+func exactRat(value any) *big.Rat {
+	if f, ok := value.(float64); ok {
+		return new(big.Rat).SetFloat64(f)
+	}
+	return toRat(value)
+}
+
+// formatNumber writes a number the way the reader reads it back: inexact
+// numbers always show a '.' or an exponent, rationals print as a/b.
+func formatNumber(value any) string {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case *big.Int:
+		return v.String()
+	case *big.Rat:
+		return v.RatString()
+	case float64:
+		return formatFloat(v)
+	default:
+		panic(fmt.Sprintf("formatNumber: not a number: %T", value))
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "+nan.0"
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	}
+
+	abs := math.Abs(f)
+	if abs != 0 && (abs < 1e-7 || abs >= 1e21) {
+		// 1e+21 -> 1e21, 1e-07 -> 1e-7
+		mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+		exp, _ := strconv.Atoi(exponent)
+		return mantissa + "e" + strconv.Itoa(exp)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// numberArgs checks that every argument is a number.
+func numberArgs(name string, args []Value) ([]Number, error) {
+	return appendNumberArgs(make([]Number, 0, len(args)), name, args)
+}
+
+// appendNumberArgs is numberArgs appending to nums, which lets the arithmetic
+// builtins keep the usual few arguments in an array on the stack.
+func appendNumberArgs(nums []Number, name string, args []Value) ([]Number, error) {
+	for _, arg := range args {
+		num, ok := arg.(Number)
+		if !ok {
+			return nil, fmt.Errorf("%s expects numbers but got %s", name, arg)
+		}
+		nums = append(nums, num)
+	}
+	return nums, nil
+}
+
+// numberArg checks that the only argument of a builtin is a number.
+func numberArg(name string, args []Value) (Number, error) {
+	num, ok := args[0].(Number)
+	if !ok {
+		return Number{}, fmt.Errorf("%s expects a number but got %s", name, args[0])
+	}
+	return num, nil
+}
+
+func add(args []Value) (Value, error) {
+	var buf [4]Number
+	nums, err := appendNumberArgs(buf[:0], "+", args)
+	if err != nil {
+		return nil, err
+	}
+	result := Number{int64(0)}
+	for _, num := range nums {
+		result = addOp.apply(result, num)
+	}
+	return result, nil
+}
+
+// (- x) negates, (- x y ...) subtracts the rest from x.
+func subtract(args []Value) (Value, error) {
+	var buf [4]Number
+	nums, err := appendNumberArgs(buf[:0], "-", args)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 1 {
+		return subOp.apply(Number{int64(0)}, nums[0]), nil
+	}
+	result := nums[0]
+	for _, num := range nums[1:] {
+		result = subOp.apply(result, num)
+	}
+	return result, nil
+}
+
+func multiply(args []Value) (Value, error) {
+	var buf [4]Number
+	nums, err := appendNumberArgs(buf[:0], "*", args)
+	if err != nil {
+		return nil, err
+	}
+	result := Number{int64(1)}
+	for _, num := range nums {
+		result = mulOp.apply(result, num)
+	}
+	return result, nil
+}
+
+// (/ x) is the reciprocal, (/ x y ...) divides x by the rest. Dividing exact
+// numbers gives an exact rational, e.g. (/ 1 3) is 1/3.
+func divide(args []Value) (Value, error) {
+	nums, err := numberArgs("/", args)
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 1 {
+		nums = []Number{{int64(1)}, nums[0]}
+	}
+	result := nums[0]
+	for _, num := range nums[1:] {
+		if isExactZero(num.num) {
+			return nil, errDivisionByZero
+		}
+		result = divOp.apply(result, num)
+	}
+	return result, nil
+}
+
+func exactToInexact(args []Value) (Value, error) {
+	num, err := numberArg("exact->inexact", args)
+	if err != nil {
+		return nil, err
+	}
+	return Number{toFloat(num.num)}, nil
+}
+
+// inexactToExact is exact: 0.1 becomes the rational closest to it in binary,
+// not 1/10.
+func inexactToExact(args []Value) (Value, error) {
+	num, err := numberArg("inexact->exact", args)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := num.num.(float64)
+	if !ok {
+		return num, nil
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("inexact->exact: %s has no exact representation", formatFloat(f))
+	}
+	return Number{normalizeRat(new(big.Rat).SetFloat64(f))}, nil
+}
+
+func exactPredicate(name string, wantExact bool) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		num, err := numberArg(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return Bool(isExact(num.num) == wantExact), nil
+	}
+}
@@ -0,0 +1,50 @@
+package evaluator
+
+import "testing"
+
+// TestNumericTower checks exact and inexact arithmetic: promotion to big
+// integers, rationals from division, and inexact contagion.
+func TestNumericTower(t *testing.T) {
+	runTests(t, []evalTest{
+		{name: "integer arithmetic", src: `(+ 1 (* 2 3) (- 4))`, want: "3"},
+		{name: "division makes a rational", src: `(/ 1 3)`, want: "1/3"},
+		{name: "rationals are reduced", src: `(/ 6 4)`, want: "3/2"},
+		{name: "whole rationals are integers", src: `(/ 6 3)`, want: "2"},
+		{name: "rational literal", src: `(+ 1/2 1/3)`, want: "5/6"},
+		{name: "rational times integer", src: `(* 1/2 2)`, want: "1"},
+		{name: "reciprocal", src: `(/ 2)`, want: "1/2"},
+		{name: "negation", src: `(- 5)`, want: "-5"},
+		{name: "overflow promotes to a big integer", src: `(* 9223372036854775807 2)`, want: "18446744073709551614"},
+		{name: "underflow promotes to a big integer", src: `(- -9223372036854775807 2)`, want: "-9223372036854775809"},
+		{name: "big integers shrink back", src: `(- (* 9223372036854775807 2) 9223372036854775807)`, want: "9223372036854775807"},
+		{name: "exact power", src: `(expt 2 100)`, want: "1267650600228229401496703205376"},
+		{name: "negative exact power", src: `(expt 2 -2)`, want: "1/4"},
+		{name: "inexact contagion", src: `(+ 1 0.5)`, want: "1.5"},
+		{name: "integral float keeps its point", src: `(* 2 1.5)`, want: "3.0"},
+		{name: "exponent literal", src: `1.5e3`, want: "1500.0"},
+		{name: "max is inexact when any argument is", src: `(max 1 2.0)`, want: "2.0"},
+		{name: "exact->inexact", src: `(exact->inexact 1/3)`, want: "0.3333333333333333"},
+		{name: "inexact->exact", src: `(inexact->exact 0.5)`, want: "1/2"},
+		{name: "inexact->exact is exact", src: `(inexact->exact 0.1)`, want: "3602879701896397/36028797018963968"},
+		{name: "no exact infinity", src: `(inexact->exact (/ 1.0 0.0))`, wantErr: "+inf.0 has no exact representation"},
+		{name: "exact?", src: `(exact? 1/2)`, want: "#t"},
+		{name: "inexact?", src: `(inexact? 1.0)`, want: "#t"},
+		{name: "integer? of an integral float", src: `(integer? 2.0)`, want: "#t"},
+		{name: "integer? of a rational", src: `(integer? 1/2)`, want: "#f"},
+		{name: "number? of a symbol", src: `(number? 'a)`, want: "#f"},
+		{name: "exact and inexact compare equal", src: `(= 1 1.0)`, want: "#t"},
+		{name: "rational compared with a float", src: `(< 1/3 0.34)`, want: "#t"},
+		{name: "quotient truncates", src: `(quotient 7 -2)`, want: "-3"},
+		{name: "remainder takes the sign of the dividend", src: `(remainder -7 2)`, want: "-1"},
+		{name: "modulo takes the sign of the divisor", src: `(modulo -7 2)`, want: "1"},
+		{name: "floor of a rational", src: `(floor 7/2)`, want: "3"},
+		{name: "round to even", src: `(round 5/2)`, want: "2"},
+		{name: "round an inexact to even", src: `(round 2.5)`, want: "2.0"},
+		{name: "truncate", src: `(truncate -3.7)`, want: "-3.0"},
+		{name: "abs of a rational", src: `(abs -1/2)`, want: "1/2"},
+		{name: "inexact division by zero", src: `(/ 1.0 0.0)`, want: "+inf.0"},
+		{name: "exact division by zero", src: `(/ 1 0)`, wantErr: "division by zero"},
+		{name: "division by exact zero", src: `(/ 1.0 0)`, wantErr: "division by zero"},
+		{name: "not a number", src: `(+ 1 "a")`, wantErr: `+ expects numbers but got "a"`},
+	})
+}
@@ -0,0 +1,110 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+)
+
+// stdlib is the registry of builtin procedures. Every environment starts
+// with these, and other backends such as the LLVM generator consult it via
+// LookupStdlib to know which procedures exist and how many arguments they
+// take.
+var stdlib = []*Builtin{
+	{Name: "+", MinArgs: 0, MaxArgs: Variadic, Fn: add},
+	{Name: "-", MinArgs: 1, MaxArgs: Variadic, Fn: subtract},
+	{Name: "*", MinArgs: 0, MaxArgs: Variadic, Fn: multiply},
+	{Name: "/", MinArgs: 1, MaxArgs: Variadic, Fn: divide},
+	{Name: "quotient", MinArgs: 2, MaxArgs: 2, Fn: integerDivision("quotient", quotientExact, quotientInexact)},
+	{Name: "remainder", MinArgs: 2, MaxArgs: 2, Fn: integerDivision("remainder", remainderExact, remainderInexact)},
+	{Name: "modulo", MinArgs: 2, MaxArgs: 2, Fn: integerDivision("modulo", moduloExact, moduloInexact)},
+	{Name: "abs", MinArgs: 1, MaxArgs: 1, Fn: abs},
+	{Name: "min", MinArgs: 1, MaxArgs: Variadic, Fn: extremum("min", -1)},
+	{Name: "max", MinArgs: 1, MaxArgs: Variadic, Fn: extremum("max", 1)},
+	{Name: "expt", MinArgs: 2, MaxArgs: 2, Fn: expt},
+	{Name: "sqrt", MinArgs: 1, MaxArgs: 1, Fn: sqrt},
+	{Name: "exp", MinArgs: 1, MaxArgs: 1, Fn: floatFunction("exp", math.Exp)},
+	{Name: "log", MinArgs: 1, MaxArgs: 2, Fn: logarithm},
+	{Name: "sin", MinArgs: 1, MaxArgs: 1, Fn: floatFunction("sin", math.Sin)},
+	{Name: "cos", MinArgs: 1, MaxArgs: 1, Fn: floatFunction("cos", math.Cos)},
+	{Name: "tan", MinArgs: 1, MaxArgs: 1, Fn: floatFunction("tan", math.Tan)},
+	{Name: "floor", MinArgs: 1, MaxArgs: 1, Fn: rounding("floor", floorRat, math.Floor)},
+	{Name: "ceiling", MinArgs: 1, MaxArgs: 1, Fn: rounding("ceiling", ceilingRat, math.Ceil)},
+	{Name: "round", MinArgs: 1, MaxArgs: 1, Fn: rounding("round", roundRat, math.RoundToEven)},
+	{Name: "truncate", MinArgs: 1, MaxArgs: 1, Fn: rounding("truncate", truncateRat, math.Trunc)},
+	{Name: "=", MinArgs: 1, MaxArgs: Variadic, Fn: numericComparison("=", func(c int) bool { return c == 0 })},
+	{Name: "<", MinArgs: 1, MaxArgs: Variadic, Fn: numericComparison("<", func(c int) bool { return c < 0 })},
+	{Name: ">", MinArgs: 1, MaxArgs: Variadic, Fn: numericComparison(">", func(c int) bool { return c > 0 })},
+	{Name: "<=", MinArgs: 1, MaxArgs: Variadic, Fn: numericComparison("<=", func(c int) bool { return c <= 0 })},
+	{Name: ">=", MinArgs: 1, MaxArgs: Variadic, Fn: numericComparison(">=", func(c int) bool { return c >= 0 })},
+	{Name: "number?", MinArgs: 1, MaxArgs: 1, Fn: isNumberPredicate},
+	{Name: "integer?", MinArgs: 1, MaxArgs: 1, Fn: isIntegerPredicate},
+	{Name: "zero?", MinArgs: 1, MaxArgs: 1, Fn: signPredicate("zero?", func(c int) bool { return c == 0 })},
+	{Name: "positive?", MinArgs: 1, MaxArgs: 1, Fn: signPredicate("positive?", func(c int) bool { return c > 0 })},
+	{Name: "negative?", MinArgs: 1, MaxArgs: 1, Fn: signPredicate("negative?", func(c int) bool { return c < 0 })},
+	{Name: "even?", MinArgs: 1, MaxArgs: 1, Fn: parityPredicate("even?", true)},
+	{Name: "odd?", MinArgs: 1, MaxArgs: 1, Fn: parityPredicate("odd?", false)},
+	{Name: "exact->inexact", MinArgs: 1, MaxArgs: 1, Fn: exactToInexact},
+	{Name: "inexact->exact", MinArgs: 1, MaxArgs: 1, Fn: inexactToExact},
+	{Name: "exact?", MinArgs: 1, MaxArgs: 1, Fn: exactPredicate("exact?", true)},
+	{Name: "inexact?", MinArgs: 1, MaxArgs: 1, Fn: exactPredicate("inexact?", false)},
+	{Name: "cons", MinArgs: 2, MaxArgs: 2, Fn: cons},
+	{Name: "car", MinArgs: 1, MaxArgs: 1, Fn: car},
+	{Name: "cdr", MinArgs: 1, MaxArgs: 1, Fn: cdr},
+	{Name: "list", MinArgs: 0, MaxArgs: Variadic, Fn: list},
+	{Name: "null?", MinArgs: 1, MaxArgs: 1, Fn: isNullPredicate},
+	{Name: "pair?", MinArgs: 1, MaxArgs: 1, Fn: isPairPredicate},
+	{Name: "length", MinArgs: 1, MaxArgs: 1, Fn: length},
+	{Name: "append", MinArgs: 0, MaxArgs: Variadic, Fn: appendLists},
+	{Name: "reverse", MinArgs: 1, MaxArgs: 1, Fn: reverse},
+	{Name: "map", MinArgs: 2, MaxArgs: Variadic, call: mapLists},
+	{Name: "filter", MinArgs: 2, MaxArgs: 2, call: filter},
+	{Name: "fold", MinArgs: 3, MaxArgs: Variadic, call: fold},
+	{Name: "macroexpand", MinArgs: 1, MaxArgs: 1, call: macroexpand},
+	{Name: "string-append", MinArgs: 0, MaxArgs: Variadic, Fn: stringAppend},
+	{Name: "string-length", MinArgs: 1, MaxArgs: 1, Fn: stringLength},
+	{Name: "substring", MinArgs: 2, MaxArgs: 3, Fn: substring},
+	{Name: "display", MinArgs: 0, MaxArgs: Variadic, Fn: display},
+	{Name: "newline", MinArgs: 0, MaxArgs: 0, Fn: newline},
+}
+
+// LookupStdlib returns the builtin procedure called name.
+func LookupStdlib(name string) (*Builtin, bool) {
+	for _, builtin := range stdlib {
+		if builtin.Name == name {
+			return builtin, true
+		}
+	}
+	return nil, false
+}
+
+// CheckArity reports an error unless the builtin accepts argc arguments.
+func (b *Builtin) CheckArity(argc int) error {
+	if argc >= b.MinArgs && (b.MaxArgs == Variadic || argc <= b.MaxArgs) {
+		return nil
+	}
+	return fmt.Errorf("%s expects %s, got %d", b.Name, describeArity(b.MinArgs, b.MaxArgs), argc)
+}
+
+func describeArity(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs == Variadic:
+		return fmt.Sprintf("at least %s", pluralArguments(minArgs))
+	case minArgs == maxArgs:
+		return pluralArguments(minArgs)
+	case minArgs+1 == maxArgs:
+		return fmt.Sprintf("%d or %s", minArgs, pluralArguments(maxArgs))
+	default:
+		return fmt.Sprintf("%d to %s", minArgs, pluralArguments(maxArgs))
+	}
+}
+
+func pluralArguments(n int) string {
+	switch n {
+	case 0:
+		return "no arguments"
+	case 1:
+		return "1 argument"
+	default:
+		return fmt.Sprintf("%d arguments", n)
+	}
+}
@@ -0,0 +1,64 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+func stringAppend(args []Value) (Value, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		str, ok := arg.(String)
+		if !ok {
+			return nil, fmt.Errorf("string-append expects strings but got %s", arg)
+		}
+		sb.WriteString(string(str))
+	}
+	return String(sb.String()), nil
+}
+
+func stringLength(args []Value) (Value, error) {
+	str, ok := args[0].(String)
+	if !ok {
+		return nil, fmt.Errorf("string-length expects a string but got %s", args[0])
+	}
+	return Number{int64(utf8.RuneCountInString(string(str)))}, nil
+}
+
+// (substring s start [end]) with indexes counted in characters
+func substring(args []Value) (Value, error) {
+	str, ok := args[0].(String)
+	if !ok {
+		return nil, fmt.Errorf("substring expects a string but got %s", args[0])
+	}
+	runes := []rune(string(str))
+
+	start, err := stringIndex(args[1], len(runes))
+	if err != nil {
+		return nil, fmt.Errorf("substring start: %w", err)
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		end, err = stringIndex(args[2], len(runes))
+		if err != nil {
+			return nil, fmt.Errorf("substring end: %w", err)
+		}
+	}
+	if start > end {
+		return nil, fmt.Errorf("substring start %d is after end %d", start, end)
+	}
+	return String(runes[start:end]), nil
+}
+
+func stringIndex(arg Value, length int) (int, error) {
+	num, ok := arg.(Number)
+	index, isInt := num.num.(int64)
+	if !ok || !isInt {
+		return 0, fmt.Errorf("expected an exact integer index but got %s", arg)
+	}
+	if index < 0 || index > int64(length) {
+		return 0, fmt.Errorf("index %d out of range [0, %d]", index, length)
+	}
+	return int(index), nil
+}
@@ -0,0 +1,450 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+
+	"simlang/parser"
+	"simlang/types"
+)
+
+// ellipsis follows a subpattern that matches any number of items, and a
+// subtemplate that is repeated for each of them.
+const ellipsis = "..."
+
+// syntaxRules is a macro defined with define-syntax.
+type syntaxRules struct {
+	literals map[string]bool
+	rules    []syntaxRule
+}
+
+type syntaxRule struct {
+	pattern  *types.ListNode
+	template types.ASTNode
+	// depths maps each pattern variable to the number of ellipses it is under.
+	depths map[string]int
+}
+
+// newSyntaxRules checks the rules of node: pattern variables must be
+// distinct, an ellipsis may follow only one subpattern of a list, and
+// templates must use every pattern variable under as many ellipses as its
+// pattern does.
+func newSyntaxRules(node *types.DefineSyntaxNode) (*syntaxRules, error) {
+	m := &syntaxRules{literals: make(map[string]bool, len(node.Literals))}
+	for _, literal := range node.Literals {
+		m.literals[literal.Name] = true
+	}
+	for _, rule := range node.Rules {
+		pattern, ok := rule.Pattern.(*types.ListNode)
+		if !ok || len(pattern.Elements) == 0 {
+			return nil, errorAt(rule.Pattern, errors.New("a pattern must be a list like (_ args...)"))
+		}
+		r := syntaxRule{pattern: pattern, template: rule.Template, depths: make(map[string]int)}
+		// the head stands for the macro keyword and is not matched
+		rest := &types.ListNode{Span: pattern.Span, Elements: pattern.Elements[1:], Tail: pattern.Tail}
+		if err := m.collect(rest, 0, r.depths); err != nil {
+			return nil, err
+		}
+		if err := checkTemplate(rule.Template, 0, r.depths); err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, r)
+	}
+	return m, nil
+}
+
+// collect records in depths the variables of pattern, which is under depth
+// ellipses.
+func (m *syntaxRules) collect(pattern types.ASTNode, depth int, depths map[string]int) error {
+	switch v := pattern.(type) {
+	case *types.SymbolNode:
+		if v.Name == ellipsis {
+			return errorAt(v, errors.New("... must follow a subpattern"))
+		}
+		if !m.isVariable(v.Name) {
+			return nil
+		}
+		if _, ok := depths[v.Name]; ok {
+			return errorAt(v, fmt.Errorf("pattern variable %s is used twice", v.Name))
+		}
+		depths[v.Name] = depth
+	case *types.ListNode:
+		repeated := ellipsisIndex(v.Elements)
+		for i, element := range v.Elements {
+			switch {
+			case repeated >= 0 && i == repeated+1:
+				continue
+			case i > 0 && isEllipsis(element):
+				return errorAt(element, errors.New("... may follow only one subpattern of a list"))
+			}
+			elementDepth := depth
+			if i == repeated {
+				elementDepth++
+			}
+			if err := m.collect(element, elementDepth, depths); err != nil {
+				return err
+			}
+		}
+		if v.Tail != nil {
+			return m.collect(v.Tail, depth, depths)
+		}
+	}
+	return nil
+}
+
+// checkTemplate checks the pattern variables in template, which is under
+// depth ellipses.
+func checkTemplate(template types.ASTNode, depth int, depths map[string]int) error {
+	switch v := template.(type) {
+	case *types.SymbolNode:
+		if v.Name == ellipsis {
+			return errorAt(v, errors.New("... must follow a subtemplate"))
+		}
+		if variableDepth, ok := depths[v.Name]; ok && variableDepth > depth {
+			return errorAt(v, fmt.Errorf("pattern variable %s is matched under %d ellipses but used under %d", v.Name, variableDepth, depth))
+		}
+	case *types.ListNode:
+		for i := 0; i < len(v.Elements); i++ {
+			element := v.Elements[i]
+			repeats := ellipsesAfter(v.Elements, i)
+			for level := 1; level <= repeats; level++ {
+				if !repeatable(element, depth+level, depths) {
+					return errorAt(element, fmt.Errorf("no pattern variable in %s is matched under enough ellipses to repeat it", datumToValue(element)))
+				}
+			}
+			if err := checkTemplate(element, depth+repeats, depths); err != nil {
+				return err
+			}
+			i += repeats
+		}
+		if v.Tail != nil {
+			return checkTemplate(v.Tail, depth, depths)
+		}
+	}
+	return nil
+}
+
+// repeatable reports whether template holds a pattern variable matched under
+// at least depth ellipses.
+func repeatable(template types.ASTNode, depth int, depths map[string]int) bool {
+	switch v := template.(type) {
+	case *types.SymbolNode:
+		return depths[v.Name] >= depth
+	case *types.ListNode:
+		for _, element := range v.Elements {
+			if repeatable(element, depth, depths) {
+				return true
+			}
+		}
+		return v.Tail != nil && repeatable(v.Tail, depth, depths)
+	default:
+		return false
+	}
+}
+
+func (m *syntaxRules) isVariable(name string) bool {
+	return name != "_" && name != ellipsis && !m.literals[name]
+}
+
+func isEllipsis(node types.ASTNode) bool {
+	symbol, ok := node.(*types.SymbolNode)
+	return ok && symbol.Name == ellipsis
+}
+
+// ellipsisIndex returns the index of the subpattern an ellipsis follows in
+// elements, or -1.
+func ellipsisIndex(elements []types.ASTNode) int {
+	for i := 1; i < len(elements); i++ {
+		if isEllipsis(elements[i]) {
+			return i - 1
+		}
+	}
+	return -1
+}
+
+// ellipsesAfter counts the ellipses right after elements[i].
+func ellipsesAfter(elements []types.ASTNode, i int) int {
+	n := 0
+	for i+n+1 < len(elements) && isEllipsis(elements[i+n+1]) {
+		n++
+	}
+	return n
+}
+
+// transform fills in the template of the first rule whose pattern matches
+// call.
+func (m *syntaxRules) transform(x *expansion, call *types.CallNode, sc *scope) (types.ASTNode, error) {
+	use := parser.Unparse(call).(*types.ListNode)
+	args := &types.ListNode{Span: use.Span, Elements: use.Elements[1:]}
+	for _, r := range m.rules {
+		pattern := &types.ListNode{Span: r.pattern.Span, Elements: r.pattern.Elements[1:], Tail: r.pattern.Tail}
+		matches := make(map[string]*patternMatch)
+		if !m.match(x, sc, pattern, args, matches) {
+			continue
+		}
+		fill := &instantiation{x: x, span: call.Span, renames: make(map[string]string)}
+		datum, err := fill.fill(r.template, matches)
+		if err != nil {
+			return nil, err
+		}
+		return parser.ParseDatum(datum)
+	}
+	return nil, fmt.Errorf("no rule matches %s", datumToValue(x.data(use)))
+}
+
+// patternMatch is what a pattern variable matched: a datum, or for a variable
+// under ellipses, one patternMatch per repetition in items.
+type patternMatch struct {
+	datum types.ASTNode
+	items []*patternMatch
+}
+
+// match reports whether datum matches pattern, and records what its variables
+// matched in matches.
+func (m *syntaxRules) match(x *expansion, sc *scope, pattern, datum types.ASTNode, matches map[string]*patternMatch) bool {
+	switch p := pattern.(type) {
+	case *types.SymbolNode:
+		if m.literals[p.Name] {
+			// a literal matches the same name, unless the use binds it locally
+			symbol, ok := datum.(*types.SymbolNode)
+			return ok && x.original(symbol.Name) == p.Name && sc.lookup(symbol.Name) == nil
+		}
+		if p.Name != "_" {
+			matches[p.Name] = &patternMatch{datum: datum}
+		}
+		return true
+	case *types.ListNode:
+		elements, tail, ok := listParts(datum)
+		if !ok {
+			return false
+		}
+		before, after := p.Elements, []types.ASTNode(nil)
+		repeated := ellipsisIndex(p.Elements)
+		if repeated >= 0 {
+			before, after = p.Elements[:repeated], p.Elements[repeated+2:]
+		}
+		fixed := len(before) + len(after)
+		if len(elements) < fixed || repeated < 0 && p.Tail == nil && len(elements) > fixed || p.Tail == nil && tail != nil {
+			return false
+		}
+
+		for i, element := range before {
+			if !m.match(x, sc, element, elements[i], matches) {
+				return false
+			}
+		}
+		rest := elements[len(before):]
+		if repeated >= 0 {
+			items := rest[:len(rest)-len(after)]
+			if !m.matchRepeated(x, sc, p.Elements[repeated], items, matches) {
+				return false
+			}
+			for i, element := range after {
+				if !m.match(x, sc, element, rest[len(items)+i], matches) {
+					return false
+				}
+			}
+			rest = nil
+		}
+		if p.Tail != nil {
+			return m.match(x, sc, p.Tail, restDatum(rest, tail, p.Span), matches)
+		}
+		return true
+	default:
+		return equalDatum(pattern, datum)
+	}
+}
+
+// matchRepeated matches each of items against the subpattern an ellipsis
+// follows.
+func (m *syntaxRules) matchRepeated(x *expansion, sc *scope, pattern types.ASTNode, items []types.ASTNode, matches map[string]*patternMatch) bool {
+	depths := make(map[string]int)
+	m.collect(pattern, 0, depths) // cannot fail, newSyntaxRules checked pattern
+	for name := range depths {
+		matches[name] = &patternMatch{items: make([]*patternMatch, 0, len(items))}
+	}
+	for _, item := range items {
+		itemMatches := make(map[string]*patternMatch, len(depths))
+		if !m.match(x, sc, pattern, item, itemMatches) {
+			return false
+		}
+		for name := range depths {
+			matches[name].items = append(matches[name].items, itemMatches[name])
+		}
+	}
+	return true
+}
+
+// listParts returns the elements of a list datum and the datum after its
+// dot, if any.
+func listParts(datum types.ASTNode) (elements []types.ASTNode, tail types.ASTNode, ok bool) {
+	list, ok := datum.(*types.ListNode)
+	if !ok {
+		return nil, nil, false
+	}
+	for {
+		elements = append(elements, list.Elements...)
+		next, ok := list.Tail.(*types.ListNode)
+		if !ok {
+			return elements, list.Tail, true
+		}
+		list = next
+	}
+}
+
+// restDatum builds the list of elements followed by tail.
+func restDatum(elements []types.ASTNode, tail types.ASTNode, span types.Span) types.ASTNode {
+	if len(elements) == 0 && tail != nil {
+		return tail
+	}
+	return &types.ListNode{Span: span, Elements: elements, Tail: tail}
+}
+
+// equalDatum compares the number, string or boolean a pattern holds to datum.
+func equalDatum(pattern, datum types.ASTNode) bool {
+	switch p := pattern.(type) {
+	case *types.NumberNode:
+		d, ok := datum.(*types.NumberNode)
+		if !ok || (p.Exact == nil) != (d.Exact == nil) {
+			return false
+		}
+		if p.Exact != nil {
+			return p.Exact.Cmp(d.Exact) == 0
+		}
+		return p.Value == d.Value
+	case *types.StringNode:
+		d, ok := datum.(*types.StringNode)
+		return ok && p.Value == d.Value
+	case *types.BoolNode:
+		d, ok := datum.(*types.BoolNode)
+		return ok && p.Value == d.Value
+	default:
+		return false
+	}
+}
+
+// instantiation fills in one template for one macro use. Symbols the
+// template introduces are renamed to aliases, the same alias for every
+// occurrence of a name, and take the span of the use.
+type instantiation struct {
+	x       *expansion
+	span    types.Span
+	renames map[string]string
+}
+
+func (in *instantiation) fill(template types.ASTNode, matches map[string]*patternMatch) (types.ASTNode, error) {
+	switch v := template.(type) {
+	case *types.SymbolNode:
+		if match, ok := matches[v.Name]; ok {
+			return match.datum, nil
+		}
+		if parser.IsKeyword(v.Name) {
+			return &types.SymbolNode{Span: in.span, Name: v.Name}, nil
+		}
+		alias, ok := in.renames[v.Name]
+		if !ok {
+			alias = in.x.alias(v.Name)
+			in.renames[v.Name] = alias
+		}
+		return &types.SymbolNode{Span: in.span, Name: alias}, nil
+	case *types.ListNode:
+		filled := &types.ListNode{Span: in.span, Elements: make([]types.ASTNode, 0, len(v.Elements))}
+		for i := 0; i < len(v.Elements); i++ {
+			repeats := ellipsesAfter(v.Elements, i)
+			if repeats == 0 {
+				element, err := in.fill(v.Elements[i], matches)
+				if err != nil {
+					return nil, err
+				}
+				filled.Elements = append(filled.Elements, element)
+				continue
+			}
+			elements, err := in.repeat(v.Elements[i], matches, repeats)
+			if err != nil {
+				return nil, err
+			}
+			filled.Elements = append(filled.Elements, elements...)
+			i += repeats
+		}
+		if v.Tail != nil {
+			tail, err := in.fill(v.Tail, matches)
+			if err != nil {
+				return nil, err
+			}
+			filled.Tail = tail
+		}
+		return filled, nil
+	case *types.NumberNode:
+		filled := *v
+		filled.Span = in.span
+		return &filled, nil
+	case *types.StringNode:
+		filled := *v
+		filled.Span = in.span
+		return &filled, nil
+	case *types.BoolNode:
+		filled := *v
+		filled.Span = in.span
+		return &filled, nil
+	default:
+		return template, nil
+	}
+}
+
+// repeat fills in template, which is followed by the given number of
+// ellipses, once for each item the pattern variables in it matched.
+func (in *instantiation) repeat(template types.ASTNode, matches map[string]*patternMatch, ellipses int) ([]types.ASTNode, error) {
+	var repeated []string
+	count := 0
+	for _, name := range templateSymbols(template, nil) {
+		match, ok := matches[name]
+		if !ok || match.datum != nil {
+			continue
+		}
+		if len(repeated) > 0 && len(match.items) != count {
+			return nil, fmt.Errorf("pattern variables %s and %s matched different numbers of items", repeated[0], name)
+		}
+		repeated, count = append(repeated, name), len(match.items)
+	}
+
+	var filled []types.ASTNode
+	for i := range count {
+		itemMatches := make(map[string]*patternMatch, len(matches))
+		for name, match := range matches {
+			itemMatches[name] = match
+		}
+		for _, name := range repeated {
+			itemMatches[name] = matches[name].items[i]
+		}
+		if ellipses > 1 {
+			elements, err := in.repeat(template, itemMatches, ellipses-1)
+			if err != nil {
+				return nil, err
+			}
+			filled = append(filled, elements...)
+			continue
+		}
+		element, err := in.fill(template, itemMatches)
+		if err != nil {
+			return nil, err
+		}
+		filled = append(filled, element)
+	}
+	return filled, nil
+}
+
+// templateSymbols appends the names of the symbols in template to names.
+func templateSymbols(template types.ASTNode, names []string) []string {
+	switch v := template.(type) {
+	case *types.SymbolNode:
+		return append(names, v.Name)
+	case *types.ListNode:
+		for _, element := range v.Elements {
+			names = templateSymbols(element, names)
+		}
+		if v.Tail != nil {
+			names = templateSymbols(v.Tail, names)
+		}
+	}
+	return names
+}
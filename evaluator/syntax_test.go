@@ -0,0 +1,154 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+)
+
+const swapMacro = `
+	(define-syntax swap!
+	  (syntax-rules ()
+	    ((_ a b) (let ((tmp a)) in (begin (set! a b) (set! b tmp))))))`
+
+const myOrMacro = `
+	(define-syntax my-or
+	  (syntax-rules ()
+	    ((_) #f)
+	    ((_ e) e)
+	    ((_ e r ...) (let ((t e)) in (if t t (my-or r ...))))))`
+
+// TestSyntaxRules checks that syntax-rules macros neither capture the
+// variables of their uses nor are captured by them.
+func TestSyntaxRules(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "swap globals",
+			src:  swapMacro + `(define x 1) (define y 2) (swap! x y) (list x y)`,
+			want: "(2 1)",
+		},
+		{
+			name: "swap a global named like the temporary",
+			src:  swapMacro + `(define tmp 1) (define y 2) (swap! tmp y) (list tmp y)`,
+			want: "(2 1)",
+		},
+		{
+			name: "swap locals named like the temporary",
+			src:  swapMacro + `(let ((tmp 1) (other 2)) in (begin (swap! tmp other) (list tmp other)))`,
+			want: "(2 1)",
+		},
+		{
+			name: "swap closure parameters",
+			src:  swapMacro + `((lambda (tmp y) (begin (swap! tmp y) (list tmp y))) 1 2)`,
+			want: "(2 1)",
+		},
+		{
+			name: "my-or does not capture t",
+			src:  myOrMacro + `(define t 5) (my-or #f t)`,
+			want: "5",
+		},
+		{
+			name: "my-or does not capture a local t",
+			src:  myOrMacro + `(let ((t 5)) in (my-or #f t))`,
+			want: "5",
+		},
+		{
+			name: "my-or evaluates its argument once",
+			src:  myOrMacro + `(define n 0) (my-or (begin (set! n (+ n 1)) n) 'never) n`,
+			want: "1",
+		},
+		{
+			name: "template list is the global one when the use shadows it",
+			src: `
+				(define-syntax pair-of (syntax-rules () ((_ a b) (list a b))))
+				(let ((list (lambda (a b) 'shadowed))) in (pair-of 1 2))`,
+			want: "(1 2)",
+		},
+		{
+			name: "template else is the keyword when the use shadows it",
+			src: `
+				(define-syntax choose
+				  (syntax-rules () ((_ c a b) (cond (c a) (else b)))))
+				(let ((else #f)) in (choose #f 1 2))`,
+			want: "2",
+		},
+		{
+			name: "literal else matches else",
+			src: `
+				(define-syntax kind (syntax-rules (else) ((_ else) 'keyword) ((_ x) 'variable)))
+				(kind else)`,
+			want: "keyword",
+		},
+		{
+			name: "literal else does not match a local else",
+			src: `
+				(define-syntax kind (syntax-rules (else) ((_ else) 'keyword) ((_ x) 'variable)))
+				(let ((else 1)) in (kind else))`,
+			want: "variable",
+		},
+		{
+			name: "nested ellipses",
+			src: `
+				(define-syntax flatten
+				  (syntax-rules () ((_ (a ...) ...) '(a ... ...))))
+				(flatten (1 2) () (3))`,
+			want: "(1 2 3)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := run(t, tt.src)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if got := result.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSyntaxRulesEllipsisDepth checks that define-syntax rejects templates
+// using a pattern variable under fewer or more ellipses than it matched.
+func TestSyntaxRulesEllipsisDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "variable used under too few ellipses",
+			src:     `(define-syntax m (syntax-rules () ((_ x ...) (list x))))`,
+			wantErr: "pattern variable x is matched under 1 ellipses but used under 0",
+		},
+		{
+			name:    "nested variable used under one ellipsis",
+			src:     `(define-syntax m (syntax-rules () ((_ (x ...) ...) (list x ...))))`,
+			wantErr: "pattern variable x is matched under 2 ellipses but used under 1",
+		},
+		{
+			name:    "variable used under too many ellipses",
+			src:     `(define-syntax m (syntax-rules () ((_ x) (list x ...))))`,
+			wantErr: "no pattern variable in x is matched under enough ellipses to repeat it",
+		},
+		{
+			name:    "ellipsis without a subtemplate",
+			src:     `(define-syntax m (syntax-rules () ((_ x ...) (...))))`,
+			wantErr: "... must follow a subtemplate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := run(t, tt.src)
+			if err == nil {
+				t.Fatalf("succeeded, want an error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package evaluator
+
+import (
+	"strings"
+
+	"simlang/types"
+	"simlang/util"
+)
+
+// Value is a simlang runtime value.
+type Value interface {
+	// Type names the kind of value for error messages, e.g. "number".
+	Type() string
+	// String formats the value the way it would be written in simlang
+	// source, which is also how the REPL displays it.
+	String() string
+	// Equal reports whether two values are the same: numbers and strings by
+	// content, pairs element-wise and procedures by identity.
+	Equal(other Value) bool
+}
+
+// Number is an exact or inexact number; see number.go.
+type Number struct {
+	num any
+}
+
+// String is a simlang string.
+type String string
+
+type Bool bool
+
+// Symbol is a quoted name, e.g. the value of 'x.
+type Symbol string
+
+// Nil is the empty list ().
+type Nil struct{}
+
+// Void is the result of expressions that have no useful value, like define
+// or display. The REPL prints nothing for it.
+type Void struct{}
+
+// Pair is a cons cell. Proper lists are pairs ending in Nil.
+type Pair struct {
+	Car Value
+	Cdr Value
+}
+
+// Closure is a procedure created by lambda. Name is filled in when the
+// closure is defined with a name, for printing only.
+type Closure struct {
+	Name   string
+	Params []*types.SymbolNode
+	Body   types.ASTNode
+	Env    *Env
+}
+
+// Builtin is a procedure implemented in Go. apply checks the number of
+// arguments against MinArgs and MaxArgs before calling Fn. The args slice is
+// only valid during the call, so Fn must copy it to keep it.
+type Builtin struct {
+	Name    string
+	MinArgs int
+	MaxArgs int // Variadic for no upper bound
+	Fn      func(args []Value) (Value, error)
+	// call replaces Fn for builtins that call procedures, like map, so the
+	// calls count against the limits of the running evaluation.
+	call func(ev *evaluation, args []Value) (Value, error)
+}
+
+// Variadic is the MaxArgs of builtins that take any number of arguments.
+const Variadic = -1
+
+func (n Number) Type() string   { return "number" }
+func (s String) Type() string   { return "string" }
+func (b Bool) Type() string     { return "boolean" }
+func (s Symbol) Type() string   { return "symbol" }
+func (Nil) Type() string        { return "empty list" }
+func (Void) Type() string       { return "void" }
+func (p *Pair) Type() string    { return "pair" }
+func (c *Closure) Type() string { return "procedure" }
+func (b *Builtin) Type() string { return "procedure" }
+
+func (n Number) String() string {
+	return formatNumber(n.num)
+}
+
+func (s String) String() string {
+	return util.Quote(string(s))
+}
+
+func (b Bool) String() string {
+	if b {
+		return "#t"
+	}
+	return "#f"
+}
+
+func (s Symbol) String() string {
+	return string(s)
+}
+
+func (Nil) String() string {
+	return "()"
+}
+
+func (Void) String() string {
+	return "#<void>"
+}
+
+// String writes proper lists as (1 2 3) and improper ones as (1 2 . 3).
+func (p *Pair) String() string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	for {
+		sb.WriteString(p.Car.String())
+		next, ok := p.Cdr.(*Pair)
+		if !ok {
+			break
+		}
+		sb.WriteString(" ")
+		p = next
+	}
+	if _, ok := p.Cdr.(Nil); !ok {
+		sb.WriteString(" . ")
+		sb.WriteString(p.Cdr.String())
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+func (c *Closure) String() string {
+	if c.Name == "" {
+		return "#<procedure>"
+	}
+	return "#<procedure " + c.Name + ">"
+}
+
+func (b *Builtin) String() string {
+	return "#<procedure " + b.Name + ">"
+}
+
+// Equal treats an exact and an inexact number as different even when they
+// are numerically equal, like eqv?: 1 and 1.0 are not Equal.
+func (n Number) Equal(other Value) bool {
+	o, ok := other.(Number)
+	if !ok || isExact(n.num) != isExact(o.num) {
+		return false
+	}
+	c, ok := compareNumbers(n.num, o.num)
+	return ok && c == 0
+}
+
+func (s String) Equal(other Value) bool {
+	o, ok := other.(String)
+	return ok && s == o
+}
+
+func (b Bool) Equal(other Value) bool {
+	o, ok := other.(Bool)
+	return ok && b == o
+}
+
+func (s Symbol) Equal(other Value) bool {
+	o, ok := other.(Symbol)
+	return ok && s == o
+}
+
+func (Nil) Equal(other Value) bool {
+	_, ok := other.(Nil)
+	return ok
+}
+
+func (Void) Equal(other Value) bool {
+	_, ok := other.(Void)
+	return ok
+}
+
+func (p *Pair) Equal(other Value) bool {
+	o, ok := other.(*Pair)
+	return ok && p.Car.Equal(o.Car) && p.Cdr.Equal(o.Cdr)
+}
+
+func (c *Closure) Equal(other Value) bool {
+	o, ok := other.(*Closure)
+	return ok && c == o
+}
+
+func (b *Builtin) Equal(other Value) bool {
+	o, ok := other.(*Builtin)
+	return ok && b == o
+}
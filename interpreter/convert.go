@@ -0,0 +1,300 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"simlang/evaluator"
+)
+
+var (
+	valueType = reflect.TypeFor[evaluator.Value]()
+	errorType = reflect.TypeFor[error]()
+)
+
+// toValue converts a Go value to a Simlang value. Functions become builtins
+// called name.
+func toValue(name string, value any) (evaluator.Value, error) {
+	if value == nil {
+		return evaluator.Void{}, nil
+	}
+	return reflectToValue(name, reflect.ValueOf(value))
+}
+
+func reflectToValue(name string, v reflect.Value) (evaluator.Value, error) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return evaluator.Void{}, nil
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(valueType) {
+		return v.Interface().(evaluator.Value), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return evaluator.Bool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return evaluator.Int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return evaluator.BigInt(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return evaluator.Float(v.Float()), nil
+	case reflect.String:
+		return evaluator.String(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		var list evaluator.Value = evaluator.Nil{}
+		for i := v.Len() - 1; i >= 0; i-- {
+			element, err := reflectToValue(name, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = &evaluator.Pair{Car: element, Cdr: list}
+		}
+		return list, nil
+	case reflect.Func:
+		return newBuiltin(name, v.Interface())
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a simlang value", v.Type())
+	}
+}
+
+// fromValue converts value to the Go type t, or returns ok=false if value
+// does not fit t.
+func fromValue(value evaluator.Value, t reflect.Type) (converted reflect.Value, ok bool) {
+	converted = reflect.New(t).Elem()
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		// no value is nil, which the zero value already is
+		if v := natural(value); v != nil {
+			converted.Set(reflect.ValueOf(v))
+		}
+		return converted, true
+	}
+	if t.Implements(valueType) {
+		if !reflect.TypeOf(value).AssignableTo(t) {
+			return converted, false
+		}
+		converted.Set(reflect.ValueOf(value))
+		return converted, true
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, ok := value.(evaluator.Bool)
+		converted.SetBool(bool(b))
+		return converted, ok
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := exactInt(value)
+		if !ok || converted.OverflowInt(i) {
+			return converted, false
+		}
+		converted.SetInt(i)
+		return converted, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := exactInt(value)
+		if !ok || i < 0 || converted.OverflowUint(uint64(i)) {
+			return converted, false
+		}
+		converted.SetUint(uint64(i))
+		return converted, true
+	case reflect.Float32, reflect.Float64:
+		num, ok := value.(evaluator.Number)
+		if !ok {
+			return converted, false
+		}
+		converted.SetFloat(num.Float64())
+		return converted, true
+	case reflect.String:
+		str, ok := value.(evaluator.String)
+		converted.SetString(string(str))
+		return converted, ok
+	case reflect.Slice:
+		elements, ok := listElements(value)
+		if !ok {
+			return converted, false
+		}
+		converted = reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			v, ok := fromValue(element, t.Elem())
+			if !ok {
+				return converted, false
+			}
+			converted.Index(i).Set(v)
+		}
+		return converted, true
+	default:
+		return converted, false
+	}
+}
+
+func exactInt(value evaluator.Value) (int64, bool) {
+	num, ok := value.(evaluator.Number)
+	if !ok {
+		return 0, false
+	}
+	return num.Int64()
+}
+
+// natural picks the Go value an empty interface receives for value: int64,
+// float64, string, bool, []any for proper lists, nil for no value, or value
+// itself.
+func natural(value evaluator.Value) any {
+	switch v := value.(type) {
+	case evaluator.Void:
+		return nil
+	case evaluator.Number:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		return v.Float64()
+	case evaluator.String:
+		return string(v)
+	case evaluator.Bool:
+		return bool(v)
+	case evaluator.Nil, *evaluator.Pair:
+		elements, ok := listElements(v)
+		if !ok {
+			return value
+		}
+		naturals := make([]any, len(elements))
+		for i, element := range elements {
+			naturals[i] = natural(element)
+		}
+		return naturals
+	default:
+		return value
+	}
+}
+
+// listElements returns the elements of a proper list.
+func listElements(value evaluator.Value) ([]evaluator.Value, bool) {
+	var elements []evaluator.Value
+	for {
+		switch v := value.(type) {
+		case evaluator.Nil:
+			return elements, true
+		case *evaluator.Pair:
+			elements = append(elements, v.Car)
+			value = v.Cdr
+		default:
+			return nil, false
+		}
+	}
+}
+
+// convertible reports whether fromValue and reflectToValue handle t.
+func convertible(t reflect.Type) bool {
+	if t.Implements(valueType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return convertible(t.Elem())
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	default:
+		return false
+	}
+}
+
+// describeType names what a parameter of type t accepts, for error messages.
+func describeType(t reflect.Type) string {
+	if t.Implements(valueType) && t.Kind() != reflect.Interface {
+		return "a " + reflect.Zero(t).Interface().(evaluator.Value).Type()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("an exact integer that fits in %s", t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprintf("a non-negative exact integer that fits in %s", t)
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return fmt.Sprintf("a list of %s", t.Elem())
+	default:
+		return "any value"
+	}
+}
+
+// newBuiltin wraps the Go function fn as a procedure called name.
+func newBuiltin(name string, fn any) (*evaluator.Builtin, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("%T is not a function", fn)
+	}
+	t := f.Type()
+	for i := range t.NumIn() {
+		paramType := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		if !convertible(paramType) {
+			return nil, fmt.Errorf("unsupported parameter type %s", paramType)
+		}
+	}
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType,
+		t.NumOut() >= 1 && t.Out(0) != errorType && !convertible(t.Out(0)):
+		return nil, fmt.Errorf("unsupported results %s, expected a value, an error or both", t)
+	}
+
+	minArgs, maxArgs := t.NumIn(), t.NumIn()
+	if t.IsVariadic() {
+		minArgs, maxArgs = t.NumIn()-1, evaluator.Variadic
+	}
+	return &evaluator.Builtin{
+		Name:    name,
+		MinArgs: minArgs,
+		MaxArgs: maxArgs,
+		Fn: func(args []evaluator.Value) (result evaluator.Value, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					result, err = nil, fmt.Errorf("%s panicked: %v", name, r)
+				}
+			}()
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				paramType := t.In(min(i, t.NumIn()-1))
+				if t.IsVariadic() && i >= t.NumIn()-1 {
+					paramType = paramType.Elem()
+				}
+				v, ok := fromValue(arg, paramType)
+				if !ok {
+					return nil, fmt.Errorf("%s expects %s as argument %d but got %s", name, describeType(paramType), i+1, arg)
+				}
+				in[i] = v
+			}
+			return callResults(name, f.Call(in))
+		},
+	}, nil
+}
+
+// callResults turns what a registered function returned into a value or an
+// error.
+func callResults(name string, results []reflect.Value) (evaluator.Value, error) {
+	if len(results) == 0 {
+		return evaluator.Void{}, nil
+	}
+	last := results[len(results)-1]
+	if last.Type() == errorType {
+		if !last.IsNil() {
+			return nil, last.Interface().(error)
+		}
+		results = results[:len(results)-1]
+	}
+	if len(results) == 0 {
+		return evaluator.Void{}, nil
+	}
+	return reflectToValue(name, results[0])
+}
@@ -0,0 +1,136 @@
+// Package interpreter embeds the Simlang programming language in Go programs.
+// It wraps the lexer, parser and evaluator behind one Interpreter that keeps
+// its definitions between calls and can expose Go functions to Simlang code.
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simlang/evaluator"
+	"simlang/lexer"
+	"simlang/parser"
+)
+
+// Interpreter evaluates Simlang source in a session of its own. It is safe for
+// concurrent use; evaluations are serialized.
+type Interpreter struct {
+	session *evaluator.Session
+	limits  evaluator.Limits
+	timeout time.Duration
+}
+
+// Option configures an Interpreter in New.
+type Option func(*Interpreter)
+
+// WithLimits bounds every EvalString and Call.
+func WithLimits(limits evaluator.Limits) Option {
+	return func(in *Interpreter) {
+		in.limits = limits
+	}
+}
+
+// WithTimeout stops every EvalString and Call that runs longer than timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(in *Interpreter) {
+		in.timeout = timeout
+	}
+}
+
+// New returns an Interpreter whose environment holds the standard library.
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{session: evaluator.NewSession()}
+	for _, opt := range opts {
+		opt(in)
+	}
+	return in
+}
+
+// Define binds name to value, converted as described for RegisterFunc. A Go
+// function becomes a procedure, so Define(name, f) is RegisterFunc(name, f).
+func (in *Interpreter) Define(name string, value any) error {
+	converted, err := toValue(name, value)
+	if err != nil {
+		return fmt.Errorf("failed to define %s: %w", name, err)
+	}
+	in.session.Define(name, converted)
+	return nil
+}
+
+// RegisterFunc makes the Go function fn callable as the procedure name.
+//
+// Arguments are converted to the parameter types of fn: numbers to Go integer
+// and float types (integers must be exact and in range), strings to string,
+// booleans to bool, proper lists to slices, and any value to evaluator.Value
+// or to an empty interface, which receives int64, float64, string, bool or
+// []any where possible. A variadic fn accepts any number of trailing
+// arguments.
+//
+// fn may return nothing, one value, an error, or a value and an error. Results
+// are converted back the same way; a non-nil error, or a panic, is reported as
+// the error of the call.
+func (in *Interpreter) RegisterFunc(name string, fn any) error {
+	builtin, err := newBuiltin(name, fn)
+	if err != nil {
+		return fmt.Errorf("failed to register %s: %w", name, err)
+	}
+	in.session.Define(name, builtin)
+	return nil
+}
+
+// EvalString evaluates every form in src and returns the value of the last
+// one, converted like the argument of a function taking an empty interface:
+// an int64, a float64, a string, a bool, an []any for a proper list, nil
+// for no value, or the evaluator.Value itself for others, like procedures.
+func (in *Interpreter) EvalString(src string) (any, error) {
+	program, err := parser.ParseProgram(lexer.Toknize(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+
+	ctx, cancel := in.context()
+	defer cancel()
+	return naturalResult(in.session.EvalProgramWithOptions(ctx, program, in.limits))
+}
+
+// Call calls fn, either the name of a procedure or a procedure value such as
+// the result of EvalString, with args converted as for Define. The result is
+// as for EvalString.
+func (in *Interpreter) Call(fn any, args ...any) (any, error) {
+	f, ok := fn.(evaluator.Value)
+	if name, isName := fn.(string); isName {
+		if f, ok = in.session.Lookup(name); !ok {
+			return nil, &evaluator.UnboundError{Name: name}
+		}
+	} else if !ok {
+		return nil, fmt.Errorf("cannot call %T, expected a procedure name or value", fn)
+	}
+
+	values := make([]evaluator.Value, len(args))
+	for i, arg := range args {
+		value, err := toValue(fmt.Sprintf("argument %d", i+1), arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to call %s: %w", f, err)
+		}
+		values[i] = value
+	}
+
+	ctx, cancel := in.context()
+	defer cancel()
+	return naturalResult(in.session.Apply(ctx, f, values, in.limits))
+}
+
+func naturalResult(value evaluator.Value, err error) (any, error) {
+	if err != nil {
+		return nil, err
+	}
+	return natural(value), nil
+}
+
+func (in *Interpreter) context() (context.Context, context.CancelFunc) {
+	if in.timeout > 0 {
+		return context.WithTimeout(context.Background(), in.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
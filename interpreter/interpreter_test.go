@@ -0,0 +1,151 @@
+package interpreter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errBoom = errors.New("boom")
+
+// newTestInterpreter returns an Interpreter with Go functions of the
+// parameter and result types RegisterFunc converts.
+func newTestInterpreter(t *testing.T) *Interpreter {
+	t.Helper()
+	in := New()
+	for name, fn := range map[string]any{
+		"describe": func(x any) string { return reflect.TypeOf(x).String() },
+		"is-nil":   func(x any) bool { return x == nil },
+		"add":      func(a, b int) int { return a + b },
+		"small":    func(n int8) int8 { return n },
+		"count":    func(n uint) uint { return n },
+		"half":     func(x float64) float64 { return x / 2 },
+		"greet":    func(name string) string { return "hello " + name },
+		"sum": func(xs ...int64) int64 {
+			total := int64(0)
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"total":   func(xs []float64) float64 { return xs[0] + xs[1] },
+		"words":   func(s string) []string { return strings.Fields(s) },
+		"nothing": func() {},
+		"parse-pos": func(n int) (int, error) {
+			if n < 0 {
+				return 0, errBoom
+			}
+			return n, nil
+		},
+		"explode": func() int { panic("kaboom") },
+	} {
+		if err := in.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%q) failed: %v", name, err)
+		}
+	}
+	return in
+}
+
+// TestRegisterFunc checks how arguments and results of registered Go
+// functions are converted, and how their errors and panics are reported.
+func TestRegisterFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    any
+		wantErr string
+	}{
+		{name: "no value is nil for any", src: `(is-nil (begin))`, want: true},
+		{name: "a value is not nil for any", src: `(is-nil 0)`, want: false},
+		{name: "exact integer for any", src: `(describe 42)`, want: "int64"},
+		{name: "float for any", src: `(describe 1.5)`, want: "float64"},
+		{name: "list for any", src: `(describe '(1 "a"))`, want: "[]interface {}"},
+		{name: "ints", src: `(add 2 3)`, want: int64(5)},
+		{name: "inexact int", src: `(add 2.5 1)`, wantErr: "add expects an exact integer that fits in int as argument 1 but got 2.5"},
+		{name: "int out of range", src: `(small 300)`, wantErr: "fits in int8"},
+		{name: "negative uint", src: `(count -1)`, wantErr: "a non-negative exact integer"},
+		{name: "rational to float", src: `(half 1/2)`, want: 0.25},
+		{name: "string", src: `(greet "you")`, want: "hello you"},
+		{name: "not a string", src: `(greet 1)`, wantErr: "greet expects a string as argument 1 but got 1"},
+		{name: "variadic", src: `(sum 1 2 3)`, want: int64(6)},
+		{name: "variadic with none", src: `(sum)`, want: int64(0)},
+		{name: "list to slice", src: `(total '(1 1/2))`, want: 1.5},
+		{name: "improper list to slice", src: `(total '(1 . 2))`, wantErr: "total expects a list of float64"},
+		{name: "slice to list", src: `(words "a b c")`, want: []any{"a", "b", "c"}},
+		{name: "no result", src: `(nothing)`, want: nil},
+		{name: "value and nil error", src: `(parse-pos 7)`, want: int64(7)},
+		{name: "error", src: `(parse-pos -7)`, wantErr: "boom"},
+		{name: "panic", src: `(explode)`, wantErr: "explode panicked: kaboom"},
+		{name: "wrong number of arguments", src: `(add 1)`, wantErr: "add"},
+	}
+	in := newTestInterpreter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := in.EvalString(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EvalString(%q) = %v, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalString(%q) failed: %v", tt.src, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EvalString(%q) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := in.EvalString(`(parse-pos -1)`); !errors.Is(err, errBoom) {
+		t.Errorf("(parse-pos -1) = %v, want errBoom in the chain", err)
+	}
+}
+
+// TestCall checks calling procedures from Go by name and by value, with Go
+// arguments converted to Simlang values.
+func TestCall(t *testing.T) {
+	in := newTestInterpreter(t)
+	double, err := in.EvalString(`(lambda (x) (* x 2))`)
+	if err != nil {
+		t.Fatalf("failed to make a procedure: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		fn      any
+		args    []any
+		want    any
+		wantErr string
+	}{
+		{name: "builtin by name", fn: "+", args: []any{1, 2.5}, want: 3.5},
+		{name: "registered by name", fn: "add", args: []any{int8(2), uint16(3)}, want: int64(5)},
+		{name: "procedure value", fn: double, args: []any{21}, want: int64(42)},
+		{name: "slice argument", fn: "total", args: []any{[]float64{1, 2}}, want: 3.0},
+		{name: "nil argument", fn: "is-nil", args: []any{nil}, want: true},
+		{name: "no value", fn: "nothing", want: nil},
+		{name: "error", fn: "parse-pos", args: []any{-1}, wantErr: "boom"},
+		{name: "panic", fn: "explode", wantErr: "explode panicked: kaboom"},
+		{name: "unbound name", fn: "nope", wantErr: "nope"},
+		{name: "not callable", fn: 42, wantErr: "cannot call int"},
+		{name: "unconvertible argument", fn: "describe", args: []any{map[string]int{}}, wantErr: "cannot convert map[string]int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := in.Call(tt.fn, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Call(%v) = %v, %v, want an error containing %q", tt.fn, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call(%v) failed: %v", tt.fn, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Call(%v) = %#v, want %#v", tt.fn, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,73 +1,177 @@
 package lexer
 
 import (
+	"strings"
+	"unicode/utf8"
+
 	"simlang/types"
+	"simlang/util"
 )
 
+// Toknize splits input into tokens. Every token records the span of source
+// text it was read from.
 func Toknize(input string) []types.Token {
 	tokens := []types.Token{}
 	var current string
+	var currentStart types.Pos
+	pos := types.Pos{Offset: 0, Line: 1, Column: 1}
+
+	flush := func() {
+		if current != "" {
+			token := createToken(current)
+			token.Span = types.Span{Start: currentStart, End: pos}
+			tokens = append(tokens, token)
+			current = ""
+		}
+	}
 
-	for i := 0; i < len(input); i++ {
+	// emit adds a token for raw, which starts at the current position
+	emit := func(tokenType types.TokenType, raw string) {
+		end := pos.Advance(raw)
+		tokens = append(tokens, types.Token{Type: tokenType, Value: raw, Span: types.Span{Start: pos, End: end}})
+		pos = end
+	}
+
+	for i := 0; i < len(input); {
 		ch := input[i]
 
-		switch ch {
-		case '(':
-			tokens = append(tokens, types.Token{Type: types.LPAREN, Value: "("})
-		case ')':
-			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
-			}
-			tokens = append(tokens, types.Token{Type: types.RPAREN, Value: ")"})
-		case ' ', '\n', '\t':
-			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
-			}
+		switch {
+		case ch == '(':
+			flush()
+			emit(types.LPAREN, "(")
+			i++
+		case ch == ')':
+			flush()
+			emit(types.RPAREN, ")")
+			i++
+		case ch == '\'':
+			flush()
+			emit(types.QUOTE, "'")
+			i++
+		case ch == '`':
+			flush()
+			emit(types.QUASIQUOTE, "`")
+			i++
+		case ch == ',' && strings.HasPrefix(input[i:], ",@"):
+			flush()
+			emit(types.UNQUOTE_SPLICING, ",@")
+			i += 2
+		case ch == ',':
+			flush()
+			emit(types.UNQUOTE, ",")
+			i++
+		case ch == '"':
+			// the raw literal is kept, escapes are decoded by the parser
+			flush()
+			raw := input[i:scanString(input, i)]
+			emit(types.STRING, raw)
+			i += len(raw)
+		case ch == ';', i == 0 && strings.HasPrefix(input, "#!"):
+			// line comment, or the shebang line of an executable script
+			flush()
+			raw := input[i:scanLine(input, i)]
+			emit(types.COMMENT, raw)
+			i += len(raw)
+		case current == "" && strings.HasPrefix(input[i:], "#|"):
+			raw := input[i:scanBlockComment(input, i)]
+			emit(types.COMMENT, raw)
+			i += len(raw)
+		case ch == ' ', ch == '\n', ch == '\t', ch == '\r':
+			flush()
+			pos = pos.Advance(input[i : i+1])
+			i++
 		default:
-			current += string(ch)
+			if current == "" {
+				currentStart = pos
+			}
+			// a whole character, so the word keeps the bytes of the input
+			_, size := utf8.DecodeRuneInString(input[i:])
+			current += input[i : i+size]
+			pos = pos.Advance(input[i : i+size])
+			i += size
 		}
 	}
+	flush()
 
 	return tokens
 }
 
+// scanLine returns the index of the newline ending the line that contains
+// input[start], or len(input) on the last line.
+func scanLine(input string, start int) int {
+	if newline := strings.IndexByte(input[start:], '\n'); newline >= 0 {
+		return start + newline
+	}
+	return len(input)
+}
+
+// scanBlockComment returns the index just past the |# closing the block
+// comment starting at input[start], or len(input) if it is never closed.
+func scanBlockComment(input string, start int) int {
+	if end := strings.Index(input[start+2:], "|#"); end >= 0 {
+		return start + 2 + end + 2
+	}
+	return len(input)
+}
+
+// scanString returns the index just past the string literal starting at
+// input[start], or len(input) if the literal is never closed.
+func scanString(input string, start int) int {
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(input)
+}
+
+// WordToken returns the token a word like let, 42 or x reads as. Its span is
+// left empty.
+func WordToken(word string) types.Token {
+	return createToken(word)
+}
+
 func createToken(value string) types.Token {
 	// 숫자인지 확인
-	if isNumber(value) {
+	if util.LooksLikeNumber(value) {
 		return types.Token{Type: types.NUMBER, Value: value}
 	}
 
 	switch value {
 	case "let":
 		return types.Token{Type: types.LET, Value: value}
+	case "let*":
+		return types.Token{Type: types.LETSTAR, Value: value}
+	case "letrec":
+		return types.Token{Type: types.LETREC, Value: value}
 	case "in":
 		return types.Token{Type: types.IN, Value: value}
 	case "lambda":
 		return types.Token{Type: types.LAMBDA, Value: value}
+	case "define":
+		return types.Token{Type: types.DEFINE, Value: value}
+	case "defmacro":
+		return types.Token{Type: types.DEFMACRO, Value: value}
+	case "define-syntax":
+		return types.Token{Type: types.DEFINE_SYNTAX, Value: value}
+	case "set!":
+		return types.Token{Type: types.SET, Value: value}
+	case "begin":
+		return types.Token{Type: types.BEGIN, Value: value}
+	case "if":
+		return types.Token{Type: types.IF, Value: value}
+	case "cond":
+		return types.Token{Type: types.COND, Value: value}
+	case "and":
+		return types.Token{Type: types.AND, Value: value}
+	case "or":
+		return types.Token{Type: types.OR, Value: value}
+	case "#t", "#f", "#true", "#false":
+		return types.Token{Type: types.BOOLEAN, Value: value}
 	}
 
 	return types.Token{Type: types.ATOM, Value: value}
 }
-
-func isNumber(s string) bool {
-	if len(s) == 0 {
-		return false
-	}
-
-	start := 0
-	if s[0] == '-' || s[0] == '+' {
-		if len(s) == 1 {
-			return false
-		}
-		start = 1
-	}
-
-	for i := start; i < len(s); i++ {
-		if s[i] < '0' || s[i] > '9' {
-			return false
-		}
-	}
-	return true
-}
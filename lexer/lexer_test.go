@@ -0,0 +1,21 @@
+package lexer
+
+import "testing"
+
+// TestToknizeNonASCII checks that words keep the bytes of non-ASCII
+// characters and that columns count runes.
+func TestToknizeNonASCII(t *testing.T) {
+	tokens := Toknize(`'(é ü) "ü" a→b`)
+	want := []string{"'", "(", "é", "ü", ")", `"ü"`, "a→b"}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens %v, want %q", len(tokens), tokens, want)
+	}
+	for i, token := range tokens {
+		if token.Value != want[i] {
+			t.Errorf("token %d = %q, want %q", i, token.Value, want[i])
+		}
+	}
+	if last := tokens[len(tokens)-1]; last.Start.Column != 12 || last.End.Column != 15 {
+		t.Errorf("a→b spans columns %d to %d, want 12 to 15", last.Start.Column, last.End.Column)
+	}
+}
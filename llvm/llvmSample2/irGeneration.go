@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 
+	"simlang/evaluator"
 	"simlang/types"
 )
 
@@ -17,6 +19,8 @@ type IRGenerationContext struct {
 	nextVariableIndex uint32
 	instructions      []string
 	lookup            *IRRegisterLookup
+	// intrinsics used so far, which the module has to declare
+	intrinsics map[string]bool
 }
 
 func NewIRGenerationContext() *IRGenerationContext {
@@ -27,9 +31,35 @@ func NewIRGenerationContext() *IRGenerationContext {
 			prev: nil,
 			dict: map[string]IRValue{},
 		},
+		intrinsics: map[string]bool{},
 	}
 }
 
+// binaryInstructions lowers stdlib arithmetic to instructions on doubles.
+// Calls with more arguments are folded from the left.
+var binaryInstructions = map[string]string{
+	"+": "fadd",
+	"-": "fsub",
+	"*": "fmul",
+	"/": "fdiv",
+}
+
+// intrinsics lowers stdlib procedures to LLVM intrinsics. min and max take
+// two arguments and are folded like binaryInstructions, the rest take one.
+var intrinsics = map[string]string{
+	"abs":      "llvm.fabs.f64",
+	"sqrt":     "llvm.sqrt.f64",
+	"exp":      "llvm.exp.f64",
+	"sin":      "llvm.sin.f64",
+	"cos":      "llvm.cos.f64",
+	"floor":    "llvm.floor.f64",
+	"ceiling":  "llvm.ceil.f64",
+	"round":    "llvm.roundeven.f64",
+	"truncate": "llvm.trunc.f64",
+	"min":      "llvm.minnum.f64",
+	"max":      "llvm.maxnum.f64",
+}
+
 func (c *IRGenerationContext) astToLLVMIR(ast *types.AST) (string, error) {
 	root := ast.Root
 	return c.nodeToLLVMIR(root)
@@ -66,7 +96,7 @@ func (c *IRGenerationContext) nodeToLLVMIRValue(node types.ASTNode) (IRValue, er
 		return &NumberLiteral{Value: v.Value}, nil
 
 	case *types.SymbolNode:
-		irValue := c.lookup.dict[v.Name]
+		irValue := c.lookup.find(v.Name)
 		if irValue != nil {
 			return irValue, nil
 		}
@@ -76,31 +106,50 @@ func (c *IRGenerationContext) nodeToLLVMIRValue(node types.ASTNode) (IRValue, er
 		return c.callNodeToLLVMIRValue(v)
 
 	case *types.LetNode:
-		c.pushLookup()
-		defer c.popLookup()
-		for argName, arg := range v.LetEnv {
-			irValue, err := c.nodeToLLVMIRValue(arg)
-			if err != nil {
-				return nil, fmt.Errorf("failed to nodeToLLVMIRValue arg: %w", err)
-			}
-			if err := c.PutLookup(argName, irValue); err != nil {
-				return nil, fmt.Errorf("failed to PutLookup: %w", err)
-			}
-		}
-
-		return c.nodeToLLVMIRValue(v.Body)
+		return c.letNodeToLLVMIRValue(v)
 	}
 
 	return nil, fmt.Errorf("not implemented yet %v", node)
 }
 
-/* PutFAddInstruction returns new variable name */
-func (c *IRGenerationContext) PutFAddInstruction(arg0 IRValue, arg1 IRValue) RegisterName {
+/* PutBinaryInstruction returns new variable name */
+func (c *IRGenerationContext) PutBinaryInstruction(instruction string, arg0 IRValue, arg1 IRValue) RegisterName {
+	varName := c.AcquireNextTempVariableName()
+	c.instructions = append(c.instructions, fmt.Sprintf("%s = %s double %s, %s", varName, instruction, arg0.toIRValue(), arg1.toIRValue()))
+	return RegisterName{Name: varName}
+}
+
+/* PutIntrinsicCall returns new variable name */
+func (c *IRGenerationContext) PutIntrinsicCall(intrinsic string, args ...IRValue) RegisterName {
+	c.intrinsics[intrinsic] = true
+	irArgs := make([]string, len(args))
+	for i, arg := range args {
+		irArgs[i] = "double " + arg.toIRValue()
+	}
 	varName := c.AcquireNextTempVariableName()
-	c.instructions = append(c.instructions, fmt.Sprintf("%s = fadd double %s, %s", varName, arg0.toIRValue(), arg1.toIRValue()))
+	c.instructions = append(c.instructions, fmt.Sprintf("%s = call double @%s(%s)", varName, intrinsic, strings.Join(irArgs, ", ")))
 	return RegisterName{Name: varName}
 }
 
+// MakeDeclarations declares the intrinsics the generated code calls.
+func (c *IRGenerationContext) MakeDeclarations() string {
+	names := make([]string, 0, len(c.intrinsics))
+	for name := range c.intrinsics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stringbuilder := strings.Builder{}
+	for _, name := range names {
+		params := "double"
+		if name == intrinsics["min"] || name == intrinsics["max"] {
+			params = "double, double"
+		}
+		stringbuilder.WriteString(fmt.Sprintf("declare double @%s(%s)\n", name, params))
+	}
+	return stringbuilder.String()
+}
+
 func (c *IRGenerationContext) AcquireNextTempVariableName() string {
 	name := fmt.Sprintf("%%temp.%d", c.nextVariableIndex)
 	c.nextVariableIndex += 1
@@ -119,38 +168,124 @@ func (c *IRGenerationContext) MakeFunctionBody() string {
 	return stringbuilder.String()
 }
 
+// callNodeToLLVMIRValue lowers a call to a stdlib procedure. The stdlib
+// registry decides which names exist and how many arguments they take; the
+// generator supports the subset in binaryInstructions and intrinsics.
 func (c *IRGenerationContext) callNodeToLLVMIRValue(callNode *types.CallNode) (IRValue, error) {
-	if _, ok := callNode.Function.(*types.SymbolNode); !ok {
+	symbol, ok := callNode.Function.(*types.SymbolNode)
+	if !ok {
 		return nil, fmt.Errorf("function is not symbol")
 	}
-	if callNode.Function.(*types.SymbolNode).Name != "+" {
-		return nil, fmt.Errorf("function name is not +")
+	builtin, ok := evaluator.LookupStdlib(symbol.Name)
+	if !ok {
+		return nil, fmt.Errorf("function %s not found", symbol.Name)
 	}
-
-	if len(callNode.Args) == 0 {
-		return nil, fmt.Errorf("the number of arguments should be greater than zero")
+	if err := builtin.CheckArity(len(callNode.Args)); err != nil {
+		return nil, err
 	}
 
-	sumName, arg0Err := c.nodeToLLVMIRValue(callNode.Args[0])
-	if arg0Err != nil {
-		return nil, fmt.Errorf("failed to generate ir from arg0 %w", arg0Err)
+	args := make([]IRValue, len(callNode.Args))
+	for i, arg := range callNode.Args {
+		argI, argErr := c.nodeToLLVMIRValue(arg)
+		if argErr != nil {
+			return nil, fmt.Errorf("failed to nodeToLLVMIRValue arg: %w", argErr)
+		}
+		args[i] = argI
 	}
 
-	for index, arg := range callNode.Args {
-		if index == 0 {
-			continue
+	if instruction, ok := binaryInstructions[symbol.Name]; ok {
+		switch {
+		case len(args) == 0:
+			// (+) and (*)
+			identity := 0.0
+			if symbol.Name == "*" {
+				identity = 1.0
+			}
+			return &NumberLiteral{Value: identity}, nil
+		case len(args) == 1 && (symbol.Name == "-" || symbol.Name == "/"):
+			// (- x) is 0 - x and (/ x) is 1 / x
+			identity := 0.0
+			if symbol.Name == "/" {
+				identity = 1.0
+			}
+			args = append([]IRValue{&NumberLiteral{Value: identity}}, args...)
 		}
+		return c.foldArgs(args, func(arg0, arg1 IRValue) RegisterName {
+			return c.PutBinaryInstruction(instruction, arg0, arg1)
+		}), nil
+	}
 
-		argI, argErr := c.nodeToLLVMIRValue(arg)
-		if argErr != nil {
-			return nil, fmt.Errorf("failed to nodeToLLVMIRValue arg: %w", argErr)
+	if intrinsic, ok := intrinsics[symbol.Name]; ok {
+		if symbol.Name == "min" || symbol.Name == "max" {
+			return c.foldArgs(args, func(arg0, arg1 IRValue) RegisterName {
+				return c.PutIntrinsicCall(intrinsic, arg0, arg1)
+			}), nil
 		}
+		result := c.PutIntrinsicCall(intrinsic, args[0])
+		return &result, nil
+	}
+
+	return nil, fmt.Errorf("%s is not supported by the LLVM generator yet", symbol.Name)
+}
 
-		addResult := c.PutFAddInstruction(sumName, argI)
-		sumName = &addResult
+// foldArgs combines args from the left with put, e.g. (+ a b c) as (a + b) + c.
+func (c *IRGenerationContext) foldArgs(args []IRValue, put func(arg0, arg1 IRValue) RegisterName) IRValue {
+	result := args[0]
+	for _, arg := range args[1:] {
+		register := put(result, arg)
+		result = &register
+	}
+	return result
+}
+
+func (c *IRGenerationContext) letNodeToLLVMIRValue(letNode *types.LetNode) (IRValue, error) {
+	switch letNode.Kind {
+	case types.LETSTAR:
+		// every binding gets its own scope so it can see (and shadow) the previous ones
+		for _, binding := range letNode.Bindings {
+			irValue, err := c.nodeToLLVMIRValue(binding.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to nodeToLLVMIRValue let* value %s: %w", binding.Name.Name, err)
+			}
+			c.pushLookup()
+			defer c.popLookup()
+			if err := c.PutLookup(binding.Name.Name, irValue); err != nil {
+				return nil, fmt.Errorf("failed to PutLookup: %w", err)
+			}
+		}
+	case types.LETREC:
+		// values are generated inside the new scope, so they can refer to bindings defined before them
+		c.pushLookup()
+		defer c.popLookup()
+		for _, binding := range letNode.Bindings {
+			irValue, err := c.nodeToLLVMIRValue(binding.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to nodeToLLVMIRValue letrec value %s: %w", binding.Name.Name, err)
+			}
+			if err := c.PutLookup(binding.Name.Name, irValue); err != nil {
+				return nil, fmt.Errorf("failed to PutLookup: %w", err)
+			}
+		}
+	default:
+		// all values are generated in the outer scope before any binding is visible
+		irValues := make([]IRValue, len(letNode.Bindings))
+		for i, binding := range letNode.Bindings {
+			irValue, err := c.nodeToLLVMIRValue(binding.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to nodeToLLVMIRValue let value %s: %w", binding.Name.Name, err)
+			}
+			irValues[i] = irValue
+		}
+		c.pushLookup()
+		defer c.popLookup()
+		for i, binding := range letNode.Bindings {
+			if err := c.PutLookup(binding.Name.Name, irValues[i]); err != nil {
+				return nil, fmt.Errorf("failed to PutLookup: %w", err)
+			}
+		}
 	}
 
-	return sumName, nil
+	return c.nodeToLLVMIRValue(letNode.Body)
 }
 
 func (c *IRGenerationContext) PutLookup(name string, irValue IRValue) error {
@@ -166,6 +301,16 @@ func (c *IRGenerationContext) PutLookup(name string, irValue IRValue) error {
 	return nil
 }
 
+// find looks name up in this scope and then in the enclosing ones.
+func (l *IRRegisterLookup) find(name string) IRValue {
+	for lookup := l; lookup != nil; lookup = lookup.prev {
+		if irValue, ok := lookup.dict[name]; ok {
+			return irValue
+		}
+	}
+	return nil
+}
+
 func (c *IRGenerationContext) pushLookup() {
 	c.lookup = &IRRegisterLookup{
 		prev: c.lookup,
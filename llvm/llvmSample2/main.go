@@ -14,7 +14,7 @@ import (
 func main() {
 	fmt.Println("Hello, Go Project!")
 
-	ast, err := parser.Parse(lexer.Toknize("(let (x 10) in (+ x (let (x 5) in x)))"))
+	ast, err := parser.Parse(lexer.Toknize("(let* ((x 10) (y (+ x 1))) in (+ x (* y 2) (sqrt (let ((x 16)) in x))))"))
 
 	if err != nil {
 		log.Fatalf("failed to parse %v", err)
@@ -37,7 +37,7 @@ func main() {
 	// ret i32 %3
 
 	functionBody := body
-	llvmIR, err := fillTemplate(functionBody)
+	llvmIR, err := fillTemplate(functionBody, iRGenerationContext.MakeDeclarations())
 	if err != nil {
 		log.Fatalf("failed to fill template %v", err)
 	}
@@ -50,10 +50,11 @@ func main() {
 	log.Printf("Successfully wrote LLVM IR to %s", filename)
 }
 
-func fillTemplate(functionBody string) (string, error) {
+func fillTemplate(functionBody string, declarations string) (string, error) {
 	llvmIRTemplate := `; ModuleID = 'simple_module'
 source_filename = "simple_program.ll"
 declare i32 @printf(ptr, ...)
+{{.declarations}}
 
 @pat = global [14 x i8] c"answer is %f\0A\00"
 
@@ -79,6 +80,7 @@ define i32 @main() {
 	var llvmIRSB strings.Builder
 	err = t.Execute(&llvmIRSB, map[string]any{
 		"functionBody": functionBody,
+		"declarations": declarations,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to execute template: %v", err)
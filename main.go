@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
+
 	"simlang/evaluator"
 	"simlang/lexer"
 	"simlang/parser"
@@ -14,13 +17,25 @@ import (
 
 func main() {
 	mode := flag.String("mode", "terminal", "Interface mode (terminal/web)")
+	file := flag.String("file", "", "Run a source file instead of starting an interface (also accepted as the first argument)")
+	timeout := flag.Duration("timeout", ui.DefaultEvalTimeout, "Time limit for each evaluation in web mode")
 	flag.Parse()
 
+	if *file == "" && flag.NArg() > 0 {
+		*file = flag.Arg(0)
+	}
+	if *file != "" {
+		if err := runFile(*file); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	switch *mode {
 	case "terminal":
 		runTerminalUI()
 	case "web":
-		runWebUI()
+		runWebUI(*timeout)
 	default:
 		fmt.Println("Invalid mode. Use 'terminal' or 'web'")
 		os.Exit(1)
@@ -30,6 +45,7 @@ func main() {
 func runTerminalUI() {
 	ui.PrintWelcome()
 
+	session := evaluator.NewSession()
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		ui.PrintPrompt()
@@ -41,25 +57,75 @@ func runTerminalUI() {
 		if input == "exit" {
 			break
 		}
+		if source, ok := strings.CutPrefix(input, ":expand "); ok {
+			printExpansion(session, source)
+			continue
+		}
 
 		ast, err := parser.Parse(lexer.Toknize(input))
 		if err != nil {
-			ui.PrintError(err.Error())
+			ui.PrintError(input, err)
 			continue
 		}
 
-		result, err := evaluator.Eval(ast)
+		result, err := session.Eval(ast)
 		if err != nil {
-			ui.PrintError(err.Error())
+			ui.PrintError(input, err)
 			continue
 		}
 
-		ui.PrintResult(fmt.Sprintf("%v", result))
+		// define and friends have no value worth printing
+		if _, ok := result.(evaluator.Void); !ok {
+			ui.PrintResult(result.String())
+		}
+	}
+}
+
+// printExpansion prints source with every macro use in it expanded, for the
+// :expand command of the terminal UI.
+func printExpansion(session *evaluator.Session, source string) {
+	ast, err := parser.Parse(lexer.Toknize(source))
+	if err != nil {
+		ui.PrintError(source, err)
+		return
+	}
+
+	expanded, err := session.Expand(ast)
+	if err != nil {
+		ui.PrintError(source, err)
+		return
+	}
+	ui.PrintResult(evaluator.Quote(expanded.Root).String())
+}
+
+// runFile evaluates every form of the file at path, top to bottom. Errors are
+// reported on stderr.
+func runFile(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	program, err := parser.ParseProgram(lexer.Toknize(string(source)))
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+		ui.FprintError(os.Stderr, string(source), err)
+		return err
+	}
+
+	if _, err := evaluator.NewSession().EvalProgram(program); err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+		ui.FprintError(os.Stderr, string(source), err)
+		return err
 	}
+	return nil
 }
 
-func runWebUI() {
+func runWebUI(timeout time.Duration) {
 	fmt.Println("Starting web server on http://localhost:8080")
-	http.Handle("/", ui.NewWebUI())
+	webUI := ui.NewWebUI()
+	webUI.Timeout = timeout
+	http.Handle("/", webUI)
 	http.ListenAndServe(":8080", nil)
 }
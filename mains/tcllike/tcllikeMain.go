@@ -15,6 +15,17 @@ func main() {
 	lpe(`print [+ 1 2]`)
 	lpe(`print (1 + 2)
     print 3`)
+	lpe(`set x 3
+    print [+ $x 1]`)
+	lpe(`proc add {a {b 10}} {
+        return [+ $a $b]
+    }
+    print [add 1] [add 1 2]`)
+	lpe(`for {set i 0} {$i < 3} {incr i} {
+        if {$i == 1} {continue}
+        print $i
+    }`)
+	lpe(`print [expr {1 + 2 * 3}] (7 / 2) (7.0 / 2) (2 ** 10) [expr {sqrt(16)}]`)
 }
 
 func lpe(code string) {
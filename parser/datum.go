@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+
+	"simlang/lexer"
+	"simlang/types"
+	"simlang/util"
+)
+
+// ParseDatum parses a datum, such as the code a macro builds, the way Parse
+// parses source text: (if c a b) becomes an IfNode and so on. Every node gets
+// the span of the datum it comes from.
+func ParseDatum(datum types.ASTNode) (types.ASTNode, error) {
+	tokens, err := datumTokens(datum, nil)
+	if err != nil {
+		return nil, err
+	}
+	parsingContext := ParsingContext{tokens: tokens, currentTokenIndex: 0}
+	return parseSingle(&parsingContext)
+}
+
+// datumTokens appends the tokens datum is written as to tokens.
+func datumTokens(datum types.ASTNode, tokens []types.Token) ([]types.Token, error) {
+	span := datum.SourceSpan()
+	switch v := datum.(type) {
+	case *types.ListNode:
+		tokens = append(tokens, types.Token{Type: types.LPAREN, Value: "(", Span: span})
+		var err error
+		for _, element := range v.Elements {
+			if tokens, err = datumTokens(element, tokens); err != nil {
+				return nil, err
+			}
+		}
+		if v.Tail != nil {
+			tokens = append(tokens, types.Token{Type: types.ATOM, Value: ".", Span: span})
+			if tokens, err = datumTokens(v.Tail, tokens); err != nil {
+				return nil, err
+			}
+		}
+		return append(tokens, types.Token{Type: types.RPAREN, Value: ")", Span: span}), nil
+	case *types.SymbolNode:
+		token := lexer.WordToken(v.Name)
+		token.Span = span
+		return append(tokens, token), nil
+	case *types.NumberNode:
+		var literal string
+		switch {
+		case v.Exact != nil:
+			literal = v.Exact.RatString()
+		case math.IsInf(v.Value, 0) || math.IsNaN(v.Value):
+			return nil, &ParseError{Span: span, Expected: "finite number in code", Found: strconv.FormatFloat(v.Value, 'g', -1, 64)}
+		default:
+			// the exponent keeps the literal inexact, e.g. 1e+00 for 1.0
+			literal = strconv.FormatFloat(v.Value, 'e', -1, 64)
+		}
+		return append(tokens, types.Token{Type: types.NUMBER, Value: literal, Span: span}), nil
+	case *types.StringNode:
+		return append(tokens, types.Token{Type: types.STRING, Value: util.Quote(v.Value), Span: span}), nil
+	case *types.BoolNode:
+		literal := "#f"
+		if v.Value {
+			literal = "#t"
+		}
+		return append(tokens, types.Token{Type: types.BOOLEAN, Value: literal, Span: span}), nil
+	default:
+		return nil, &ParseError{Span: span, Expected: "datum", Found: datum.String()}
+	}
+}
+
+// IsKeyword reports whether name means something of its own in code, like
+// let or else, rather than naming a variable.
+func IsKeyword(name string) bool {
+	if lexer.WordToken(name).Type != types.ATOM {
+		return true
+	}
+	switch name {
+	case "quote", "quasiquote", "unquote", "unquote-splicing", "else", "syntax-rules", ".":
+		return true
+	default:
+		return false
+	}
+}
+
+var letKeywords = map[types.TokenType]string{
+	types.LET:     "let",
+	types.LETSTAR: "let*",
+	types.LETREC:  "letrec",
+}
+
+// Unparse turns code back into the datum it is written as, e.g. to pass it
+// to a macro. Shorthands are spelled out: 'x becomes (quote x) and
+// (define (f x) body) becomes (define f (lambda (x) body)).
+func Unparse(node types.ASTNode) types.ASTNode {
+	span := node.SourceSpan()
+	symbol := func(name string) types.ASTNode {
+		return &types.SymbolNode{Span: span, Name: name}
+	}
+	list := func(elements ...types.ASTNode) *types.ListNode {
+		return &types.ListNode{Span: span, Elements: elements}
+	}
+	symbols := func(names []*types.SymbolNode) *types.ListNode {
+		elements := make([]types.ASTNode, len(names))
+		for i, name := range names {
+			elements[i] = name
+		}
+		return list(elements...)
+	}
+
+	switch v := node.(type) {
+	case *types.CallNode:
+		elements := []types.ASTNode{Unparse(v.Function)}
+		for _, arg := range v.Args {
+			elements = append(elements, Unparse(arg))
+		}
+		return list(elements...)
+	case *types.LetNode:
+		bindings := make([]types.ASTNode, len(v.Bindings))
+		for i, binding := range v.Bindings {
+			bindings[i] = list(binding.Name, Unparse(binding.Value))
+		}
+		return list(symbol(letKeywords[v.Kind]), list(bindings...), symbol("in"), Unparse(v.Body))
+	case *types.IfNode:
+		if v.Else == nil {
+			return list(symbol("if"), Unparse(v.Cond), Unparse(v.Then))
+		}
+		return list(symbol("if"), Unparse(v.Cond), Unparse(v.Then), Unparse(v.Else))
+	case *types.CondNode:
+		elements := []types.ASTNode{symbol("cond")}
+		for _, clause := range v.Clauses {
+			elements = append(elements, list(Unparse(clause.Test), Unparse(clause.Body)))
+		}
+		if v.Else != nil {
+			elements = append(elements, list(symbol("else"), Unparse(v.Else)))
+		}
+		return list(elements...)
+	case *types.LogicalNode:
+		elements := []types.ASTNode{symbol("and")}
+		if v.Op == types.OR {
+			elements[0] = symbol("or")
+		}
+		for _, arg := range v.Args {
+			elements = append(elements, Unparse(arg))
+		}
+		return list(elements...)
+	case *types.DefineNode:
+		return list(symbol("define"), v.Name, Unparse(v.Value))
+	case *types.SetNode:
+		return list(symbol("set!"), v.Name, Unparse(v.Value))
+	case *types.BeginNode:
+		elements := []types.ASTNode{symbol("begin")}
+		for _, expr := range v.Body {
+			elements = append(elements, Unparse(expr))
+		}
+		return list(elements...)
+	case *types.LambdaNode:
+		return list(symbol("lambda"), symbols(v.Args), Unparse(v.Body))
+	case *types.DefmacroNode:
+		return list(symbol("defmacro"), v.Name, symbols(v.Params), Unparse(v.Body))
+	case *types.DefineSyntaxNode:
+		rules := []types.ASTNode{symbol("syntax-rules"), symbols(v.Literals)}
+		for _, rule := range v.Rules {
+			rules = append(rules, list(rule.Pattern, rule.Template))
+		}
+		return list(symbol("define-syntax"), v.Name, list(rules...))
+	case *types.QuoteNode:
+		if empty, ok := v.Datum.(*types.ListNode); ok && len(empty.Elements) == 0 && empty.Tail == nil && v.Span == empty.Span {
+			// () in code is read as '(), see parseFromLParen
+			return empty
+		}
+		return list(symbol("quote"), v.Datum)
+	case *types.QuasiquoteNode:
+		return list(symbol("quasiquote"), Unparse(v.Template))
+	case *types.UnquoteNode:
+		if v.Splicing {
+			return list(symbol("unquote-splicing"), Unparse(v.Expr))
+		}
+		return list(symbol("unquote"), Unparse(v.Expr))
+	case *types.ListNode:
+		// a template may hold unquotes anywhere
+		elements := make([]types.ASTNode, len(v.Elements))
+		for i, element := range v.Elements {
+			elements[i] = Unparse(element)
+		}
+		unparsed := list(elements...)
+		if v.Tail != nil {
+			unparsed.Tail = Unparse(v.Tail)
+		}
+		return unparsed
+	default:
+		return node
+	}
+}
@@ -6,103 +6,244 @@ package parser
 import (
 	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 
 	"simlang/types"
 	"simlang/util"
 )
 
+// ParseError is returned for every input Parse cannot turn into an AST. It
+// names what the parser was looking for and what it found instead.
+type ParseError struct {
+	types.Span
+	Expected string
+	Found    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: expected %s but found %s", e.Start, e.Expected, e.Found)
+}
+
+func expectedAt(token types.Token, expected string) *ParseError {
+	return &ParseError{Span: token.Span, Expected: expected, Found: describeToken(token)}
+}
+
+// wrapParse adds that parsing what failed to err, unless err already points
+// at the offending input. Without that, an error deep in a nested form would
+// carry one prefix for every form around it.
+func wrapParse(what string, err error) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return err
+	}
+	return fmt.Errorf("failed to parse %s: %w", what, err)
+}
+
+func describeToken(token types.Token) string {
+	if token.Type == types.EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%s %q", token.Type, token.Value)
+}
+
+// MaxNestingDepth bounds how deeply forms may nest, so that a long run of
+// opening parens or quotes is a ParseError rather than a stack overflow.
+const MaxNestingDepth = 10_000
+
 type ParsingContext struct {
 	tokens            []types.Token
 	currentTokenIndex int
+	// depth counts the forms being parsed around the current token, and
+	// outermost is the token that opened the first of them.
+	depth     int
+	outermost types.Token
 }
 
+// enter starts parsing a form that may nest further forms. Every successful
+// enter must be paired with a leave.
+func (p *ParsingContext) enter() error {
+	if p.depth == 0 {
+		p.outermost = p.currentToken()
+	}
+	if p.depth >= MaxNestingDepth {
+		return &ParseError{Span: p.outermost.Span, Expected: fmt.Sprintf("at most %d levels of nesting", MaxNestingDepth), Found: "more"}
+	}
+	p.depth++
+	return nil
+}
+
+func (p *ParsingContext) leave() {
+	p.depth--
+}
+
+// currentToken returns an EOF token once every token has been consumed, so
+// callers never index past the end of the input.
 func (p *ParsingContext) currentToken() types.Token {
+	if p.currentTokenIndex >= len(p.tokens) {
+		return p.eofToken()
+	}
 	return p.tokens[p.currentTokenIndex]
 }
 
+func (p *ParsingContext) eofToken() types.Token {
+	end := types.Pos{Offset: 0, Line: 1, Column: 1}
+	if len(p.tokens) > 0 {
+		end = p.tokens[len(p.tokens)-1].End
+	}
+	return types.Token{Type: types.EOF, Span: types.Span{Start: end, End: end}}
+}
+
 func (p *ParsingContext) hasNextToken() bool {
 	return p.currentTokenIndex < len(p.tokens)
 }
 
 func (p *ParsingContext) consume() types.Token {
-	if p.currentTokenIndex >= len(p.tokens) {
-		panic("unexpected end of input in consume")
-	}
-
 	token := p.currentToken()
 	p.currentTokenIndex++
 	return token
 }
 
 func (p *ParsingContext) back() {
-	if p.currentTokenIndex == 0 {
-		panic("unexpected end of input in back")
+	if p.currentTokenIndex > 0 {
+		p.currentTokenIndex--
 	}
-	p.currentTokenIndex--
 }
 
+// Parse turns tokens into an AST. It never panics: every malformed or
+// truncated input is reported as a *ParseError.
 func Parse(tokens []types.Token) (*types.AST, error) {
+	tokens, err := withoutTrivia(tokens)
+	if err != nil {
+		return nil, err
+	}
+	parsingContext := ParsingContext{tokens: tokens, currentTokenIndex: 0}
 	if len(tokens) == 0 {
-		return nil, errors.New("empty input")
+		return nil, expectedAt(parsingContext.eofToken(), "expression")
 	}
 
-	parsingContext := ParsingContext{tokens: tokens, currentTokenIndex: 0}
 	node, err := parseSingle(&parsingContext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse %v: %w", tokens, err)
+		return nil, wrapParse("expression", err)
 	}
 	if parsingContext.hasNextToken() {
-		return nil, fmt.Errorf("node is parsed but tokens remains, node: %v", node)
+		return nil, expectedAt(parsingContext.currentToken(), "end of input")
 	}
 
 	return &types.AST{Root: node}, nil
 }
 
-func parseFloat64(value string) float64 {
-	if value == "" {
-		return 0
+// withoutTrivia drops comments from tokens. A block comment that is never
+// closed is reported as an error.
+func withoutTrivia(tokens []types.Token) ([]types.Token, error) {
+	filtered := make([]types.Token, 0, len(tokens))
+	for _, token := range tokens {
+		if !token.Type.IsTrivia() {
+			filtered = append(filtered, token)
+			continue
+		}
+		if strings.HasPrefix(token.Value, "#|") && (len(token.Value) < 4 || !strings.HasSuffix(token.Value, "|#")) {
+			return nil, &ParseError{Span: types.Span{Start: token.Start, End: token.Start.Advance("#|")}, Expected: "|# closing the block comment", Found: "end of input"}
+		}
 	}
-	f, err := strconv.ParseFloat(value, 64)
+	return filtered, nil
+}
+
+// ParseProgram parses every top-level form in tokens, e.g. a whole source
+// file. An empty input is an empty program.
+func ParseProgram(tokens []types.Token) (*types.Program, error) {
+	tokens, err := withoutTrivia(tokens)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	parsingContext := ParsingContext{tokens: tokens, currentTokenIndex: 0}
+	forms := make([]types.ASTNode, 0)
+	for parsingContext.hasNextToken() {
+		node, err := parseSingle(&parsingContext)
+		if err != nil {
+			return nil, wrapParse(fmt.Sprintf("form %d", len(forms)+1), err)
+		}
+		forms = append(forms, node)
+	}
+	return &types.Program{Forms: forms}, nil
+}
+
+func parseNumber(token types.Token) (*types.NumberNode, error) {
+	literal, numberErr := util.ParseNumber(token.Value)
+	if numberErr != nil {
+		start := token.Start.Advance(token.Value[:numberErr.Offset])
+		end := token.End
+		if numberErr.Offset < len(token.Value) {
+			end = start.Advance(token.Value[numberErr.Offset : numberErr.Offset+1])
+		}
+		return nil, &ParseError{Span: types.Span{Start: start, End: end}, Expected: numberErr.Expected, Found: numberErr.Found}
+	}
+	return &types.NumberNode{Span: token.Span, Value: literal.Float, Exact: literal.Exact}, nil
+}
+
+func parseString(token types.Token) (*types.StringNode, error) {
+	value, quoteErr := util.Unquote(token.Value)
+	if quoteErr != nil {
+		start := token.Start.Advance(token.Value[:quoteErr.Offset])
+		end := token.End
+		if quoteErr.Offset < len(token.Value) {
+			end = start.Advance(token.Value[quoteErr.Offset : quoteErr.Offset+1])
+		}
+		return nil, &ParseError{Span: types.Span{Start: start, End: end}, Expected: quoteErr.Expected, Found: quoteErr.Found}
 	}
-	return f
+	return &types.StringNode{Span: token.Span, Value: value}, nil
 }
 
 func parseSingle(parsingContext *ParsingContext) (types.ASTNode, error) {
+	if err := parsingContext.enter(); err != nil {
+		return nil, err
+	}
+	defer parsingContext.leave()
 	switch parsingContext.currentToken().Type {
 	case types.LPAREN:
 		return parseFromLParen(parsingContext)
 	case types.ATOM:
-		return &types.SymbolNode{Name: parsingContext.consume().Value}, nil
+		token := parsingContext.consume()
+		return &types.SymbolNode{Span: token.Span, Name: token.Value}, nil
 	case types.NUMBER:
-		return &types.NumberNode{Value: parseFloat64(parsingContext.consume().Value)}, nil
+		return parseNumber(parsingContext.consume())
+	case types.STRING:
+		return parseString(parsingContext.consume())
+	case types.BOOLEAN:
+		token := parsingContext.consume()
+		return &types.BoolNode{Span: token.Span, Value: token.Value == "#t" || token.Value == "#true"}, nil
+	case types.QUOTE:
+		quote := parsingContext.consume()
+		datum, err := parseDatum(parsingContext)
+		if err != nil {
+			return nil, wrapParse("quoted datum", err)
+		}
+		return &types.QuoteNode{Span: quote.To(datum.SourceSpan()), Datum: datum}, nil
+	case types.QUASIQUOTE:
+		quasiquote := parsingContext.consume()
+		template, err := parseTemplate(parsingContext, 1)
+		if err != nil {
+			return nil, wrapParse("quasiquote template", err)
+		}
+		return &types.QuasiquoteNode{Span: quasiquote.To(template.SourceSpan()), Template: template}, nil
 	default:
-		return nil, fmt.Errorf("in parseSingle, unexpected main.TokenType %v", parsingContext.currentToken().Type.String())
+		return nil, expectedAt(parsingContext.currentToken(), "expression")
 	}
 }
 
 func parseFromLParen(parsingContext *ParsingContext) (types.ASTNode, error) {
-	token := parsingContext.consume()
-	util.Invariant(token.Type == types.LPAREN, "invalid atom, there should be lparentheses %v", parsingContext.tokens)
+	lparen := parsingContext.consume()
+	if lparen.Type != types.LPAREN {
+		return nil, expectedAt(lparen, "lparen")
+	}
 
-	token = parsingContext.consume()
+	token := parsingContext.consume()
 	switch token.Type {
-	case types.ATOM:
-		parsingContext.back()
-		parsingContext.back()
-		funcCallNode, err := parseFunctionCall(parsingContext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse function call: %w", err)
-		}
-		return funcCallNode, nil
-	case types.LET:
+	case types.LET, types.LETSTAR, types.LETREC:
 		parsingContext.back()
 		parsingContext.back()
 		letValueNode, err := parseLetValue(parsingContext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse let value: %w", err)
+			return nil, wrapParse("let value", err)
 		}
 		return letValueNode, nil
 	case types.LAMBDA:
@@ -110,173 +251,907 @@ func parseFromLParen(parsingContext *ParsingContext) (types.ASTNode, error) {
 		parsingContext.back()
 		lambdaNode, err := parseLambda(parsingContext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse lambda: %w", err)
+			return nil, wrapParse("lambda", err)
 		}
 		return lambdaNode, nil
-	case types.NUMBER:
-		return nil, fmt.Errorf("there should be function call but found number %v", token)
-	case types.LPAREN:
-		return nil, fmt.Errorf("there should be function call but found lparen")
+	case types.DEFINE:
+		parsingContext.back()
+		parsingContext.back()
+		defineNode, err := parseDefine(parsingContext)
+		if err != nil {
+			return nil, wrapParse("define", err)
+		}
+		return defineNode, nil
+	case types.SET:
+		parsingContext.back()
+		parsingContext.back()
+		setNode, err := parseSet(parsingContext)
+		if err != nil {
+			return nil, wrapParse("set!", err)
+		}
+		return setNode, nil
+	case types.BEGIN:
+		parsingContext.back()
+		parsingContext.back()
+		beginNode, err := parseBegin(parsingContext)
+		if err != nil {
+			return nil, wrapParse("begin", err)
+		}
+		return beginNode, nil
+	case types.IF:
+		parsingContext.back()
+		parsingContext.back()
+		ifNode, err := parseIf(parsingContext)
+		if err != nil {
+			return nil, wrapParse("if", err)
+		}
+		return ifNode, nil
+	case types.COND:
+		parsingContext.back()
+		parsingContext.back()
+		condNode, err := parseCond(parsingContext)
+		if err != nil {
+			return nil, wrapParse("cond", err)
+		}
+		return condNode, nil
+	case types.DEFMACRO:
+		parsingContext.back()
+		parsingContext.back()
+		defmacroNode, err := parseDefmacro(parsingContext)
+		if err != nil {
+			return nil, wrapParse("defmacro", err)
+		}
+		return defmacroNode, nil
+	case types.DEFINE_SYNTAX:
+		parsingContext.back()
+		parsingContext.back()
+		defineSyntaxNode, err := parseDefineSyntax(parsingContext)
+		if err != nil {
+			return nil, wrapParse("define-syntax", err)
+		}
+		return defineSyntaxNode, nil
+	case types.AND, types.OR:
+		parsingContext.back()
+		parsingContext.back()
+		logicalNode, err := parseLogical(parsingContext)
+		if err != nil {
+			return nil, wrapParse(token.Value, err)
+		}
+		return logicalNode, nil
 	case types.RPAREN:
-		return nil, fmt.Errorf("invalid rparen, we are not supporting empty list")
+		// () evaluates to the empty list, like '()
+		span := lparen.To(token.Span)
+		return &types.QuoteNode{Span: span, Datum: &types.ListNode{Span: span}}, nil
+	case types.EOF, types.IN:
+		return nil, expectedAt(token, "function call, let or lambda")
 	default:
-		return nil, fmt.Errorf("unexpected main.TokenType: %#v", token.Type.String())
+		parsingContext.back()
+		parsingContext.back()
+		if token.Type == types.ATOM && token.Value == "quote" {
+			quoteNode, err := parseQuote(parsingContext)
+			if err != nil {
+				return nil, wrapParse("quote", err)
+			}
+			return quoteNode, nil
+		}
+		if token.Type == types.ATOM && token.Value == "quasiquote" {
+			quasiquoteNode, err := parseQuasiquote(parsingContext)
+			if err != nil {
+				return nil, wrapParse("quasiquote", err)
+			}
+			return quasiquoteNode, nil
+		}
+		// any other expression in head position is the function being called,
+		// e.g. (f 1) or ((lambda (x) x) 5)
+		funcCallNode, err := parseFunctionCall(parsingContext)
+		if err != nil {
+			return nil, wrapParse("function call", err)
+		}
+		return funcCallNode, nil
 	}
 }
 
-func discardRParen(parsingContext *ParsingContext) error {
+func discardRParen(parsingContext *ParsingContext) (types.Token, error) {
 	token := parsingContext.consume()
 	if token.Type != types.RPAREN {
-		return fmt.Errorf("expected rparent but get:  %v", token)
+		return token, expectedAt(token, "rparen")
 	}
-	return nil
+	return token, nil
 }
 
 func parseFunctionCall(parsingContext *ParsingContext) (*types.CallNode, error) {
-	if err := discardLParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse function call: %w", err)
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("function call", err)
 	}
 
-	funcSymbolNode, err := parseSymbol(parsingContext)
+	functionNode, err := parseSingle(parsingContext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse function call node: %w", err)
+		return nil, wrapParse("function call node", err)
 	}
 
 	args := make([]types.ASTNode, 0)
 	for parsingContext.currentToken().Type != types.RPAREN {
 		argNode, err := parseSingle(parsingContext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse function call arg: %w", err)
+			return nil, wrapParse("function call arg", err)
 		}
 		args = append(args, argNode)
 	}
 
-	if err := discardRParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse function call, handling last rparen: %w", err)
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("function call, handling last rparen", err)
 	}
 
-	return &types.CallNode{Function: funcSymbolNode, Args: args}, nil
+	return &types.CallNode{Span: lparen.To(rparen.Span), Function: functionNode, Args: args}, nil
 }
 
-func discardLParen(parsingContext *ParsingContext) error {
+func discardLParen(parsingContext *ParsingContext) (types.Token, error) {
 	token := parsingContext.consume()
 	if token.Type != types.LPAREN {
-		return fmt.Errorf("expected lparen but get:  %v", token)
+		return token, expectedAt(token, "lparen")
 	}
-	return nil
+	return token, nil
 }
 
 func parseSymbol(parsingContext *ParsingContext) (*types.SymbolNode, error) {
 	token := parsingContext.consume()
 	if token.Type != types.ATOM {
-		return nil, fmt.Errorf("expected atom but get:  %v", token)
+		return nil, expectedAt(token, "atom")
 	}
-	return &types.SymbolNode{Name: token.Value}, nil
+	return &types.SymbolNode{Span: token.Span, Name: token.Value}, nil
 }
 
-// (let (x 10) in x)
+// (let ((x 10) (y 20)) in x), also let* and letrec. The single binding form
+// (let (x 10) in x) is still accepted.
 func parseLetValue(parsingContext *ParsingContext) (types.ASTNode, error) {
-	if err := discardLParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value: %w", err)
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("let value", err)
 	}
-	if err := discardLet(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value: %w", err)
+	kind, err := discardLet(parsingContext)
+	if err != nil {
+		return nil, wrapParse("let value", err)
 	}
-	if err := discardLParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value: %w", err)
+	if _, err := discardLParen(parsingContext); err != nil {
+		return nil, wrapParse("let value", err)
 	}
 
-	envVariableName, err := parseSymbol(parsingContext)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse let value, while parsing env variable name: %w", err)
+	bindings := make([]types.LetBinding, 0)
+	if parsingContext.currentToken().Type == types.ATOM {
+		binding, err := parseLetBindingBody(parsingContext)
+		if err != nil {
+			return nil, wrapParse("let value", err)
+		}
+		bindings = append(bindings, binding)
+	} else {
+		for parsingContext.currentToken().Type != types.RPAREN {
+			if _, err := discardLParen(parsingContext); err != nil {
+				return nil, wrapParse("let value, while parsing bindings", err)
+			}
+			binding, err := parseLetBindingBody(parsingContext)
+			if err != nil {
+				return nil, wrapParse("let value", err)
+			}
+			bindings = append(bindings, binding)
+		}
+		if _, err := discardRParen(parsingContext); err != nil {
+			return nil, wrapParse("let value", err)
+		}
 	}
 
-	envVariableValue, err := parseSingle(parsingContext)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse let value, while parsing env variable value: %w", err)
-	}
-	if err := discardRParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value: %w", err)
+	if kind != types.LETSTAR {
+		seen := make(map[string]bool)
+		for _, binding := range bindings {
+			if seen[binding.Name.Name] {
+				return nil, &ParseError{Span: binding.Name.Span, Expected: "distinct binding names", Found: fmt.Sprintf("duplicate %s", binding.Name.Name)}
+			}
+			seen[binding.Name.Name] = true
+		}
 	}
 
 	if err := discardIN(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value, try to discard 'in': %w", err)
+		return nil, wrapParse("let value, try to discard 'in'", err)
 	} // discardIN(parsingContext)
 
 	body, err := parseSingle(parsingContext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse let value, while parsing body: %w", err)
+		return nil, wrapParse("let value, while parsing body", err)
 	}
-	if err := discardRParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse let value, try consume last rparen: %w", err)
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("let value, try consume last rparen", err)
 	}
 
 	return &types.LetNode{
-		LetEnv: map[string]types.ASTNode{envVariableName.Name: envVariableValue},
-		Body:   body,
+		Span:     lparen.To(rparen.Span),
+		Kind:     kind,
+		Bindings: bindings,
+		Body:     body,
 	}, nil
 }
 
-func discardLet(parsingContext *ParsingContext) error {
-	token := parsingContext.consume()
-	if token.Type != types.LET {
-		return fmt.Errorf("expected let but get:  %v", token)
+// parseLetBindingBody parses `x 10)`, the rest of a binding after its lparen.
+func parseLetBindingBody(parsingContext *ParsingContext) (types.LetBinding, error) {
+	envVariableName, err := parseSymbol(parsingContext)
+	if err != nil {
+		return types.LetBinding{}, wrapParse("let binding, while parsing env variable name", err)
 	}
 
-	return nil
+	envVariableValue, err := parseSingle(parsingContext)
+	if err != nil {
+		return types.LetBinding{}, wrapParse("let binding, while parsing env variable value", err)
+	}
+	if _, err := discardRParen(parsingContext); err != nil {
+		return types.LetBinding{}, wrapParse("let binding", err)
+	}
+
+	return types.LetBinding{Name: envVariableName, Value: envVariableValue}, nil
+}
+
+func discardLet(parsingContext *ParsingContext) (types.TokenType, error) {
+	token := parsingContext.consume()
+	switch token.Type {
+	case types.LET, types.LETSTAR, types.LETREC:
+		return token.Type, nil
+	default:
+		return token.Type, expectedAt(token, "let, let* or letrec")
+	}
 }
 
 func discardIN(parsingContext *ParsingContext) error {
 	token := parsingContext.consume()
 	if token.Type != types.IN {
-		return fmt.Errorf("expected in but get:  %v", token)
+		return expectedAt(token, "in")
 	}
 
 	return nil
 }
 
 func parseLambda(parsingContext *ParsingContext) (types.ASTNode, error) {
-	if err := discardLParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse lambda: %w", err)
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("lambda", err)
 	}
 	if err := discardLAMBDA(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse lambda: %w", err)
-	}
-	if err := discardLParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse lambda: %w", err)
+		return nil, wrapParse("lambda", err)
 	}
-
-	args := make([]*types.SymbolNode, 0)
-	for parsingContext.currentToken().Type != types.RPAREN {
-		envVariableName, err := parseSymbol(parsingContext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse lambda, while parsing env variable name: %w", err)
-		}
-		args = append(args, envVariableName)
+	if _, err := discardLParen(parsingContext); err != nil {
+		return nil, wrapParse("lambda", err)
 	}
-	if err := discardRParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse lambda: %w", err)
+	args, err := parseLambdaArgs(parsingContext)
+	if err != nil {
+		return nil, wrapParse("lambda", err)
 	}
 
 	body, err := parseSingle(parsingContext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse lambda, while parsing body: %w", err)
+		return nil, wrapParse("lambda, while parsing body", err)
 	}
-	if err := discardRParen(parsingContext); err != nil {
-		return nil, fmt.Errorf("failed to parse lambda, try consume last rparen: %w", err)
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("lambda, try consume last rparen", err)
 	}
 
 	return &types.LambdaNode{
+		Span: lparen.To(rparen.Span),
 		Args: args,
 		Body: body,
 	}, nil
 }
 
+// parseLambdaArgs parses `x y)`, the argument names after their lparen.
+func parseLambdaArgs(parsingContext *ParsingContext) ([]*types.SymbolNode, error) {
+	args := make([]*types.SymbolNode, 0)
+	for parsingContext.currentToken().Type != types.RPAREN {
+		envVariableName, err := parseSymbol(parsingContext)
+		if err != nil {
+			return nil, wrapParse("lambda, while parsing env variable name", err)
+		}
+		args = append(args, envVariableName)
+	}
+	if _, err := discardRParen(parsingContext); err != nil {
+		return nil, wrapParse("lambda", err)
+	}
+	return args, nil
+}
+
 func discardLAMBDA(parsingContext *ParsingContext) error {
 	token := parsingContext.consume()
 	if token.Type != types.LAMBDA {
-		return fmt.Errorf("expected lambda but get:  %v", token)
+		return expectedAt(token, "lambda")
 	}
 
 	return nil
 }
+
+func discardToken(parsingContext *ParsingContext, tokenType types.TokenType, expected string) (types.Token, error) {
+	token := parsingContext.consume()
+	if token.Type != tokenType {
+		return token, expectedAt(token, expected)
+	}
+	return token, nil
+}
+
+// (if cond then else), else is optional
+func parseIf(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("if", err)
+	}
+	if _, err := discardToken(parsingContext, types.IF, "if"); err != nil {
+		return nil, wrapParse("if", err)
+	}
+
+	cond, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse("if, while parsing condition", err)
+	}
+	then, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse("if, while parsing then branch", err)
+	}
+	var elseNode types.ASTNode
+	if parsingContext.currentToken().Type != types.RPAREN {
+		elseNode, err = parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse("if, while parsing else branch", err)
+		}
+	}
+
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("if, try consume last rparen", err)
+	}
+
+	return &types.IfNode{Span: lparen.To(rparen.Span), Cond: cond, Then: then, Else: elseNode}, nil
+}
+
+// (cond (test body) ... (else body))
+func parseCond(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("cond", err)
+	}
+	if _, err := discardToken(parsingContext, types.COND, "cond"); err != nil {
+		return nil, wrapParse("cond", err)
+	}
+
+	clauses := make([]types.CondClause, 0)
+	var elseNode types.ASTNode
+	for parsingContext.currentToken().Type != types.RPAREN {
+		if _, err := discardLParen(parsingContext); err != nil {
+			return nil, wrapParse("cond clause", err)
+		}
+
+		if token := parsingContext.currentToken(); token.Type == types.ATOM && token.Value == "else" {
+			parsingContext.consume()
+			elseNode, err = parseSingle(parsingContext)
+			if err != nil {
+				return nil, wrapParse("cond else clause", err)
+			}
+			if _, err := discardRParen(parsingContext); err != nil {
+				return nil, wrapParse("cond else clause", err)
+			}
+			// else has to be the last clause
+			break
+		}
+
+		test, err := parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse("cond clause test", err)
+		}
+		body, err := parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse("cond clause body", err)
+		}
+		if _, err := discardRParen(parsingContext); err != nil {
+			return nil, wrapParse("cond clause", err)
+		}
+		clauses = append(clauses, types.CondClause{Test: test, Body: body})
+	}
+
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("cond, try consume last rparen", err)
+	}
+
+	return &types.CondNode{Span: lparen.To(rparen.Span), Clauses: clauses, Else: elseNode}, nil
+}
+
+// (and a b ...) or (or a b ...)
+func parseLogical(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("logical", err)
+	}
+	op := parsingContext.consume()
+	if op.Type != types.AND && op.Type != types.OR {
+		return nil, expectedAt(op, "and or or")
+	}
+
+	args := make([]types.ASTNode, 0)
+	for parsingContext.currentToken().Type != types.RPAREN {
+		arg, err := parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse(fmt.Sprintf("%s arg", op.Value), err)
+		}
+		args = append(args, arg)
+	}
+
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("%s, try consume last rparen", op.Value), err)
+	}
+
+	return &types.LogicalNode{Span: lparen.To(rparen.Span), Op: op.Type, Args: args}, nil
+}
+
+// (define name value) or (define (name args...) body)
+func parseDefine(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define", err)
+	}
+	if _, err := discardToken(parsingContext, types.DEFINE, "define"); err != nil {
+		return nil, wrapParse("define", err)
+	}
+
+	if parsingContext.currentToken().Type == types.LPAREN {
+		parsingContext.consume()
+		name, err := parseSymbol(parsingContext)
+		if err != nil {
+			return nil, wrapParse("define, while parsing function name", err)
+		}
+		args, err := parseLambdaArgs(parsingContext)
+		if err != nil {
+			return nil, wrapParse(fmt.Sprintf("define of %s", name.Name), err)
+		}
+		body, err := parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse(fmt.Sprintf("define of %s, while parsing body", name.Name), err)
+		}
+		rparen, err := discardRParen(parsingContext)
+		if err != nil {
+			return nil, wrapParse("define, try consume last rparen", err)
+		}
+
+		span := lparen.To(rparen.Span)
+		return &types.DefineNode{
+			Span:  span,
+			Name:  name,
+			Value: &types.LambdaNode{Span: span, Args: args, Body: body},
+		}, nil
+	}
+
+	name, err := parseSymbol(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define, while parsing name", err)
+	}
+	value, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("define of %s, while parsing value", name.Name), err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define, try consume last rparen", err)
+	}
+
+	return &types.DefineNode{Span: lparen.To(rparen.Span), Name: name, Value: value}, nil
+}
+
+// (set! name value)
+func parseSet(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("set!", err)
+	}
+	if _, err := discardToken(parsingContext, types.SET, "set!"); err != nil {
+		return nil, wrapParse("set!", err)
+	}
+
+	name, err := parseSymbol(parsingContext)
+	if err != nil {
+		return nil, wrapParse("set!, while parsing name", err)
+	}
+	value, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("set! of %s, while parsing value", name.Name), err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("set!, try consume last rparen", err)
+	}
+
+	return &types.SetNode{Span: lparen.To(rparen.Span), Name: name, Value: value}, nil
+}
+
+// (begin body...)
+func parseBegin(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("begin", err)
+	}
+	if _, err := discardToken(parsingContext, types.BEGIN, "begin"); err != nil {
+		return nil, wrapParse("begin", err)
+	}
+
+	body := make([]types.ASTNode, 0)
+	for parsingContext.currentToken().Type != types.RPAREN {
+		expr, err := parseSingle(parsingContext)
+		if err != nil {
+			return nil, wrapParse("begin body", err)
+		}
+		body = append(body, expr)
+	}
+
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("begin, try consume last rparen", err)
+	}
+
+	return &types.BeginNode{Span: lparen.To(rparen.Span), Body: body}, nil
+}
+
+// (defmacro name (params...) body)
+func parseDefmacro(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("defmacro", err)
+	}
+	if _, err := discardToken(parsingContext, types.DEFMACRO, "defmacro"); err != nil {
+		return nil, wrapParse("defmacro", err)
+	}
+
+	name, err := parseSymbol(parsingContext)
+	if err != nil {
+		return nil, wrapParse("defmacro, while parsing name", err)
+	}
+	if _, err := discardLParen(parsingContext); err != nil {
+		return nil, wrapParse(fmt.Sprintf("defmacro of %s", name.Name), err)
+	}
+	params, err := parseLambdaArgs(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("defmacro of %s", name.Name), err)
+	}
+	body, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("defmacro of %s, while parsing body", name.Name), err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("defmacro, try consume last rparen", err)
+	}
+
+	return &types.DefmacroNode{Span: lparen.To(rparen.Span), Name: name, Params: params, Body: body}, nil
+}
+
+// (define-syntax name (syntax-rules (literals...) (pattern template)...))
+func parseDefineSyntax(parsingContext *ParsingContext) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define-syntax", err)
+	}
+	if _, err := discardToken(parsingContext, types.DEFINE_SYNTAX, "define-syntax"); err != nil {
+		return nil, wrapParse("define-syntax", err)
+	}
+
+	name, err := parseSymbol(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define-syntax, while parsing name", err)
+	}
+	if _, err := discardLParen(parsingContext); err != nil {
+		return nil, wrapParse(fmt.Sprintf("define-syntax of %s", name.Name), err)
+	}
+	if token := parsingContext.consume(); token.Type != types.ATOM || token.Value != "syntax-rules" {
+		return nil, expectedAt(token, "syntax-rules")
+	}
+	if _, err := discardLParen(parsingContext); err != nil {
+		return nil, wrapParse(fmt.Sprintf("syntax-rules of %s, while parsing literals", name.Name), err)
+	}
+	literals, err := parseLambdaArgs(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("syntax-rules of %s, while parsing literals", name.Name), err)
+	}
+
+	rules := make([]types.SyntaxRule, 0)
+	for parsingContext.currentToken().Type != types.RPAREN {
+		rule, err := parseSyntaxRule(parsingContext)
+		if err != nil {
+			return nil, wrapParse(fmt.Sprintf("syntax-rules of %s", name.Name), err)
+		}
+		rules = append(rules, rule)
+	}
+	if _, err := discardRParen(parsingContext); err != nil {
+		return nil, wrapParse(fmt.Sprintf("syntax-rules of %s", name.Name), err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("define-syntax, try consume last rparen", err)
+	}
+
+	return &types.DefineSyntaxNode{Span: lparen.To(rparen.Span), Name: name, Literals: literals, Rules: rules}, nil
+}
+
+// parseSyntaxRule parses (pattern template). The pattern must be a list; its
+// head stands for the macro keyword and is not matched.
+func parseSyntaxRule(parsingContext *ParsingContext) (types.SyntaxRule, error) {
+	if _, err := discardLParen(parsingContext); err != nil {
+		return types.SyntaxRule{}, wrapParse("rule", err)
+	}
+	if token := parsingContext.currentToken(); token.Type != types.LPAREN {
+		return types.SyntaxRule{}, expectedAt(token, "pattern list like (_ args...)")
+	}
+	pattern, err := parseListDatum(parsingContext)
+	if err != nil {
+		return types.SyntaxRule{}, wrapParse("rule, while parsing pattern", err)
+	}
+	if len(pattern.Elements) == 0 {
+		return types.SyntaxRule{}, &ParseError{Span: pattern.Span, Expected: "pattern list like (_ args...)", Found: "()"}
+	}
+	template, err := parseDatum(parsingContext)
+	if err != nil {
+		return types.SyntaxRule{}, wrapParse("rule, while parsing template", err)
+	}
+	if _, err := discardRParen(parsingContext); err != nil {
+		return types.SyntaxRule{}, wrapParse("rule, expected one pattern and one template", err)
+	}
+	return types.SyntaxRule{Pattern: pattern, Template: template}, nil
+}
+
+// (quote datum)
+func parseQuote(parsingContext *ParsingContext) (*types.QuoteNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, err
+	}
+	parsingContext.consume() // quote
+	datum, err := parseDatum(parsingContext)
+	if err != nil {
+		return nil, wrapParse("quoted datum", err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("quote, quote takes exactly one datum", err)
+	}
+	return &types.QuoteNode{Span: lparen.To(rparen.Span), Datum: datum}, nil
+}
+
+// parseDatum parses quoted data rather than code: lists may be empty or
+// dotted, and keywords like let or if are plain symbols. A nested 'x is read
+// as the list (quote x), and likewise for `x, ,x and ,@x.
+func parseDatum(parsingContext *ParsingContext) (types.ASTNode, error) {
+	if err := parsingContext.enter(); err != nil {
+		return nil, err
+	}
+	defer parsingContext.leave()
+	token := parsingContext.currentToken()
+	switch token.Type {
+	case types.LPAREN:
+		return parseListDatum(parsingContext)
+	case types.QUOTE, types.QUASIQUOTE, types.UNQUOTE, types.UNQUOTE_SPLICING:
+		parsingContext.consume()
+		datum, err := parseDatum(parsingContext)
+		if err != nil {
+			return nil, wrapParse("quoted datum", err)
+		}
+		return shorthandList(token, datum), nil
+	case types.NUMBER:
+		return parseNumber(parsingContext.consume())
+	case types.STRING:
+		return parseString(parsingContext.consume())
+	case types.BOOLEAN:
+		parsingContext.consume()
+		return &types.BoolNode{Span: token.Span, Value: token.Value == "#t" || token.Value == "#true"}, nil
+	case types.RPAREN, types.EOF:
+		return nil, expectedAt(token, "datum")
+	default:
+		parsingContext.consume()
+		return &types.SymbolNode{Span: token.Span, Name: token.Value}, nil
+	}
+}
+
+// (datum ...) or (datum ... . datum)
+func parseListDatum(parsingContext *ParsingContext) (*types.ListNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]types.ASTNode, 0)
+	for {
+		token := parsingContext.currentToken()
+		switch {
+		case token.Type == types.RPAREN:
+			parsingContext.consume()
+			return &types.ListNode{Span: lparen.To(token.Span), Elements: elements}, nil
+		case token.Type == types.ATOM && token.Value == ".":
+			if len(elements) == 0 {
+				return nil, expectedAt(token, "datum before '.'")
+			}
+			parsingContext.consume()
+			tail, err := parseDatum(parsingContext)
+			if err != nil {
+				return nil, wrapParse("datum after '.'", err)
+			}
+			rparen, err := discardRParen(parsingContext)
+			if err != nil {
+				return nil, wrapParse("dotted list, expected one datum after '.'", err)
+			}
+			return &types.ListNode{Span: lparen.To(rparen.Span), Elements: elements, Tail: tail}, nil
+		case token.Type == types.EOF:
+			return nil, expectedAt(token, "rparen")
+		default:
+			element, err := parseDatum(parsingContext)
+			if err != nil {
+				return nil, wrapParse("list element", err)
+			}
+			elements = append(elements, element)
+		}
+	}
+}
+
+// shorthandNames are the forms that ', `, , and ,@ abbreviate.
+var shorthandNames = map[types.TokenType]string{
+	types.QUOTE:            "quote",
+	types.QUASIQUOTE:       "quasiquote",
+	types.UNQUOTE:          "unquote",
+	types.UNQUOTE_SPLICING: "unquote-splicing",
+}
+
+// shorthandList spells out a shorthand like 'x as the list (quote x).
+func shorthandList(shorthand types.Token, datum types.ASTNode) *types.ListNode {
+	name := &types.SymbolNode{Span: shorthand.Span, Name: shorthandNames[shorthand.Type]}
+	return &types.ListNode{Span: shorthand.To(datum.SourceSpan()), Elements: []types.ASTNode{name, datum}}
+}
+
+// (quasiquote template)
+func parseQuasiquote(parsingContext *ParsingContext) (*types.QuasiquoteNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, err
+	}
+	parsingContext.consume() // quasiquote
+	template, err := parseTemplate(parsingContext, 1)
+	if err != nil {
+		return nil, wrapParse("quasiquote template", err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse("quasiquote, quasiquote takes exactly one template", err)
+	}
+	return &types.QuasiquoteNode{Span: lparen.To(rparen.Span), Template: template}, nil
+}
+
+// parseTemplate parses a quasiquote template: a datum in which ,expr and
+// ,@expr (or (unquote expr) and (unquote-splicing expr)) hold code. depth
+// counts the quasiquotes around the template; only unquotes at depth 1 are
+// code, deeper ones stay data for the inner quasiquote.
+func parseTemplate(parsingContext *ParsingContext, depth int) (types.ASTNode, error) {
+	if err := parsingContext.enter(); err != nil {
+		return nil, err
+	}
+	defer parsingContext.leave()
+	token := parsingContext.currentToken()
+	switch token.Type {
+	case types.UNQUOTE, types.UNQUOTE_SPLICING:
+		parsingContext.consume()
+		return parseUnquote(parsingContext, token, token.Type == types.UNQUOTE_SPLICING, depth)
+	case types.QUASIQUOTE, types.QUOTE:
+		parsingContext.consume()
+		innerDepth := depth
+		if token.Type == types.QUASIQUOTE {
+			innerDepth++
+		}
+		inner, err := parseTemplate(parsingContext, innerDepth)
+		if err != nil {
+			return nil, wrapParse("quoted template", err)
+		}
+		return shorthandList(token, inner), nil
+	case types.LPAREN:
+		return parseListTemplate(parsingContext, depth)
+	default:
+		return parseDatum(parsingContext)
+	}
+}
+
+// parseUnquote parses what follows an unquote: code at depth 1, a template
+// otherwise.
+func parseUnquote(parsingContext *ParsingContext, unquote types.Token, splicing bool, depth int) (types.ASTNode, error) {
+	if depth > 1 {
+		inner, err := parseTemplate(parsingContext, depth-1)
+		if err != nil {
+			return nil, wrapParse("unquoted template", err)
+		}
+		name := &types.SymbolNode{Span: unquote.Span, Name: "unquote"}
+		if splicing {
+			name.Name = "unquote-splicing"
+		}
+		return &types.ListNode{Span: unquote.To(inner.SourceSpan()), Elements: []types.ASTNode{name, inner}}, nil
+	}
+	expr, err := parseSingle(parsingContext)
+	if err != nil {
+		return nil, wrapParse("unquoted expression", err)
+	}
+	return &types.UnquoteNode{Span: unquote.To(expr.SourceSpan()), Expr: expr, Splicing: splicing}, nil
+}
+
+// parseListTemplate is parseListDatum for templates. A list spelling out an
+// unquote or a quasiquote, like (unquote x), is read like its shorthand.
+func parseListTemplate(parsingContext *ParsingContext, depth int) (types.ASTNode, error) {
+	lparen, err := discardLParen(parsingContext)
+	if err != nil {
+		return nil, err
+	}
+	if head := parsingContext.currentToken(); head.Type == types.ATOM {
+		switch head.Value {
+		case "unquote", "unquote-splicing", "quasiquote":
+			parsingContext.consume()
+			return parseSpelledOutTemplate(parsingContext, lparen, head, depth)
+		}
+	}
+
+	elements := make([]types.ASTNode, 0)
+	for {
+		token := parsingContext.currentToken()
+		switch {
+		case token.Type == types.RPAREN:
+			parsingContext.consume()
+			return &types.ListNode{Span: lparen.To(token.Span), Elements: elements}, nil
+		case token.Type == types.ATOM && token.Value == ".":
+			if len(elements) == 0 {
+				return nil, expectedAt(token, "datum before '.'")
+			}
+			parsingContext.consume()
+			tail, err := parseTemplate(parsingContext, depth)
+			if err != nil {
+				return nil, wrapParse("template after '.'", err)
+			}
+			rparen, err := discardRParen(parsingContext)
+			if err != nil {
+				return nil, wrapParse("dotted list, expected one template after '.'", err)
+			}
+			return &types.ListNode{Span: lparen.To(rparen.Span), Elements: elements, Tail: tail}, nil
+		case token.Type == types.EOF:
+			return nil, expectedAt(token, "rparen")
+		default:
+			element, err := parseTemplate(parsingContext, depth)
+			if err != nil {
+				return nil, wrapParse("list element", err)
+			}
+			elements = append(elements, element)
+		}
+	}
+}
+
+// parseSpelledOutTemplate parses the rest of (unquote x), (unquote-splicing x)
+// or (quasiquote x) after head.
+func parseSpelledOutTemplate(parsingContext *ParsingContext, lparen types.Token, head types.Token, depth int) (types.ASTNode, error) {
+	var node types.ASTNode
+	var err error
+	if head.Value == "quasiquote" {
+		node, err = parseTemplate(parsingContext, depth+1)
+	} else {
+		node, err = parseUnquote(parsingContext, head, head.Value == "unquote-splicing", depth)
+	}
+	if err != nil {
+		return nil, wrapParse(head.Value, err)
+	}
+	rparen, err := discardRParen(parsingContext)
+	if err != nil {
+		return nil, wrapParse(fmt.Sprintf("%s, %s takes exactly one template", head.Value, head.Value), err)
+	}
+
+	span := lparen.To(rparen.Span)
+	if head.Value == "quasiquote" {
+		name := &types.SymbolNode{Span: head.Span, Name: head.Value}
+		return &types.ListNode{Span: span, Elements: []types.ASTNode{name, node}}, nil
+	}
+	switch v := node.(type) {
+	case *types.UnquoteNode:
+		v.Span = span
+	case *types.ListNode:
+		v.Span = span
+	}
+	return node, nil
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"simlang/lexer"
+)
+
+// FuzzParse checks that Parse and ParseProgram are total: whatever the
+// input, they return an AST or a *ParseError and never panic.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"(+ 1 2)",
+		"(+ 1",
+		")",
+		"(let (x 1) in",
+		"(let ((x 1) (y 2)) (+ x y))",
+		"(lambda (x) x)",
+		"(lambda x",
+		"(define (f n) (if (= n 0) 1 (* n (f (- n 1)))))",
+		"(cond ((< x 0) 'neg) (else 'pos))",
+		"(and #t #f) (or)",
+		`"unterminated`,
+		`"esc\u{1F600}\n"`,
+		"'(1 2 . 3)",
+		"`(a ,b ,@c)",
+		"(defmacro m (x) x)",
+		"(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))",
+		"1.5e3 0x_ff 1/0 1__0",
+		"; comment\n(+ 1 2) ; trailing",
+		"(é ü)",
+		strings.Repeat("(", 100_000),
+		strings.Repeat("'", 100_000) + "x",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		var parseErr *ParseError
+		tokens := lexer.Toknize(input)
+		if _, err := Parse(tokens); err != nil && !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) returned %T, want a *ParseError: %v", input, err, err)
+		}
+		if _, err := ParseProgram(tokens); err != nil && !errors.As(err, &parseErr) {
+			t.Errorf("ParseProgram(%q) returned %T, want a *ParseError: %v", input, err, err)
+		}
+	})
+}
+
+// TestNestingDepth checks that input nested deeper than MaxNestingDepth is
+// a *ParseError at the first unclosed opener, returned without a prefix for
+// every level, and that input just within the limit still parses.
+func TestNestingDepth(t *testing.T) {
+	tooDeep := MaxNestingDepth + 1
+	tests := []struct {
+		name       string
+		src        string
+		wantOffset int
+	}{
+		{name: "parens", src: strings.Repeat("(", 300_000), wantOffset: 0},
+		{name: "calls", src: strings.Repeat("(f ", tooDeep) + "1" + strings.Repeat(")", tooDeep), wantOffset: 0},
+		{name: "quotes", src: strings.Repeat("'", tooDeep) + "x", wantOffset: 0},
+		{name: "quoted lists", src: "'" + strings.Repeat("(", tooDeep), wantOffset: 0},
+		{name: "quasiquote", src: "`" + strings.Repeat("(a `(b ", tooDeep), wantOffset: 0},
+		{name: "after a closed form", src: "(f 1) (g " + strings.Repeat("(", tooDeep), wantOffset: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseProgram(lexer.Toknize(tt.src))
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got %T, want a bare *ParseError: %.200v", err, err)
+			}
+			if parseErr.Start.Offset != tt.wantOffset {
+				t.Errorf("error at offset %d, want %d: %v", parseErr.Start.Offset, tt.wantOffset, err)
+			}
+		})
+	}
+
+	depth := MaxNestingDepth - 1
+	src := strings.Repeat("(f ", depth) + "1" + strings.Repeat(")", depth)
+	if _, err := Parse(lexer.Toknize(src)); err != nil {
+		t.Errorf("%d nested calls failed to parse: %.200v", depth, err)
+	}
+}
@@ -0,0 +1,187 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Command is a command implemented in Go. It gets its arguments already
+// evaluated: int64 for integers, float64 for other numbers, string for
+// strings and bare words.
+type Command func(args []any) (any, error)
+
+// command is a command as the evaluator runs it. Unlike a Command, it gets the
+// evaluation running it, for the variables of the current proc and for
+// running scripts within the same limits.
+type command func(ev *evaluation, args []any) (any, error)
+
+// goCommand adapts cmd, which needs nothing from the evaluation.
+func goCommand(cmd Command) command {
+	return func(_ *evaluation, args []any) (any, error) {
+		return cmd(args)
+	}
+}
+
+func printCommand(args []any) (any, error) {
+	words := make([]string, len(args))
+	for i, arg := range args {
+		words[i] = ToString(arg)
+	}
+	fmt.Println(strings.Join(words, " "))
+	return nil, nil
+}
+
+// addCommand sums its arguments like the + of expr: as an integer unless one
+// of them is a double.
+func addCommand(args []any) (any, error) {
+	var sum any = int64(0)
+	for _, arg := range args {
+		var err error
+		if sum, err = binaryOp("+", sum, arg); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// setCommand reads a variable when given only its name and assigns it otherwise,
+// returning the value either way.
+func setCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("wrong # args: should be \"set varName ?newValue?\"")
+	}
+	name := fmt.Sprint(args[0])
+	if len(args) == 2 {
+		ev.frame[name] = args[1]
+		return args[1], nil
+	}
+	return ev.readVar(name)
+}
+
+// unsetCommand deletes every variable it is given.
+func unsetCommand(ev *evaluation, args []any) (any, error) {
+	for _, arg := range args {
+		name := fmt.Sprint(arg)
+		if _, ok := ev.frame[name]; !ok {
+			return nil, fmt.Errorf("can't unset %q: no such variable", name)
+		}
+		delete(ev.frame, name)
+	}
+	return nil, nil
+}
+
+// incrCommand adds an increment, 1 by default, to the integer in a variable
+// and returns the sum. Like in Tcl 8.5, an unset variable counts as 0.
+func incrCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, fmt.Errorf("wrong # args: should be \"incr varName ?increment?\"")
+	}
+	name := fmt.Sprint(args[0])
+	increment := int64(1)
+	if len(args) == 2 {
+		var err error
+		if increment, err = integerValue(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	var value int64
+	if current, ok := ev.frame[name]; ok {
+		var err error
+		if value, err = integerValue(current); err != nil {
+			return nil, err
+		}
+	}
+	sum, err := intOp("+", value, increment)
+	if err != nil {
+		return nil, err
+	}
+	ev.frame[name] = sum
+	return sum, nil
+}
+
+// appendCommand appends every value to the variable, which is created if
+// needed, and returns the result.
+func appendCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong # args: should be \"append varName ?value ...?\"")
+	}
+	name := fmt.Sprint(args[0])
+	var builder strings.Builder
+	if current, ok := ev.frame[name]; ok {
+		builder.WriteString(ToString(current))
+	}
+	for _, arg := range args[1:] {
+		builder.WriteString(ToString(arg))
+	}
+	ev.frame[name] = builder.String()
+	return builder.String(), nil
+}
+
+// readVar returns the value of the variable name in the current frame.
+func (ev *evaluation) readVar(name string) (any, error) {
+	value, ok := ev.frame[name]
+	if !ok {
+		return nil, fmt.Errorf("can't read %q: no such variable", name)
+	}
+	return value, nil
+}
+
+// integerValue reads value as a whole number. Strings are parsed, so the
+// result of append can be incremented.
+func integerValue(value any) (int64, error) {
+	num, _ := numberValue(value)
+	n, ok := num.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expected integer but got %q", ToString(value))
+	}
+	return n, nil
+}
+
+// numberValue reads value as a number, an int64 or a float64, parsing
+// strings that look like one.
+func numberValue(value any) (any, bool) {
+	switch v := value.(type) {
+	case int64, float64:
+		return v, true
+	case string:
+		num := wordValue(v)
+		_, isString := num.(string)
+		return num, !isString
+	default:
+		return nil, false
+	}
+}
+
+// ToString formats value as it reads when substituted into a word. The
+// empty result of commands like print is the empty string.
+func ToString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatDouble(v)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// formatDouble formats num like Tcl: in the shortest form that reads back as
+// the same number, always with a decimal point or an exponent so it still
+// reads as a double, and as Inf, -Inf or NaN when it is not finite.
+func formatDouble(num float64) string {
+	switch {
+	case math.IsInf(num, 1):
+		return "Inf"
+	case math.IsInf(num, -1):
+		return "-Inf"
+	case math.IsNaN(num):
+		return "NaN"
+	}
+	str := strconv.FormatFloat(num, 'g', -1, 64)
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return str
+}
@@ -0,0 +1,348 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/parser"
+	"simlang/tcllike/types"
+)
+
+// resultCode is a Tcl result code other than ok and error, which are a nil
+// and a non-nil error: a way for a command to end the script running it
+// early.
+type resultCode int
+
+const (
+	codeReturn resultCode = iota + 2
+	codeBreak
+	codeContinue
+)
+
+// unwind carries a result code out of the script that raised it, as an
+// error, up to the command that handles the code: a proc call for return,
+// a loop for break and continue.
+type unwind struct {
+	code  resultCode
+	value any
+}
+
+func (u *unwind) Error() string {
+	switch u.code {
+	case codeReturn:
+		return `invoked "return" outside of a proc`
+	case codeBreak:
+		return `invoked "break" outside of a loop`
+	case codeContinue:
+		return `invoked "continue" outside of a loop`
+	default:
+		return fmt.Sprintf("command returned bad code: %d", u.code)
+	}
+}
+
+// caught reports whether err is unwinding with code and returns the value
+// it carries.
+func caught(err error, code resultCode) (any, bool) {
+	var u *unwind
+	if errors.As(err, &u) && u.code == code {
+		return u.value, true
+	}
+	return nil, false
+}
+
+// misplaced turns a break or continue that left the script it could apply
+// to, like the body of a proc, into an error, so no loop further out
+// catches it.
+func misplaced(err error) error {
+	var u *unwind
+	if !errors.As(err, &u) || u.code == codeReturn {
+		return err
+	}
+	var evalErr *EvalError
+	if errors.As(err, &evalErr) {
+		return &EvalError{Span: evalErr.Span, Err: errors.New(u.Error())}
+	}
+	return errors.New(u.Error())
+}
+
+// topLevel ends a script run by Eval or Call: a return outside any proc
+// gives the result of the script, like in tclsh, and a break or continue
+// outside any loop is an error.
+func topLevel(result any, err error) (any, error) {
+	if value, ok := caught(err, codeReturn); ok {
+		return value, nil
+	}
+	if err != nil {
+		return nil, misplaced(err)
+	}
+	return result, nil
+}
+
+// argStart returns where argument i of the command called at site starts in
+// the source when it is a braced word, so errors in a script or expression
+// read from it point into the source. The positions in other arguments
+// count from their own start.
+func argStart(site *types.CallNode, args []any, i int) types.Pos {
+	if site != nil && len(site.Args) == len(args) {
+		if braced, ok := site.Args[i].(*types.BracedNode); ok && args[i] == braced.Value {
+			return braced.Start.Advance("{")
+		}
+	}
+	return types.Pos{Offset: 0, Line: 1, Column: 1}
+}
+
+// scriptArg parses argument i of the command called at site as a script.
+func scriptArg(site *types.CallNode, args []any, i int) (*types.LinesNode, error) {
+	ast, err := parser.Parse(lexer.TokenizeAt(ToString(args[i]), argStart(site, args, i)))
+	if err != nil {
+		return nil, err
+	}
+	return ast.Root, nil
+}
+
+// exprArg parses argument i of the command called at site as an expression.
+func exprArg(site *types.CallNode, args []any, i int) (types.ASTNode, error) {
+	return parser.ParseExpr(ToString(args[i]), argStart(site, args, i))
+}
+
+// truth reads value as a Tcl boolean: a number, true when not zero, or one
+// of true, false, yes, no, on and off in any case.
+func truth(value any) (bool, error) {
+	if num, ok := numberValue(value); ok {
+		return toFloat(num) != 0, nil
+	}
+	switch strings.ToLower(ToString(value)) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected boolean value but got %q", ToString(value))
+	}
+}
+
+// test evaluates the condition cond as a boolean.
+func (ev *evaluation) test(cond types.ASTNode) (bool, error) {
+	value, err := ev.evalValue(cond)
+	if err != nil {
+		return false, err
+	}
+	ok, err := truth(value)
+	if err != nil {
+		return false, errorAt(cond, err)
+	}
+	return ok, nil
+}
+
+// loopBody runs body once for a loop. done is true after a break, while a
+// continue only ends this run.
+func (ev *evaluation) loopBody(body *types.LinesNode) (done bool, err error) {
+	_, err = ev.evalLines(body)
+	if _, ok := caught(err, codeBreak); ok {
+		return true, nil
+	}
+	if _, ok := caught(err, codeContinue); ok {
+		return false, nil
+	}
+	return false, err
+}
+
+// ifCommand runs the body of the first condition that is true and returns
+// its result: if expr ?then? body ?elseif expr ?then? body ...? ?else body?.
+// Like in Tcl, the else keyword may be left out. Only the bodies and
+// conditions that are reached are parsed.
+func ifCommand(ev *evaluation, args []any) (any, error) {
+	site := ev.site
+	for i := 0; ; {
+		if i >= len(args) {
+			keyword := "if"
+			if i > 0 {
+				keyword = ToString(args[i-1])
+			}
+			return nil, fmt.Errorf("wrong # args: no expression after %q argument", keyword)
+		}
+		cond, err := exprArg(site, args, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse condition: %w", err)
+		}
+		i++
+		if i < len(args) && args[i] == "then" {
+			i++
+		}
+		if i >= len(args) {
+			return nil, fmt.Errorf("wrong # args: no script following %q argument", ToString(args[i-1]))
+		}
+		body := i
+		i++
+
+		ok, err := ev.test(cond)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return ev.runScriptArg(site, args, body)
+		}
+
+		if i >= len(args) {
+			return nil, nil
+		}
+		if args[i] == "elseif" {
+			i++
+			continue
+		}
+		if args[i] == "else" {
+			i++
+			if i >= len(args) {
+				return nil, fmt.Errorf("wrong # args: no script following \"else\" argument")
+			}
+		}
+		if i != len(args)-1 {
+			return nil, fmt.Errorf("wrong # args: extra words after \"else\" clause in \"if\" command")
+		}
+		return ev.runScriptArg(site, args, i)
+	}
+}
+
+// runScriptArg parses and runs argument i of the command called at site.
+func (ev *evaluation) runScriptArg(site *types.CallNode, args []any, i int) (any, error) {
+	script, err := scriptArg(site, args, i)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+	return ev.evalLines(script)
+}
+
+// whileCommand runs body as long as test is true: while test body.
+func whileCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("wrong # args: should be \"while test command\"")
+	}
+	test, err := exprArg(ev.site, args, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse condition: %w", err)
+	}
+	body, err := scriptArg(ev.site, args, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	for {
+		ok, err := ev.test(test)
+		if err != nil || !ok {
+			return nil, err
+		}
+		if done, err := ev.loopBody(body); done || err != nil {
+			return nil, err
+		}
+	}
+}
+
+// forCommand runs start, then body and next as long as test is true:
+// for start test next body.
+func forCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("wrong # args: should be \"for start test next command\"")
+	}
+	start, err := scriptArg(ev.site, args, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse start: %w", err)
+	}
+	test, err := exprArg(ev.site, args, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse condition: %w", err)
+	}
+	next, err := scriptArg(ev.site, args, 2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse next: %w", err)
+	}
+	body, err := scriptArg(ev.site, args, 3)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	if _, err := ev.evalLines(start); err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := ev.test(test)
+		if err != nil || !ok {
+			return nil, err
+		}
+		if done, err := ev.loopBody(body); done || err != nil {
+			return nil, err
+		}
+		if _, err := ev.evalLines(next); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// foreachCommand runs body for every element of a list, or every group of
+// elements when there are several variables, and can walk several lists at
+// once: foreach varList list ?varList list ...? body. Variables left over
+// by a list that runs out first are set to the empty string.
+func foreachCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, fmt.Errorf("wrong # args: should be \"foreach varList list ?varList list ...? command\"")
+	}
+	body, err := scriptArg(ev.site, args, len(args)-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body: %w", err)
+	}
+
+	type walk struct {
+		vars   []string
+		values []string
+	}
+	walks := make([]walk, 0, len(args)/2)
+	iterations := 0
+	for i := 0; i < len(args)-1; i += 2 {
+		vars, err := SplitList(ToString(args[i]))
+		if err != nil {
+			return nil, err
+		}
+		if len(vars) == 0 {
+			return nil, fmt.Errorf("foreach varlist is empty")
+		}
+		values, err := SplitList(ToString(args[i+1]))
+		if err != nil {
+			return nil, err
+		}
+		walks = append(walks, walk{vars: vars, values: values})
+		iterations = max(iterations, (len(values)+len(vars)-1)/len(vars))
+	}
+
+	for n := range iterations {
+		for _, w := range walks {
+			for j, name := range w.vars {
+				if k := n*len(w.vars) + j; k < len(w.values) {
+					ev.frame[name] = wordValue(w.values[k])
+				} else {
+					ev.frame[name] = ""
+				}
+			}
+		}
+		if done, err := ev.loopBody(body); done || err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// breakCommand ends the innermost loop.
+func breakCommand(_ *evaluation, args []any) (any, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("wrong # args: should be \"break\"")
+	}
+	return nil, &unwind{code: codeBreak}
+}
+
+// continueCommand ends the current run of the body of the innermost loop.
+func continueCommand(_ *evaluation, args []any) (any, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("wrong # args: should be \"continue\"")
+	}
+	return nil, &unwind{code: codeContinue}
+}
@@ -2,16 +2,133 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"simlang/tcllike/types"
+	"simlang/util"
 )
 
-func Eval(ast *types.AST) (any, error) {
+// EvalError reports a runtime failure together with the source span of the
+// command or value that caused it.
+type EvalError struct {
+	types.Span
+	Err error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Start, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt attaches the span of node to err, unless err already points at a
+// more specific location, like an error in the body of a proc.
+func errorAt(node types.Spanned, err error) error {
+	var spanned types.Spanned
+	if errors.As(err, &spanned) {
+		return err
+	}
+	return &EvalError{Span: node.SourceSpan(), Err: err}
+}
+
+// wrapEval adds that evaluating what failed to err, unless err already
+// points at where it happened. Without that, a failure deep in a recursion
+// would carry one prefix for every command above it.
+func wrapEval(what string, err error) error {
+	var spanned types.Spanned
+	if errors.As(err, &spanned) {
+		return err
+	}
+	return fmt.Errorf("failed to eval %s: %w", what, err)
+}
+
+// Repr formats a result for display in the REPL, quoting strings.
+func Repr(value any) string {
+	switch v := value.(type) {
+	case string:
+		return util.Quote(v)
+	case float64:
+		return formatDouble(v)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// Interp holds the commands and variables that outlive one evaluation, so a
+// variable set by one Eval is visible to the following ones. It is safe for
+// concurrent use; evaluations are serialized.
+type Interp struct {
+	mu       sync.Mutex
+	commands map[string]command
+	vars     map[string]any
+}
+
+// NewInterp returns an Interp with the builtin commands and no variables.
+func NewInterp() *Interp {
+	in := &Interp{commands: make(map[string]command), vars: make(map[string]any)}
+	in.commands["print"] = goCommand(printCommand)
+	in.commands["+"] = goCommand(addCommand)
+	in.commands["set"] = setCommand
+	in.commands["unset"] = unsetCommand
+	in.commands["incr"] = incrCommand
+	in.commands["append"] = appendCommand
+	in.commands["proc"] = procCommand
+	in.commands["return"] = returnCommand
+	in.commands["if"] = ifCommand
+	in.commands["while"] = whileCommand
+	in.commands["for"] = forCommand
+	in.commands["foreach"] = foreachCommand
+	in.commands["break"] = breakCommand
+	in.commands["continue"] = continueCommand
+	in.commands["expr"] = exprCommand
+	return in
+}
+
+// SetCommand makes cmd callable as name, replacing any command of that name.
+func (in *Interp) SetCommand(name string, cmd Command) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.commands[name] = goCommand(cmd)
+}
+
+// SetVar sets the global variable name to value.
+func (in *Interp) SetVar(name string, value any) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.vars[name] = value
+}
+
+// Var returns the value of the global variable name.
+func (in *Interp) Var(name string) (any, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	value, ok := in.vars[name]
+	return value, ok
+}
+
+func (in *Interp) Eval(ast *types.AST) (any, error) {
+	return in.EvalWithOptions(context.Background(), ast, Limits{})
+}
+
+// EvalWithOptions evaluates ast, stopping with a *LimitExceeded error when ctx
+// is done or the evaluation runs past limits.
+func (in *Interp) EvalWithOptions(ctx context.Context, ast *types.AST, limits Limits) (any, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
 	lines := ast.Root
 
 	var result any = nil
-	if lineResult, err := evalLines(lines); err != nil {
+	if lineResult, err := topLevel(newEvaluation(in, ctx, limits).evalLines(lines)); err != nil {
 		return nil, fmt.Errorf("failed to eval lines: %w", err)
 	} else {
 		result = lineResult
@@ -19,12 +136,42 @@ func Eval(ast *types.AST) (any, error) {
 	return result, nil
 }
 
-func evalLines(lines *types.LinesNode) (any, error) {
+// Call runs the command name with already evaluated args within limits.
+func (in *Interp) Call(ctx context.Context, name string, args []any, limits Limits) (any, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	ev := newEvaluation(in, ctx, limits)
+	if err := ev.step(); err != nil {
+		return nil, err
+	}
+	cmd, err := ev.command(name)
+	if err != nil {
+		return nil, err
+	}
+	if result, err := topLevel(cmd(ev, args)); err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	} else {
+		return result, nil
+	}
+}
+
+// Eval evaluates ast in a fresh Interp without any limits.
+func Eval(ast *types.AST) (any, error) {
+	return NewInterp().Eval(ast)
+}
+
+// EvalWithOptions evaluates ast in a fresh Interp within limits.
+func EvalWithOptions(ctx context.Context, ast *types.AST, limits Limits) (any, error) {
+	return NewInterp().EvalWithOptions(ctx, ast, limits)
+}
+
+func (ev *evaluation) evalLines(lines *types.LinesNode) (any, error) {
 	var lastValue any
 	lastValue = nil
 	for _, line := range lines.Lines {
-		if value, err := evalLine(line); err != nil {
-			return nil, fmt.Errorf("failed to eval line: %w", err)
+		if value, err := ev.evalLine(line); err != nil {
+			return nil, wrapEval("line", err)
 		} else {
 			lastValue = value
 		}
@@ -32,63 +179,136 @@ func evalLines(lines *types.LinesNode) (any, error) {
 	return lastValue, nil
 }
 
-func evalLine(line types.ASTNode) (any, error) {
-	switch v := line.(type) {
-	case *types.CallNode:
-		return evalCall(v)
-	case *types.NumberNode:
-		return v.Value, nil
-	default:
-		return nil, fmt.Errorf("not implemented yet for type %T", line)
+func (ev *evaluation) evalLine(line types.ASTNode) (any, error) {
+	// a word alone on its line is a command without arguments
+	if symbol, ok := line.(*types.SymbolNode); ok {
+		return ev.evalValue(&types.CallNode{Span: symbol.Span, FuncName: symbol.Name})
 	}
+	return ev.evalValue(line)
 }
 
-func evalCall(call *types.CallNode) (any, error) {
-	f := call.FuncName
-
-	switch f {
-	case "print":
-		return evalPrint(call.Args)
-	case "+":
-		return evalAdd(call.Args)
-	default:
-		return nil, fmt.Errorf("not implemented yet for function %s", f)
+// command looks up the command called name.
+func (ev *evaluation) command(name string) (command, error) {
+	if cmd, ok := ev.interp.commands[name]; ok {
+		return cmd, nil
 	}
+	names := make([]string, 0, len(ev.interp.commands))
+	for name := range ev.interp.commands {
+		names = append(names, name)
+	}
+	if suggestion, ok := util.Suggest(name, names); ok {
+		return nil, fmt.Errorf("invalid command name %q, did you mean %q?", name, suggestion)
+	}
+	return nil, fmt.Errorf("invalid command name %q", name)
 }
 
-func evalPrint(args []types.ASTNode) (any, error) {
-	values := make([]any, 0)
-	for _, arg := range args {
-		value, err := evalValue(arg)
+func (ev *evaluation) evalCall(call *types.CallNode) (any, error) {
+	if err := ev.enter(); err != nil {
+		return nil, errorAt(call, err)
+	}
+	defer ev.leave()
+
+	cmd, err := ev.command(call.FuncName)
+	if err != nil {
+		return nil, errorAt(call, err)
+	}
+	args := make([]any, 0, len(call.Args))
+	for _, arg := range call.Args {
+		if expand, ok := arg.(*types.ExpandNode); ok {
+			elements, err := ev.evalExpand(expand)
+			if err != nil {
+				return nil, wrapEval(call.FuncName, err)
+			}
+			args = append(args, elements...)
+			continue
+		}
+		value, err := ev.evalValue(arg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to eval print: %w", err)
+			return nil, wrapEval(call.FuncName, err)
 		}
-		values = append(values, value)
+		args = append(args, value)
 	}
 
-	fmt.Println(values...)
-	return nil, nil
+	ev.site = call
+	result, err := cmd(ev, args)
+	if err != nil {
+		return nil, errorAt(call, err)
+	}
+	if str, ok := result.(string); ok {
+		if err := ev.charge(int64(len(str))); err != nil {
+			return nil, errorAt(call, err)
+		}
+	}
+	return result, nil
 }
 
-func evalAdd(args []types.ASTNode) (any, error) {
-	var sum float64 = 0
-	for _, arg := range args {
-		value, err := evalValue(arg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to eval print: %w", err)
-		}
-		sum += value.(float64)
+// evalExpand evaluates the word of a {*} argument and returns the elements of
+// the list it holds, numbers read as numbers.
+func (ev *evaluation) evalExpand(expand *types.ExpandNode) ([]any, error) {
+	value, err := ev.evalValue(expand.Word)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := SplitList(ToString(value))
+	if err != nil {
+		return nil, errorAt(expand, err)
 	}
-	return sum, nil
+	values := make([]any, len(elements))
+	for i, element := range elements {
+		values[i] = wordValue(element)
+	}
+	return values, nil
 }
 
-func evalValue(arg types.ASTNode) (any, error) {
+func (ev *evaluation) evalValue(arg types.ASTNode) (any, error) {
+	if err := ev.step(); err != nil {
+		return nil, errorAt(arg, err)
+	}
 	switch v := arg.(type) {
 	case *types.CallNode:
-		return evalCall(v)
+		return ev.evalCall(v)
+	case *types.ScriptNode:
+		return ev.evalLines(v.Script)
+	case *types.BinaryNode:
+		return ev.evalBinary(v)
+	case *types.UnaryNode:
+		return ev.evalUnary(v)
+	case *types.TernaryNode:
+		return ev.evalTernary(v)
+	case *types.FuncNode:
+		return ev.evalFunc(v)
 	case *types.NumberNode:
+		if v.IsInt {
+			return v.Int, nil
+		}
+		return v.Value, nil
+	case *types.StringNode:
+		return v.Value, nil
+	case *types.BracedNode:
 		return v.Value, nil
+	case *types.SymbolNode:
+		// like in Tcl, a bare word is a string
+		return v.Name, nil
+	case *types.VarNode:
+		value, err := ev.readVar(v.Name)
+		if err != nil {
+			return nil, errorAt(v, err)
+		}
+		return value, nil
+	case *types.WordNode:
+		var builder strings.Builder
+		for _, part := range v.Parts {
+			value, err := ev.evalValue(part)
+			if err != nil {
+				return nil, err
+			}
+			builder.WriteString(ToString(value))
+		}
+		if err := ev.charge(int64(builder.Len())); err != nil {
+			return nil, errorAt(v, err)
+		}
+		return builder.String(), nil
 	default:
-		return nil, fmt.Errorf("not implemented yet for type %T", arg)
+		return nil, errorAt(arg, fmt.Errorf("not implemented yet for type %T", arg))
 	}
 }
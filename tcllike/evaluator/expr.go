@@ -0,0 +1,472 @@
+package evaluator
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"simlang/tcllike/parser"
+	"simlang/tcllike/types"
+)
+
+var (
+	errDivideByZero = errors.New("divide by zero")
+	// unlike Tcl, which switches to big integers, integers are 64 bits wide
+	errIntegerOverflow = errors.New("integer overflow")
+	errDomain          = errors.New("domain error: argument not in valid range")
+)
+
+// exprCommand evaluates its arguments, joined with spaces, as an expression.
+// A single braced argument is parsed where it stands, so errors point into
+// the source.
+func exprCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("wrong # args: should be \"expr arg ?arg ...?\"")
+	}
+	var expr types.ASTNode
+	var err error
+	if len(args) == 1 {
+		expr, err = exprArg(ev.site, args, 0)
+	} else {
+		words := make([]string, len(args))
+		for i, arg := range args {
+			words[i] = ToString(arg)
+		}
+		expr, err = parser.ParseExpr(strings.Join(words, " "), types.Pos{Offset: 0, Line: 1, Column: 1})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression: %w", err)
+	}
+	return ev.evalValue(expr)
+}
+
+// evalBinary applies a binary operator. Like in Tcl, && and || evaluate their
+// right operand only when the left one does not decide the result.
+func (ev *evaluation) evalBinary(node *types.BinaryNode) (any, error) {
+	if node.Op == "&&" || node.Op == "||" {
+		left, err := ev.test(node.Left)
+		if err != nil {
+			return nil, err
+		}
+		if left == (node.Op == "||") {
+			return boolValue(left), nil
+		}
+		right, err := ev.test(node.Right)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(right), nil
+	}
+
+	left, err := ev.evalValue(node.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := ev.evalValue(node.Right)
+	if err != nil {
+		return nil, err
+	}
+	result, err := binaryOp(node.Op, left, right)
+	if err != nil {
+		return nil, errorAt(node, err)
+	}
+	return result, nil
+}
+
+func (ev *evaluation) evalUnary(node *types.UnaryNode) (any, error) {
+	if node.Op == "!" {
+		ok, err := ev.test(node.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(!ok), nil
+	}
+
+	value, err := ev.evalValue(node.Operand)
+	if err != nil {
+		return nil, err
+	}
+	result, err := unaryOp(node.Op, value)
+	if err != nil {
+		return nil, errorAt(node, err)
+	}
+	return result, nil
+}
+
+// evalTernary evaluates only the branch of ?: its condition selects.
+func (ev *evaluation) evalTernary(node *types.TernaryNode) (any, error) {
+	ok, err := ev.test(node.Cond)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return ev.evalValue(node.Then)
+	}
+	return ev.evalValue(node.Else)
+}
+
+func (ev *evaluation) evalFunc(node *types.FuncNode) (any, error) {
+	fn, ok := mathFuncs[node.Name]
+	if !ok {
+		return nil, errorAt(node, fmt.Errorf("unknown math function %q", node.Name))
+	}
+	if len(node.Args) < fn.arity {
+		return nil, errorAt(node, fmt.Errorf("too few arguments for math function %q", node.Name))
+	}
+	if len(node.Args) > fn.arity {
+		return nil, errorAt(node, fmt.Errorf("too many arguments for math function %q", node.Name))
+	}
+
+	args := make([]any, len(node.Args))
+	for i, arg := range node.Args {
+		value, err := ev.evalValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		num, ok := numberValue(value)
+		if !ok {
+			return nil, errorAt(arg, fmt.Errorf("expected number but got %q", ToString(value)))
+		}
+		args[i] = num
+	}
+	result, err := fn.apply(args)
+	if err != nil {
+		return nil, errorAt(node, err)
+	}
+	return result, nil
+}
+
+// binaryOp applies a binary operator other than && and || to evaluated
+// operands. Arithmetic on two integers gives an integer, with / and %
+// rounding towards negative infinity like in Tcl, and on any double a
+// double.
+func binaryOp(op string, left, right any) (any, error) {
+	switch op {
+	case "eq":
+		return boolValue(ToString(left) == ToString(right)), nil
+	case "ne":
+		return boolValue(ToString(left) != ToString(right)), nil
+	case "in", "ni":
+		elements, err := SplitList(ToString(right))
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(slices.Contains(elements, ToString(left)) == (op == "in")), nil
+	case "==", "!=", "<", ">", "<=", ">=":
+		return compare(op, left, right), nil
+	}
+
+	a, err := operandValue(op, left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := operandValue(op, right)
+	if err != nil {
+		return nil, err
+	}
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	if xIsInt && yIsInt {
+		return intOp(op, x, y)
+	}
+	return floatOp(op, a, b)
+}
+
+// compare applies a comparison operator. Like in Tcl, two numbers, or strings
+// that read as numbers, compare as numbers, anything else as strings.
+func compare(op string, left, right any) any {
+	var order int
+	a, aIsNum := numberValue(left)
+	b, bIsNum := numberValue(right)
+	x, xIsInt := a.(int64)
+	y, yIsInt := b.(int64)
+	switch {
+	case xIsInt && yIsInt:
+		order = cmp.Compare(x, y)
+	case aIsNum && bIsNum:
+		order = cmp.Compare(toFloat(a), toFloat(b))
+	default:
+		order = strings.Compare(ToString(left), ToString(right))
+	}
+
+	switch op {
+	case "==":
+		return boolValue(order == 0)
+	case "!=":
+		return boolValue(order != 0)
+	case "<":
+		return boolValue(order < 0)
+	case ">":
+		return boolValue(order > 0)
+	case "<=":
+		return boolValue(order <= 0)
+	default:
+		return boolValue(order >= 0)
+	}
+}
+
+func unaryOp(op string, value any) (any, error) {
+	num, err := operandValue(op, value)
+	if err != nil {
+		return nil, err
+	}
+	if op == "~" {
+		n, err := intOperand(op, num)
+		if err != nil {
+			return nil, err
+		}
+		return ^n, nil
+	}
+	switch n := num.(type) {
+	case int64:
+		if op == "+" {
+			return n, nil
+		}
+		if n == math.MinInt64 {
+			return nil, errIntegerOverflow
+		}
+		return -n, nil
+	default:
+		if op == "+" {
+			return n, nil
+		}
+		return -n.(float64), nil
+	}
+}
+
+func intOp(op string, x, y int64) (any, error) {
+	switch op {
+	case "+":
+		sum := x + y
+		if (sum > x) != (y > 0) {
+			return nil, errIntegerOverflow
+		}
+		return sum, nil
+	case "-":
+		difference := x - y
+		if (difference < x) != (y > 0) {
+			return nil, errIntegerOverflow
+		}
+		return difference, nil
+	case "*":
+		product, ok := multiply(x, y)
+		if !ok {
+			return nil, errIntegerOverflow
+		}
+		return product, nil
+	case "/":
+		if y == 0 {
+			return nil, errDivideByZero
+		}
+		if x == math.MinInt64 && y == -1 {
+			return nil, errIntegerOverflow
+		}
+		quotient := x / y
+		if x%y != 0 && (x < 0) != (y < 0) {
+			quotient--
+		}
+		return quotient, nil
+	case "%":
+		if y == 0 {
+			return nil, errDivideByZero
+		}
+		// the remainder takes the sign of the divisor
+		remainder := x % y
+		if remainder != 0 && (remainder < 0) != (y < 0) {
+			remainder += y
+		}
+		return remainder, nil
+	case "**":
+		return power(x, y)
+	case "<<", ">>":
+		if y < 0 {
+			return nil, errors.New("negative shift argument")
+		}
+		if op == ">>" {
+			return x >> min(y, 63), nil
+		}
+		if x == 0 {
+			return x, nil
+		}
+		if y >= 64 || (x<<y)>>y != x {
+			return nil, errIntegerOverflow
+		}
+		return x << y, nil
+	case "&":
+		return x & y, nil
+	case "^":
+		return x ^ y, nil
+	case "|":
+		return x | y, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+}
+
+func floatOp(op string, a, b any) (any, error) {
+	var result float64
+	switch op {
+	case "+":
+		result = toFloat(a) + toFloat(b)
+	case "-":
+		result = toFloat(a) - toFloat(b)
+	case "*":
+		result = toFloat(a) * toFloat(b)
+	case "/":
+		result = toFloat(a) / toFloat(b)
+	case "**":
+		result = math.Pow(toFloat(a), toFloat(b))
+	default:
+		// the operators on integers only
+		if _, err := intOperand(op, a); err != nil {
+			return nil, err
+		}
+		_, err := intOperand(op, b)
+		return nil, err
+	}
+	return checkDomain(result)
+}
+
+// power raises x to the power y. Like in Tcl, a negative power of an integer
+// other than 1 and -1 is 0, and zero has none.
+func power(x, y int64) (any, error) {
+	if y < 0 {
+		switch x {
+		case 0:
+			return nil, errors.New("exponentiation of zero by negative power")
+		case 1:
+			return int64(1), nil
+		case -1:
+			if y%2 == 0 {
+				return int64(1), nil
+			}
+			return int64(-1), nil
+		default:
+			return int64(0), nil
+		}
+	}
+
+	result, base := int64(1), x
+	for ok := true; y > 0; y >>= 1 {
+		if y&1 == 1 {
+			if result, ok = multiply(result, base); !ok {
+				return nil, errIntegerOverflow
+			}
+		}
+		if y > 1 {
+			if base, ok = multiply(base, base); !ok {
+				return nil, errIntegerOverflow
+			}
+		}
+	}
+	return result, nil
+}
+
+// multiply returns x*y, or false if it overflows.
+func multiply(x, y int64) (int64, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+	product := x * y
+	if product/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+// operandValue reads value as a number for the operator op.
+func operandValue(op string, value any) (any, error) {
+	num, ok := numberValue(value)
+	if ok {
+		return num, nil
+	}
+	if str := ToString(value); str != "" {
+		return nil, fmt.Errorf("can't use non-numeric string %q as operand of %q", str, op)
+	}
+	return nil, fmt.Errorf("can't use empty string as operand of %q", op)
+}
+
+// intOperand checks that the number num can be an operand of op, which
+// takes only integers.
+func intOperand(op string, num any) (int64, error) {
+	if n, ok := num.(int64); ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("can't use floating-point value %q as operand of %q", ToString(num), op)
+}
+
+// checkDomain rejects the NaN a double operation gives for arguments it is
+// not defined for, like Tcl.
+func checkDomain(result float64) (any, error) {
+	if math.IsNaN(result) {
+		return nil, errDomain
+	}
+	return result, nil
+}
+
+// mathFunc is a function callable in expressions with arity arguments, all
+// numbers.
+type mathFunc struct {
+	arity int
+	apply func(args []any) (any, error)
+}
+
+var mathFuncs = map[string]mathFunc{
+	"abs": {1, func(args []any) (any, error) {
+		n, ok := args[0].(int64)
+		if !ok {
+			return math.Abs(toFloat(args[0])), nil
+		}
+		if n < 0 {
+			return unaryOp("-", n)
+		}
+		return n, nil
+	}},
+	"sqrt": {1, func(args []any) (any, error) {
+		return checkDomain(math.Sqrt(toFloat(args[0])))
+	}},
+	"pow": {2, func(args []any) (any, error) {
+		return checkDomain(math.Pow(toFloat(args[0]), toFloat(args[1])))
+	}},
+	"int": {1, func(args []any) (any, error) {
+		return toInteger(args[0], math.Trunc)
+	}},
+	"double": {1, func(args []any) (any, error) {
+		return toFloat(args[0]), nil
+	}},
+	"round": {1, func(args []any) (any, error) {
+		// math.Round rounds halves away from zero, like Tcl
+		return toInteger(args[0], math.Round)
+	}},
+}
+
+// toInteger converts the number num to an integer, with round for doubles.
+func toInteger(num any, round func(float64) float64) (any, error) {
+	if n, ok := num.(int64); ok {
+		return n, nil
+	}
+	f := round(toFloat(num))
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, errors.New("integer value too large to represent")
+	}
+	return int64(f), nil
+}
+
+// toFloat converts the number num to a double.
+func toFloat(num any) float64 {
+	if n, ok := num.(int64); ok {
+		return float64(n)
+	}
+	return num.(float64)
+}
+
+// boolValue is the result of a condition, 1 or 0.
+func boolValue(ok bool) any {
+	if ok {
+		return int64(1)
+	}
+	return int64(0)
+}
@@ -0,0 +1,115 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"simlang/tcllike/types"
+)
+
+// Limits bounds the resources of one evaluation. A zero field means no limit,
+// except for MaxDepth.
+type Limits struct {
+	// MaxSteps bounds the number of commands and values evaluated.
+	MaxSteps int64
+	// MaxDepth bounds how deeply commands nest, e.g. through [brackets] or
+	// procs calling procs. Zero means DefaultMaxDepth, as nesting without
+	// bound would overflow the Go stack, which crashes the program.
+	MaxDepth int
+	// MaxAllocBytes bounds an estimate of the memory allocated for command
+	// results.
+	MaxAllocBytes int64
+}
+
+// LimitExceeded is returned when an evaluation runs past one of its Limits,
+// or when its context is cancelled or times out. For the context case Limit
+// is "context" and Err is the context's error.
+type LimitExceeded struct {
+	Limit string // "steps", "depth", "alloc" or "context"
+	Max   int64
+	Err   error
+}
+
+func (e *LimitExceeded) Error() string {
+	switch e.Limit {
+	case "context":
+		return fmt.Sprintf("evaluation stopped: %s", e.Err)
+	case "alloc":
+		return fmt.Sprintf("evaluation exceeded the allocation limit of %d bytes", e.Max)
+	default:
+		return fmt.Sprintf("evaluation exceeded the %s limit of %d", e.Limit, e.Max)
+	}
+}
+
+func (e *LimitExceeded) Unwrap() error {
+	return e.Err
+}
+
+// DefaultMaxDepth is the depth evaluations are bounded to when the Limits
+// leave MaxDepth zero. A nested command takes a few KB of Go stack, so it
+// stays well below the 1GB Go allows by default.
+const DefaultMaxDepth = 100_000
+
+// contextCheckInterval is how many steps pass between checks of the context,
+// which are much more expensive than counting.
+const contextCheckInterval = 1024
+
+// evaluation is the state of one Eval: its context, limits and usage so far.
+type evaluation struct {
+	interp    *Interp
+	ctx       context.Context
+	limits    Limits
+	steps     int64
+	depth     int
+	allocated int64
+	// frame holds the variables of the running proc, or the global variables
+	// of interp outside any proc.
+	frame map[string]any
+	// site is the call of the command being run, for commands like proc that
+	// look at how their arguments were written. It is nil in Interp.Call.
+	site *types.CallNode
+}
+
+func newEvaluation(interp *Interp, ctx context.Context, limits Limits) *evaluation {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	return &evaluation{interp: interp, ctx: ctx, limits: limits, frame: interp.vars}
+}
+
+// step counts one evaluated command or value.
+func (ev *evaluation) step() error {
+	ev.steps++
+	if ev.limits.MaxSteps > 0 && ev.steps > ev.limits.MaxSteps {
+		return &LimitExceeded{Limit: "steps", Max: ev.limits.MaxSteps}
+	}
+	if ev.steps%contextCheckInterval == 0 {
+		if err := ev.ctx.Err(); err != nil {
+			return &LimitExceeded{Limit: "context", Err: err}
+		}
+	}
+	return nil
+}
+
+// enter starts a nested evaluation; every successful enter must be paired
+// with a leave.
+func (ev *evaluation) enter() error {
+	if ev.depth >= ev.limits.MaxDepth {
+		return &LimitExceeded{Limit: "depth", Max: int64(ev.limits.MaxDepth)}
+	}
+	ev.depth++
+	return nil
+}
+
+func (ev *evaluation) leave() {
+	ev.depth--
+}
+
+// charge records bytes allocated by the evaluation.
+func (ev *evaluation) charge(bytes int64) error {
+	ev.allocated += bytes
+	if ev.limits.MaxAllocBytes > 0 && ev.allocated > ev.limits.MaxAllocBytes {
+		return &LimitExceeded{Limit: "alloc", Max: ev.limits.MaxAllocBytes}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"simlang/tcllike/lexer"
+	"simlang/util"
+)
+
+// SplitList splits a Tcl list into its elements, separated by whitespace.
+// An element is a word in braces, taken literally, a word in double quotes,
+// or a bare word in which a backslash quotes the character after it.
+func SplitList(list string) ([]string, error) {
+	elements := make([]string, 0)
+	i := 0
+	for {
+		for i < len(list) && isListSpace(list[i]) {
+			i++
+		}
+		if i >= len(list) {
+			return elements, nil
+		}
+
+		switch list[i] {
+		case '{':
+			end, closed := lexer.ScanBraced(list, i)
+			if !closed {
+				return nil, fmt.Errorf("unmatched open brace in list")
+			}
+			elements = append(elements, list[i+1:end-1])
+			i = end
+		case '"':
+			end := scanQuoted(list, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched open quote in list")
+			}
+			element, err := util.Unquote(list[i:end])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read list element %s: %w", list[i:end], err)
+			}
+			elements = append(elements, element)
+			i = end
+		default:
+			var builder strings.Builder
+			for i < len(list) && !isListSpace(list[i]) {
+				if list[i] == '\\' && i+1 < len(list) {
+					i++
+				}
+				builder.WriteByte(list[i])
+				i++
+			}
+			elements = append(elements, builder.String())
+			continue
+		}
+
+		if i < len(list) && !isListSpace(list[i]) {
+			quoting := "braces"
+			if list[i-1] == '"' {
+				quoting = "quotes"
+			}
+			return nil, fmt.Errorf("list element in %s followed by %q instead of space", quoting, list[i:i+1])
+		}
+	}
+}
+
+// scanQuoted returns the index just past the quoted element starting at
+// list[start], or -1 if it is never closed.
+func scanQuoted(list string, start int) int {
+	for i := start + 1; i < len(list); i++ {
+		switch list[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func isListSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+// FormatList is the inverse of SplitList: it joins values into a list,
+// quoting every element that would not read back as itself.
+func FormatList(values []any) string {
+	elements := make([]string, len(values))
+	for i, value := range values {
+		elements[i] = formatListElement(ToString(value))
+	}
+	return strings.Join(elements, " ")
+}
+
+// listSpecials are the characters that make an element need quoting.
+const listSpecials = " \t\n\r{}\"\\[]$;"
+
+func formatListElement(element string) string {
+	if element == "" {
+		return "{}"
+	}
+	if !strings.ContainsAny(element, listSpecials) {
+		return element
+	}
+	if end, closed := lexer.ScanBraced("{"+element+"}", 0); closed && end == len(element)+2 {
+		return "{" + element + "}"
+	}
+	// unbalanced braces cannot be braced, so escape every special character
+	var builder strings.Builder
+	for i := 0; i < len(element); i++ {
+		if strings.IndexByte(listSpecials, element[i]) >= 0 {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(element[i])
+	}
+	return builder.String()
+}
+
+// wordValue reads a word taken from a list like the lexer reads a word in a
+// script: as an int64 or a float64 if it looks like a number, as a string
+// otherwise.
+func wordValue(word string) any {
+	if util.LooksLikeNumber(word) {
+		if literal, err := util.ParseNumber(word); err == nil {
+			if literal.IsExact() && literal.Exact.IsInt() && literal.Exact.Num().IsInt64() {
+				return literal.Exact.Num().Int64()
+			}
+			return literal.Float
+		}
+	}
+	return word
+}
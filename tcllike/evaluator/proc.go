@@ -0,0 +1,151 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"simlang/tcllike/types"
+)
+
+// returnCommand ends the running proc with the given value, or none.
+func returnCommand(_ *evaluation, args []any) (any, error) {
+	switch len(args) {
+	case 0:
+		return nil, &unwind{code: codeReturn}
+	case 1:
+		return nil, &unwind{code: codeReturn, value: args[0]}
+	default:
+		return nil, fmt.Errorf("wrong # args: should be \"return ?value?\"")
+	}
+}
+
+// param is a formal parameter of a proc.
+type param struct {
+	name         string
+	defaultValue any
+	hasDefault   bool
+}
+
+// proc is a command defined by a script.
+type proc struct {
+	name   string
+	params []param
+	// variadic is true when the last parameter is args, which collects the
+	// remaining arguments into a list.
+	variadic bool
+	body     *types.LinesNode
+}
+
+// procCommand defines a command: proc name params body. Every element of
+// params is a name or a list of a name and its default value. The body is
+// parsed once, here.
+func procCommand(ev *evaluation, args []any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("wrong # args: should be \"proc name args body\"")
+	}
+	p := &proc{name: ToString(args[0])}
+	if err := p.parseParams(ToString(args[1])); err != nil {
+		return nil, fmt.Errorf("failed to define %s: %w", p.name, err)
+	}
+
+	body, err := scriptArg(ev.site, args, 2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse body of %s: %w", p.name, err)
+	}
+	p.body = body
+
+	ev.interp.commands[p.name] = p.call
+	return nil, nil
+}
+
+func (p *proc) parseParams(list string) error {
+	elements, err := SplitList(list)
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		fields, err := SplitList(element)
+		if err != nil {
+			return err
+		}
+		switch len(fields) {
+		case 0:
+			return fmt.Errorf("argument with no name")
+		case 1:
+			p.params = append(p.params, param{name: fields[0]})
+		case 2:
+			p.params = append(p.params, param{name: fields[0], defaultValue: wordValue(fields[1]), hasDefault: true})
+		default:
+			return fmt.Errorf("too many fields in argument specifier %q", element)
+		}
+	}
+	p.variadic = len(p.params) > 0 && p.params[len(p.params)-1].name == "args"
+	return nil
+}
+
+// call runs the body of p in a new frame holding the arguments.
+func (p *proc) call(ev *evaluation, args []any) (any, error) {
+	params := p.params
+	if p.variadic {
+		params = params[:len(params)-1]
+	}
+	if len(args) > len(params) && !p.variadic {
+		return nil, p.wrongArgs()
+	}
+
+	frame := make(map[string]any, len(p.params))
+	for i, param := range params {
+		switch {
+		case i < len(args):
+			frame[param.name] = args[i]
+		case param.hasDefault:
+			frame[param.name] = param.defaultValue
+		default:
+			return nil, p.wrongArgs()
+		}
+	}
+	if p.variadic {
+		rest := FormatList(args[min(len(params), len(args)):])
+		if err := ev.charge(int64(len(rest))); err != nil {
+			return nil, err
+		}
+		frame["args"] = rest
+	}
+
+	caller := ev.frame
+	ev.frame = frame
+	defer func() { ev.frame = caller }()
+
+	result, err := ev.evalLines(p.body)
+	if value, ok := caught(err, codeReturn); ok {
+		return value, nil
+	}
+	if err != nil {
+		err = misplaced(err)
+		if errors.As(err, new(types.Spanned)) {
+			// it points into the body already, and recursive procs would
+			// otherwise repeat "failed to run" once per call
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to run %s: %w", p.name, err)
+	}
+	return result, nil
+}
+
+// wrongArgs reports a call with the wrong number of arguments, showing how
+// p is called like Tcl does: optional parameters in ?question marks?.
+func (p *proc) wrongArgs() error {
+	words := []string{p.name}
+	for i, param := range p.params {
+		switch {
+		case p.variadic && i == len(p.params)-1:
+			words = append(words, "?arg ...?")
+		case param.hasDefault:
+			words = append(words, "?"+param.name+"?")
+		default:
+			words = append(words, param.name)
+		}
+	}
+	return fmt.Errorf("wrong # args: should be %q", strings.Join(words, " "))
+}
@@ -0,0 +1,274 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"simlang/tcllike/evaluator"
+	"simlang/util"
+)
+
+var errorType = reflect.TypeFor[error]()
+
+func isFunc(value any) bool {
+	return value != nil && reflect.TypeOf(value).Kind() == reflect.Func
+}
+
+// toValue converts a Go value to an int64, float64 or string, the values
+// commands work with. Slices and arrays become lists.
+func toValue(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return reflectToValue(reflect.ValueOf(value))
+}
+
+func reflectToValue(v reflect.Value) (any, error) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			// too large for an integer, like a double it is only approximated
+			return float64(v.Uint()), nil
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]any, v.Len())
+		for i := range elements {
+			element, err := reflectToValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return evaluator.FormatList(elements), nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a tcllike value", v.Type())
+	}
+}
+
+// toNumber reads value as a number, an int64 or a float64, parsing strings
+// like Tcl does.
+func toNumber(value any) (any, bool) {
+	switch v := value.(type) {
+	case int64, float64:
+		return v, true
+	case string:
+		if !util.LooksLikeNumber(v) {
+			return nil, false
+		}
+		literal, err := util.ParseNumber(v)
+		if err != nil {
+			return nil, false
+		}
+		if literal.IsExact() && literal.Exact.IsInt() && literal.Exact.Num().IsInt64() {
+			return literal.Exact.Num().Int64(), true
+		}
+		return literal.Float, true
+	default:
+		return nil, false
+	}
+}
+
+// toFloat reads value as a float64, converting integers.
+func toFloat(value any) (float64, bool) {
+	num, ok := toNumber(value)
+	if i, isInt := num.(int64); isInt {
+		return float64(i), true
+	}
+	f, _ := num.(float64)
+	return f, ok
+}
+
+// fromValue converts value to the Go type t, or returns ok=false if value
+// does not fit t.
+func fromValue(value any, t reflect.Type) (converted reflect.Value, ok bool) {
+	converted = reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		if value != nil {
+			converted.Set(reflect.ValueOf(value))
+		}
+		return converted, true
+	case reflect.String:
+		converted.SetString(evaluator.ToString(value))
+		return converted, true
+	case reflect.Bool:
+		num, ok := toFloat(value)
+		converted.SetBool(num != 0)
+		return converted, ok
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, _ := toNumber(value)
+		i, ok := num.(int64)
+		if !ok || converted.OverflowInt(i) {
+			return converted, false
+		}
+		converted.SetInt(i)
+		return converted, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		num, _ := toNumber(value)
+		i, ok := num.(int64)
+		if !ok || i < 0 || converted.OverflowUint(uint64(i)) {
+			return converted, false
+		}
+		converted.SetUint(uint64(i))
+		return converted, true
+	case reflect.Float32, reflect.Float64:
+		num, ok := toFloat(value)
+		converted.SetFloat(num)
+		return converted, ok
+	case reflect.Slice:
+		if value == nil {
+			return converted, true
+		}
+		elements, err := evaluator.SplitList(evaluator.ToString(value))
+		if err != nil {
+			return converted, false
+		}
+		converted = reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			v, ok := fromValue(element, t.Elem())
+			if !ok {
+				return converted, false
+			}
+			converted.Index(i).Set(v)
+		}
+		return converted, true
+	default:
+		return converted, false
+	}
+}
+
+// convertible reports whether fromValue and reflectToValue handle t.
+func convertible(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return convertible(t.Elem())
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	default:
+		return false
+	}
+}
+
+// describeType names what a parameter of type t accepts, for error messages.
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a number, non-zero for true"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("an integer that fits in %s", t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Sprintf("a non-negative integer that fits in %s", t)
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return fmt.Sprintf("a list of %s", t.Elem())
+	default:
+		return "any value"
+	}
+}
+
+// newCommand wraps the Go function fn as the command name.
+func newCommand(name string, fn any) (evaluator.Command, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("%T is not a function", fn)
+	}
+	t := f.Type()
+	for i := range t.NumIn() {
+		paramType := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		if !convertible(paramType) {
+			return nil, fmt.Errorf("unsupported parameter type %s", paramType)
+		}
+	}
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType,
+		t.NumOut() >= 1 && t.Out(0) != errorType && !convertible(t.Out(0)):
+		return nil, fmt.Errorf("unsupported results %s, expected a value, an error or both", t)
+	}
+
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+	return func(args []any) (result any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result, err = nil, fmt.Errorf("%s panicked: %v", name, r)
+			}
+		}()
+		if len(args) < fixed || !t.IsVariadic() && len(args) > fixed {
+			return nil, fmt.Errorf("wrong # args: %s expects %s, got %d", name, describeArity(fixed, t.IsVariadic()), len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			paramType := t.In(min(i, t.NumIn()-1))
+			if t.IsVariadic() && i >= fixed {
+				paramType = paramType.Elem()
+			}
+			v, ok := fromValue(arg, paramType)
+			if !ok {
+				return nil, fmt.Errorf("%s expects %s as argument %d but got %s", name, describeType(paramType), i+1, evaluator.ToString(arg))
+			}
+			in[i] = v
+		}
+		return callResults(f.Call(in))
+	}, nil
+}
+
+func describeArity(fixed int, variadic bool) string {
+	arguments := "arguments"
+	if fixed == 1 {
+		arguments = "argument"
+	}
+	if variadic {
+		return fmt.Sprintf("at least %d %s", fixed, arguments)
+	}
+	return fmt.Sprintf("%d %s", fixed, arguments)
+}
+
+// callResults turns what a registered function returned into a value or an
+// error.
+func callResults(results []reflect.Value) (any, error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+	last := results[len(results)-1]
+	if last.Type() == errorType {
+		if !last.IsNil() {
+			return nil, last.Interface().(error)
+		}
+		results = results[:len(results)-1]
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return reflectToValue(results[0])
+}
@@ -0,0 +1,127 @@
+// Package interpreter embeds the Tcl-like language variant in Go programs. It
+// wraps the lexer, parser and evaluator behind one Interpreter that keeps its
+// variables between calls and can expose Go functions as commands.
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simlang/tcllike/evaluator"
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/parser"
+)
+
+// Interpreter evaluates Tcl-like scripts in an evaluator.Interp of its own. It
+// is safe for concurrent use; evaluations are serialized.
+type Interpreter struct {
+	interp  *evaluator.Interp
+	limits  evaluator.Limits
+	timeout time.Duration
+}
+
+// Option configures an Interpreter in New.
+type Option func(*Interpreter)
+
+// WithLimits bounds every EvalString and Call.
+func WithLimits(limits evaluator.Limits) Option {
+	return func(in *Interpreter) {
+		in.limits = limits
+	}
+}
+
+// WithTimeout stops every EvalString and Call that runs longer than timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(in *Interpreter) {
+		in.timeout = timeout
+	}
+}
+
+// New returns an Interpreter with the builtin commands.
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{interp: evaluator.NewInterp()}
+	for _, opt := range opts {
+		opt(in)
+	}
+	return in
+}
+
+// Define sets the variable name to value, converted as described for
+// RegisterFunc. A Go function becomes a command instead, so Define(name, f)
+// is RegisterFunc(name, f).
+func (in *Interpreter) Define(name string, value any) error {
+	if isFunc(value) {
+		return in.RegisterFunc(name, value)
+	}
+	converted, err := toValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to define %s: %w", name, err)
+	}
+	in.interp.SetVar(name, converted)
+	return nil
+}
+
+// RegisterFunc makes the Go function fn callable as the command name.
+//
+// Arguments are converted to the parameter types of fn: integers, and strings
+// that read as integers, to Go integer types (they must be in range),
+// numbers to Go float types, any value to string as it reads in a word (so
+// 2.0 stays "2.0"), numbers to bool (non-zero is true), lists to slices, and any value to an empty interface, which
+// receives int64, float64 or string. A variadic fn accepts any number of
+// trailing arguments.
+//
+// fn may return nothing, one value, an error, or a value and an error.
+// Integers and booleans are returned as int64, other numbers as float64,
+// strings as string and slices as lists; a non-nil error, or a panic, is
+// reported as the error of the command.
+func (in *Interpreter) RegisterFunc(name string, fn any) error {
+	cmd, err := newCommand(name, fn)
+	if err != nil {
+		return fmt.Errorf("failed to register %s: %w", name, err)
+	}
+	in.interp.SetCommand(name, cmd)
+	return nil
+}
+
+// EvalString evaluates the script src and returns the result of its last
+// command: an int64, a float64, a string, or nil if there is none.
+func (in *Interpreter) EvalString(src string) (any, error) {
+	ast, err := parser.Parse(lexer.Tokenize(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse: %w", err)
+	}
+
+	ctx, cancel := in.context()
+	defer cancel()
+	return in.interp.EvalWithOptions(ctx, ast, in.limits)
+}
+
+// Call runs the command fn, given by its name, with args converted as for
+// Define. The result is as for EvalString.
+func (in *Interpreter) Call(fn any, args ...any) (any, error) {
+	name, ok := fn.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot call %T, expected a command name", fn)
+	}
+
+	values := make([]any, len(args))
+	for i, arg := range args {
+		value, err := toValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to call %s: argument %d: %w", name, i+1, err)
+		}
+		values[i] = value
+	}
+
+	ctx, cancel := in.context()
+	defer cancel()
+	return in.interp.Call(ctx, name, values, in.limits)
+}
+
+func (in *Interpreter) context() (context.Context, context.CancelFunc) {
+	if in.timeout > 0 {
+		return context.WithTimeout(context.Background(), in.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
@@ -0,0 +1,153 @@
+package interpreter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errBoom = errors.New("boom")
+
+// newTestInterpreter returns an Interpreter with Go functions of the
+// parameter and result types RegisterFunc converts.
+func newTestInterpreter(t *testing.T) *Interpreter {
+	t.Helper()
+	in := New()
+	for name, fn := range map[string]any{
+		"describe": func(x any) string { return reflect.TypeOf(x).String() },
+		"is-nil":   func(x any) bool { return x == nil },
+		"add":      func(a, b int) int { return a + b },
+		"small":    func(n int8) int8 { return n },
+		"count":    func(n uint) uint { return n },
+		"half":     func(x float64) float64 { return x / 2 },
+		"truthy":   func(b bool) bool { return b },
+		"greet":    func(name string) string { return "hello " + name },
+		"sum": func(xs ...int64) int64 {
+			total := int64(0)
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"total":   func(xs []float64) float64 { return xs[0] + xs[1] },
+		"first":   func(xs []string) string { return xs[0] },
+		"words":   func(s string) []string { return strings.Split(s, ",") },
+		"nothing": func() {},
+		"parse-pos": func(n int) (int, error) {
+			if n < 0 {
+				return 0, errBoom
+			}
+			return n, nil
+		},
+		"explode": func() int { panic("kaboom") },
+	} {
+		if err := in.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("RegisterFunc(%q) failed: %v", name, err)
+		}
+	}
+	return in
+}
+
+// TestRegisterFunc checks how arguments and results of registered Go
+// functions are converted, and how their errors and panics are reported.
+func TestRegisterFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    any
+		wantErr string
+	}{
+		{name: "no result is nil for any", src: `is-nil [nothing]`, want: int64(1)},
+		{name: "a value is not nil for any", src: `is-nil 0`, want: int64(0)},
+		{name: "integer for any", src: `describe 42`, want: "int64"},
+		{name: "double for any", src: `describe 1.5`, want: "float64"},
+		{name: "word for any", src: `describe abc`, want: "string"},
+		{name: "ints", src: `add 2 3`, want: int64(5)},
+		{name: "string that reads as an int", src: `add "2" 0x3`, want: int64(5)},
+		{name: "double for an int", src: `add 2.5 1`, wantErr: "add expects an integer that fits in int as argument 1 but got 2.5"},
+		{name: "int out of range", src: `small 300`, wantErr: "fits in int8"},
+		{name: "negative uint", src: `count -1`, wantErr: "a non-negative integer"},
+		{name: "int to float", src: `half 1`, want: 0.5},
+		{name: "not a number", src: `half abc`, wantErr: "half expects a number as argument 1 but got abc"},
+		{name: "bool", src: `truthy 2`, want: int64(1)},
+		{name: "double to string in Tcl form", src: `greet [half 4]`, want: "hello 2.0"},
+		{name: "no result to string", src: `greet [nothing]`, want: "hello "},
+		{name: "variadic", src: `sum 1 2 3`, want: int64(6)},
+		{name: "variadic with none", src: `sum`, want: int64(0)},
+		{name: "list to slice", src: `total {1 2.5}`, want: 3.5},
+		{name: "double to slice in Tcl form", src: `first [half 4]`, want: "2.0"},
+		{name: "malformed list", src: `total "{1 2"`, wantErr: "total expects a list of float64"},
+		{name: "slice to list", src: `words "a,b c,d"`, want: "a {b c} d"},
+		{name: "value and nil error", src: `parse-pos 7`, want: int64(7)},
+		{name: "error", src: `parse-pos -7`, wantErr: "boom"},
+		{name: "panic", src: `explode`, wantErr: "explode panicked: kaboom"},
+		{name: "too few arguments", src: `add 1`, wantErr: "wrong # args: add expects 2 arguments, got 1"},
+		{name: "too many arguments", src: `nothing 1`, wantErr: "wrong # args: nothing expects 0 arguments, got 1"},
+	}
+	in := newTestInterpreter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := in.EvalString(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EvalString(%q) = %v, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalString(%q) failed: %v", tt.src, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EvalString(%q) = %#v, want %#v", tt.src, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := in.EvalString(`parse-pos -1`); !errors.Is(err, errBoom) {
+		t.Errorf("parse-pos -1 = %v, want errBoom in the chain", err)
+	}
+}
+
+// TestCall checks calling commands from Go, with Go arguments converted to
+// tcllike values.
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      any
+		args    []any
+		want    any
+		wantErr string
+	}{
+		{name: "builtin", fn: "+", args: []any{1, 2.5}, want: 3.5},
+		{name: "registered", fn: "add", args: []any{int8(2), uint16(3)}, want: int64(5)},
+		{name: "double to string", fn: "greet", args: []any{2.0}, want: "hello 2.0"},
+		{name: "bool argument", fn: "truthy", args: []any{true}, want: int64(1)},
+		{name: "slice argument", fn: "total", args: []any{[]float64{1, 2}}, want: 3.0},
+		{name: "nil argument", fn: "is-nil", args: []any{nil}, want: int64(1)},
+		{name: "no result", fn: "nothing", want: nil},
+		{name: "error", fn: "parse-pos", args: []any{-1}, wantErr: "boom"},
+		{name: "panic", fn: "explode", wantErr: "explode panicked: kaboom"},
+		{name: "unknown command", fn: "nope", wantErr: `invalid command name "nope"`},
+		{name: "not a name", fn: 42, wantErr: "cannot call int, expected a command name"},
+		{name: "unconvertible argument", fn: "describe", args: []any{map[string]int{}}, wantErr: "cannot convert map[string]int"},
+	}
+	in := newTestInterpreter(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := in.Call(tt.fn, tt.args...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Call(%v) = %v, %v, want an error containing %q", tt.fn, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call(%v) failed: %v", tt.fn, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Call(%v) = %#v, want %#v", tt.fn, got, tt.want)
+			}
+		})
+	}
+}
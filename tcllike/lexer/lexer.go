@@ -1,87 +1,298 @@
 // Package lexer implements lexical analysis for the Simlang programming language.
 package lexer
 
-import "simlang/tcllike/types"
+import (
+	"strings"
+	"unicode/utf8"
 
+	"simlang/tcllike/types"
+	"simlang/util"
+)
+
+// Tokenize splits input into tokens following the word rules of Tcl: a word
+// is a bare word, a "quoted" word or a {braced} word, and commands end at a
+// newline or a semicolon. Substitutions in words are left to the parser, so
+// bare and quoted words are kept as written, [brackets] included. Every token
+// records the span of source text it was read from.
 func Tokenize(input string) []types.Token {
+	return TokenizeAt(input, types.Pos{Offset: 0, Line: 1, Column: 1})
+}
+
+// TokenizeAt is Tokenize for input that starts at start in a larger source,
+// like the body of a proc, so spans point into that source.
+func TokenizeAt(input string, start types.Pos) []types.Token {
 	tokens := []types.Token{}
 	var current string
+	var currentStart types.Pos
+	pos := start
+
+	flush := func() {
+		if current != "" {
+			token := createToken(current)
+			token.Span = types.Span{Start: currentStart, End: pos}
+			tokens = append(tokens, token)
+			current = ""
+		}
+	}
+	push := func(tokenType types.TokenType, value string) {
+		flush()
+		tokens = append(tokens, types.Token{Type: tokenType, Value: value, Span: types.Span{Start: pos, End: pos.Advance(value)}})
+	}
+	// extend adds raw to the current word, starting one if needed
+	extend := func(raw string) {
+		if current == "" {
+			currentStart = pos
+		}
+		current += raw
+	}
 
-	for i := 0; i < len(input); i++ {
+	for i := 0; i < len(input); {
 		ch := input[i]
+		// every case consumes one byte unless it sets length
+		length := 1
 
 		switch ch {
 		case '(':
+			// a parenthesized expression is a word of its own, kept raw for the
+			// parser; elsewhere a paren is an ordinary character
 			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+				extend("(")
+				break
 			}
-			tokens = append(tokens, types.Token{Type: types.LParen, Value: "("})
-		case ')':
+			end, _ := ScanParen(input, i)
+			length = end - i
+			push(types.Expr, input[i:end])
+		case '\n', ';':
+			push(types.LineEnd, string(ch))
+		case '"':
+			// like Tcl, a quote only quotes a word it starts; the raw literal is
+			// kept and substituted by the parser
 			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+				extend(`"`)
+				break
 			}
-			tokens = append(tokens, types.Token{Type: types.RParen, Value: ")"})
-		case '[':
+			end, _ := ScanQuoted(input, i)
+			length = end - i
+			push(types.String, input[i:end])
+		case '{':
+			// like Tcl, a brace only quotes a word it starts, and {*} before a
+			// word expands it into several arguments
 			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+				extend("{")
+				break
 			}
-			tokens = append(tokens, types.Token{Type: types.LBracket, Value: "["})
-		case ']':
-			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+			if strings.HasPrefix(input[i:], "{*}") && i+3 < len(input) && !isWordEnd(input[i+3]) {
+				length = 3
+				push(types.Expand, "{*}")
+				break
 			}
-			tokens = append(tokens, types.Token{Type: types.RBracket, Value: "]"})
-		case '\n':
-			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+			end, _ := ScanBraced(input, i)
+			length = end - i
+			push(types.Braced, input[i:end])
+		case '[':
+			// a command substitution is part of the word around it
+			end, _ := ScanBracketed(input, i)
+			length = end - i
+			extend(input[i:end])
+		case '$':
+			// like Tcl, ${name} runs to the first close brace, so the name may
+			// hold blanks; an unclosed one is left for the parser to report
+			if closing := strings.IndexByte(input[i:], '}'); strings.HasPrefix(input[i:], "${") && closing >= 0 {
+				length = closing + 1
 			}
-			tokens = append(tokens, types.Token{Type: types.LineEnd, Value: "\n"})
-		case ' ', '\t':
-			if current != "" {
-				tokens = append(tokens, createToken(current))
-				current = ""
+			extend(input[i : i+length])
+		case '\\':
+			if strings.HasPrefix(input[i:], "\\\n") {
+				// a backslash-newline and the blanks after it separate words
+				flush()
+				length = scanContinuation(input, i) - i
+				break
+			}
+			// the escaped character never ends the word, the parser decodes it
+			_, size := utf8.DecodeRuneInString(input[i+1:])
+			length = 1 + size
+			extend(input[i : i+length])
+		case ' ', '\t', '\r':
+			flush()
+		case '#':
+			// like Tcl, # only starts a comment where a command could start
+			if current == "" && (len(tokens) == 0 || tokens[len(tokens)-1].Type == types.LineEnd) {
+				raw := input[i:scanLine(input, i)]
+				length = len(raw)
+				tokens = append(tokens, types.Token{Type: types.Comment, Value: raw, Span: types.Span{Start: pos, End: pos.Advance(raw)}})
+				break
 			}
+			extend("#")
 		default:
-			current += string(ch)
+			// a whole character, so the word keeps the bytes of the input
+			_, length = utf8.DecodeRuneInString(input[i:])
+			extend(input[i : i+length])
 		}
-	}
 
-	if current != "" {
-		tokens = append(tokens, createToken(current))
+		pos = pos.Advance(input[i : i+length])
+		i += length
 	}
+	flush()
 
 	return tokens
 }
 
-func createToken(value string) types.Token {
-	if isNumber(value) {
-		return types.Token{Type: types.Number, Value: value}
+// isWordEnd reports whether ch ends a bare word.
+func isWordEnd(ch byte) bool {
+	switch ch {
+	case ' ', '\t', '\r', '\n', ';':
+		return true
+	default:
+		return false
 	}
-	return types.Token{Type: types.Atom, Value: value}
 }
 
-func isNumber(s string) bool {
-	if len(s) == 0 {
-		return false
+// scanLine returns the index of the newline ending the line that contains
+// input[start], or len(input) on the last line.
+func scanLine(input string, start int) int {
+	if newline := strings.IndexByte(input[start:], '\n'); newline >= 0 {
+		return start + newline
+	}
+	return len(input)
+}
+
+// scanContinuation returns the index just past the backslash-newline at
+// input[start] and the spaces and tabs after it.
+func scanContinuation(input string, start int) int {
+	i := start + 2
+	for i < len(input) && (input[i] == ' ' || input[i] == '\t') {
+		i++
 	}
+	return i
+}
 
-	start := 0
-	if s[0] == '-' || s[0] == '+' {
-		if len(s) == 1 {
-			return false
+// ScanQuoted returns the index just past the quote closing the quoted word
+// starting at input[start]. Quotes escaped with a backslash or inside a
+// command substitution do not close it. If the word is never closed, it
+// returns len(input) and closed is false.
+func ScanQuoted(input string, start int) (end int, closed bool) {
+	end, closed, _ = scanQuoted(input, start)
+	return end, closed
+}
+
+// scanQuoted is ScanQuoted that also returns how deeply the command
+// substitutions in the word nest.
+func scanQuoted(input string, start int) (end int, closed bool, depth int) {
+	for i := start + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '[':
+			var inner int
+			i, _, inner = ScanBracketedDepth(input, i)
+			depth = max(depth, inner)
+			i--
+		case '"':
+			return i + 1, true, depth
+		}
+	}
+	return len(input), false, depth
+}
+
+// ScanBraced returns the index just past the brace matching the one at
+// input[start], skipping nested braces and braces escaped with a backslash.
+// If the brace is never closed, it returns len(input) and closed is false.
+func ScanBraced(input string, start int) (end int, closed bool) {
+	depth := 0
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return len(input), false
+}
+
+// ScanBracketed returns the index just past the bracket closing the command
+// substitution starting at input[start]. Brackets in nested substitutions,
+// quoted and braced words or escaped with a backslash do not close it. If
+// the substitution is never closed, it returns len(input) and closed is
+// false.
+func ScanBracketed(input string, start int) (end int, closed bool) {
+	end, closed, _ = ScanBracketedDepth(input, start)
+	return end, closed
+}
+
+// ScanBracketedDepth is ScanBracketed that also returns how deeply command
+// substitutions nest in the one starting at input[start], itself included,
+// so that a parser can refuse deep nesting before lexing any of it.
+func ScanBracketedDepth(input string, start int) (end int, closed bool, depth int) {
+	open := 0
+	wordStart := true
+	for i := start; i < len(input); i++ {
+		ch := input[i]
+		switch {
+		case ch == '\\':
+			i++
+		case ch == '[':
+			open++
+			depth = max(depth, open)
+		case ch == ']':
+			open--
+			if open == 0 {
+				return i + 1, true, depth
+			}
+		case ch == '"' && wordStart:
+			var inner int
+			i, _, inner = scanQuoted(input, i)
+			depth = max(depth, open+inner)
+			i--
+		case ch == '{' && wordStart:
+			i, _ = ScanBraced(input, i)
+			i--
 		}
-		start = 1
+		wordStart = ch == '[' || isWordEnd(ch)
 	}
+	return len(input), false, depth
+}
 
-	for i := start; i < len(s); i++ {
-		if s[i] < '0' || s[i] > '9' {
-			return false
+// ScanParen returns the index just past the paren closing the expression
+// starting at input[start]. Parens in quoted and braced words, in command
+// substitutions or escaped with a backslash do not close it. If the
+// expression is never closed, it returns len(input) and closed is false.
+func ScanParen(input string, start int) (end int, closed bool) {
+	depth := 0
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		case '[':
+			i, _ = ScanBracketed(input, i)
+			i--
+		case '"':
+			i, _ = ScanQuoted(input, i)
+			i--
+		case '{':
+			i, _ = ScanBraced(input, i)
+			i--
 		}
 	}
-	return true
+	return len(input), false
+}
+
+func createToken(value string) types.Token {
+	// a word with substitutions is a word even when it starts like a number
+	if util.LooksLikeNumber(value) && !strings.ContainsAny(value, `$[\`) {
+		return types.Token{Type: types.Number, Value: value}
+	}
+	return types.Token{Type: types.Atom, Value: value}
 }
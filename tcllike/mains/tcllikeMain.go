@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"simlang/tcllike/evaluator"
 	"simlang/tcllike/lexer"
@@ -15,13 +16,14 @@ import (
 
 func main() {
 	mode := flag.String("mode", "terminal", "Interface mode (terminal/web)")
+	timeout := flag.Duration("timeout", ui.DefaultEvalTimeout, "Time limit for each evaluation in web mode")
 	flag.Parse()
 
 	switch *mode {
 	case "terminal":
 		runTerminalUI()
 	case "web":
-		runWebUI()
+		runWebUI(*timeout)
 	default:
 		fmt.Println("Invalid mode. Use 'terminal' or 'web'")
 		os.Exit(1)
@@ -31,6 +33,7 @@ func main() {
 func runTerminalUI() {
 	ui.PrintWelcome()
 
+	interp := evaluator.NewInterp()
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		ui.PrintPrompt()
@@ -53,25 +56,27 @@ func runTerminalUI() {
 		// Parser 과정 출력
 		ast, err := parser.Parse(tokens)
 		if err != nil {
-			ui.PrintError(err.Error())
+			ui.PrintError(input, err)
 			continue
 		}
 		fmt.Println("AST:")
 		fmt.Println(ast.String())
 
 		// Eval 과정
-		result, err := evaluator.Eval(ast)
+		result, err := interp.Eval(ast)
 		if err != nil {
-			ui.PrintError(err.Error())
+			ui.PrintError(input, err)
 			continue
 		}
 
-		ui.PrintResult(fmt.Sprintf("%v", result))
+		ui.PrintResult(evaluator.Repr(result))
 	}
 }
 
-func runWebUI() {
+func runWebUI(timeout time.Duration) {
 	fmt.Println("Starting Tcl-like web server on http://localhost:8080")
-	http.Handle("/", ui.NewWebUI())
+	webUI := ui.NewWebUI()
+	webUI.Timeout = timeout
+	http.Handle("/", webUI)
 	http.ListenAndServe(":8080", nil)
 }
@@ -0,0 +1,90 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+
+	"simlang/tcllike/evaluator"
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/parser"
+)
+
+// TestWordRules checks the word rules of Tcl, as the lexer and the parser
+// implement them, with a words command that joins the words it gets with |.
+func TestWordRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "bare words", src: `words a b  c`, want: "a|b|c"},
+		{name: "braces group", src: `words {a b} c`, want: "a b|c"},
+		{name: "braces nest", src: `words {a {b c} d}`, want: "a {b c} d"},
+		{name: "braces do not substitute", src: `set x 1; words {$x [set x] \n}`, want: `$x [set x] \n`},
+		{name: "escaped brace in braces", src: `words {a\}b}`, want: `a\}b`},
+		{name: "quotes group and substitute", src: `set x 1; words "a $x [set x]"`, want: "a 1 1"},
+		{name: "quoted escapes", src: `words "a\tb\nc"`, want: "a\tb\nc"},
+		{name: "brackets in quotes", src: `words "[words a b]"`, want: "a|b"},
+		{name: "brace inside a word", src: `words a{b}c`, want: "a{b}c"},
+		{name: "quote inside a word", src: `words a"b"c`, want: `a"b"c`},
+		{name: "escaped blank", src: `words a\ b`, want: "a b"},
+		{name: "escaped specials", src: `words \{ \} \$x \[ \;`, want: "{|}|$x|[|;"},
+		{name: "numeric escapes", src: `words \x41\101é\u{1F600}`, want: "AAé😀"},
+		{name: "command substitution", src: `words [words a] b`, want: "a|b"},
+		{name: "substitution in a bare word", src: `set x 1; words a${x}b$x`, want: "a1b1"},
+		{name: "braced variable name with blanks", src: `set {a b} 3; words ${a b}`, want: "3"},
+		{name: "semicolon separates commands", src: `words a;words b`, want: "b"},
+		{name: "newline separates commands", src: "words a\nwords b", want: "b"},
+		{name: "line continuation", src: "words a \\\n    b", want: "a|b"},
+		{name: "argument expansion", src: `words {*}{a {b c}} d`, want: "a|b c|d"},
+		{name: "expansion of nothing", src: `words {*}{} d`, want: "d"},
+		{name: "lone {*} is a braced word", src: `words {*}`, want: "*"},
+		{name: "comment at command start", src: "# words a\nwords b", want: "b"},
+		{name: "hash inside a command", src: `words a #b`, want: "a|#b"},
+		{name: "non-ASCII word", src: `words é`, want: "é"},
+		{name: "non-ASCII variable", src: `set é 1; words $é`, want: "1"},
+		{name: "escaped non-ASCII character", src: `words a\é`, want: "aé"},
+		{name: "non-ASCII in braces and quotes", src: `set x ü; words {é ü} "é $x"`, want: "é ü|é ü"},
+		{name: "unclosed brace", src: `words {a`, wantErr: "} closing {"},
+		{name: "unclosed quote", src: `words "a`, wantErr: "closing double quote"},
+		{name: "unclosed bracket", src: `words [a`, wantErr: "] closing ["},
+		{name: "unclosed braced variable", src: `words ${a`, wantErr: "} closing ${"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%q: got %q, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q failed: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("%q = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+// eval runs src in a new interpreter with the words command and returns
+// the string form of its result.
+func eval(src string) (string, error) {
+	ast, err := parser.Parse(lexer.Tokenize(src))
+	if err != nil {
+		return "", err
+	}
+	interp := evaluator.NewInterp()
+	interp.SetCommand("words", func(args []any) (any, error) {
+		words := make([]string, len(args))
+		for i, arg := range args {
+			words[i] = evaluator.ToString(arg)
+		}
+		return strings.Join(words, "|"), nil
+	})
+	result, err := interp.Eval(ast)
+	return evaluator.ToString(result), err
+}
@@ -0,0 +1,85 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestControlFlow checks if, the loops, break and continue against Tcl.
+func TestControlFlow(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "if true", src: `if {1} {words yes}`, want: "yes"},
+		{name: "if false gives nothing", src: `if {0} {words yes}`, want: ""},
+		{name: "else", src: `if {0} {words a} else {words b}`, want: "b"},
+		{name: "elseif", src: `if {0} {words a} elseif {1} {words b} else {words c}`, want: "b"},
+		{name: "first true branch wins", src: `if {1} {words a} elseif {1} {words b}`, want: "a"},
+		{name: "then keywords", src: `if 0 then {words a} elseif 0 then {words b} else {words c}`, want: "c"},
+		{name: "else keyword left out", src: `if {0} {words a} {words b}`, want: "b"},
+		{name: "string booleans", src: `set v yes; if {$v} {words a} else {words b}`, want: "a"},
+		{name: "non-boolean condition", src: `set v abc; if {$v} {words a}`, wantErr: `expected boolean value but got "abc"`},
+		{name: "no script after condition", src: `if {1}`, wantErr: `no script following "1" argument`},
+		{name: "no expression after elseif", src: `if {0} {words a} elseif`, wantErr: `no expression after "elseif" argument`},
+		{name: "extra words after else", src: `if {0} {words a} else {words b} c`, wantErr: `extra words after "else" clause`},
+		{name: "while", src: `set i 0; set s ""; while {$i < 3} {append s $i; incr i}; set s`, want: "012"},
+		{name: "while gives nothing", src: `set i 0; while {$i < 3} {incr i}`, want: ""},
+		{name: "while break", src: `set i 0; while {1} {if {$i == 3} break; incr i}; set i`, want: "3"},
+		{
+			name: "while continue",
+			src:  `set i 0; set s ""; while {$i < 5} {incr i; if {$i % 2} continue; append s $i}; set s`,
+			want: "24",
+		},
+		{name: "for", src: `set s ""; for {set i 0} {$i < 4} {incr i} {append s $i}; set s`, want: "0123"},
+		{name: "for break skips next", src: `for {set i 0} {$i < 10} {incr i} {if {$i == 2} break}; set i`, want: "2"},
+		{
+			name: "for continue runs next",
+			src:  `set s ""; for {set i 0} {$i < 5} {incr i} {if {$i == 2} continue; append s $i}; set s`,
+			want: "0134",
+		},
+		{name: "break ends only the inner loop", src: `set s ""; foreach a {1 2} {foreach b {x y} {append s $a$b; break}}; set s`, want: "1x2x"},
+		{name: "foreach", src: `set s ""; foreach x {a b c} {append s $x}; set s`, want: "abc"},
+		{name: "foreach over a group of variables", src: `set s ""; foreach {a b} {1 2 3 4} {append s $a$b,}; set s`, want: "12,34,"},
+		{name: "foreach pads the last group", src: `set s ""; foreach {a b} {1 2 3} {append s $a$b,}; set s`, want: "12,3,"},
+		{name: "foreach pads a shorter list", src: `set s ""; foreach a {1 2 3} b {x y} {append s $a$b,}; set s`, want: "1x,2y,3,"},
+		{name: "foreach over an empty list", src: `set s none; foreach x {} {set s some}; set s`, want: "none"},
+		{name: "foreach continue", src: `set s ""; foreach x {1 2 3} {if {$x == 2} continue; append s $x}; set s`, want: "13"},
+		{name: "foreach with an empty variable list", src: `foreach {} {1 2} {}`, wantErr: "foreach varlist is empty"},
+		{name: "break outside of a loop", src: `break`, wantErr: `invoked "break" outside of a loop`},
+		{name: "continue outside of a loop", src: `continue`, wantErr: `invoked "continue" outside of a loop`},
+		{name: "break inside if outside of a loop", src: `if 1 break`, wantErr: `invoked "break" outside of a loop`},
+		{
+			name:    "break does not cross a proc",
+			src:     `proc f {} {break}; foreach x {1 2} {f}`,
+			wantErr: `invoked "break" outside of a loop`,
+		},
+		{
+			name:    "continue does not cross a proc",
+			src:     `proc f {} {continue}; while 1 {f}`,
+			wantErr: `invoked "continue" outside of a loop`,
+		},
+		{name: "while wrong # args", src: `while 1`, wantErr: `wrong # args: should be "while test command"`},
+		{name: "for wrong # args", src: `for {} 1 {}`, wantErr: `wrong # args: should be "for start test next command"`},
+		{name: "foreach wrong # args", src: `foreach x {1}`, wantErr: `wrong # args: should be "foreach varList list ?varList list ...? command"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%q: got %q, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q failed: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("%q = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
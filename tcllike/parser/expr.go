@@ -0,0 +1,354 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/types"
+)
+
+// binaryLevels lists the binary operators of expressions by precedence, from
+// the loosest to the tightest binding, like in Tcl. ** is right associative,
+// the others are left associative. Unary -, +, ~ and ! bind tighter than any
+// of them, and ?: looser.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"in", "ni"},
+	{"eq", "ne"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"**"},
+}
+
+// operators are the symbols scanOperator recognizes, longest first so that
+// a prefix like < never hides <= or <<.
+var operators = []string{
+	"**", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"*", "/", "%", "+", "-", "<", ">", "&", "^", "|", "?", ":",
+}
+
+// wordOperators are the operators spelled like words.
+var wordOperators = []string{"eq", "ne", "in", "ni"}
+
+// booleans are the barewords an expression accepts as operands.
+var booleans = []string{"true", "false", "yes", "no", "on", "off"}
+
+// ParseExpr parses text, which starts at start in the source, as an
+// expression in the language of Tcl's expr. Operands are numbers, booleans,
+// $variables, [command substitutions], "quoted" and {braced} strings, math
+// functions like sqrt(x) and parenthesized expressions. Operators, from the
+// tightest to the loosest binding, are unary - + ~ !, then **, * / %, + -,
+// << >>, < > <= >=, == !=, eq ne, in ni, &, ^, |, &&, || and ?:. Whitespace,
+// newlines included, may separate any of them.
+func ParseExpr(text string, start types.Pos) (types.ASTNode, error) {
+	return parseExpr(text, start, nesting{})
+}
+
+// parseExpr is ParseExpr for an expression that nests within outer.
+func parseExpr(text string, start types.Pos, outer nesting) (types.ASTNode, error) {
+	p := &exprParser{text: text, start: start, nesting: outer}
+	expr, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.i < len(p.text) {
+		return nil, p.expected("operator or end of expression")
+	}
+	return expr, nil
+}
+
+type exprParser struct {
+	text  string
+	start types.Pos
+	// i is the offset of the next byte to read in text
+	i int
+	nesting
+}
+
+func (p *exprParser) spanOf(from, to int) types.Span {
+	begin := p.start.Advance(p.text[:from])
+	return types.Span{Start: begin, End: begin.Advance(p.text[from:to])}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.i < len(p.text) && strings.IndexByte(" \t\r\n", p.text[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// expected reports that the parser expected what at the next character.
+func (p *exprParser) expected(what string) *ParseError {
+	p.skipSpace()
+	if p.i >= len(p.text) {
+		return &ParseError{Span: p.spanOf(p.i, p.i), Expected: what, Found: "end of expression"}
+	}
+	_, size := utf8.DecodeRuneInString(p.text[p.i:])
+	return &ParseError{Span: p.spanOf(p.i, p.i+size), Expected: what, Found: fmt.Sprintf("%q", p.text[p.i:p.i+size])}
+}
+
+// scanOperator returns the operator at the next non-blank character, or ""
+// if there is none, without consuming it.
+func (p *exprParser) scanOperator() string {
+	p.skipSpace()
+	rest := p.text[p.i:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			return op
+		}
+	}
+	if word := rest[:scanIdentifier(rest, 0)]; slices.Contains(wordOperators, word) {
+		return word
+	}
+	return ""
+}
+
+func (p *exprParser) parseTernary() (types.ASTNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.scanOperator() != "?" {
+		return cond, nil
+	}
+	if err := p.enter(p.spanOf(p.i, p.i+1)); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	p.i++
+
+	then, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.scanOperator() != ":" {
+		return nil, p.expected(`":" of ?:`)
+	}
+	p.i++
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return &types.TernaryNode{Span: cond.SourceSpan().To(otherwise.SourceSpan()), Cond: cond, Then: then, Else: otherwise}, nil
+}
+
+// parseBinary parses operands joined by the operators of binaryLevels[level]
+// and of the levels binding tighter.
+func (p *exprParser) parseBinary(level int) (types.ASTNode, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.scanOperator()
+		if !slices.Contains(binaryLevels[level], op) {
+			return left, nil
+		}
+		p.i += len(op)
+
+		rightLevel := level + 1
+		if op == "**" {
+			// the right operand nests the rest of a chain like 2**3**2
+			rightLevel = level
+			if err := p.enter(p.spanOf(p.i-len(op), p.i)); err != nil {
+				return nil, err
+			}
+		}
+		right, err := p.parseBinary(rightLevel)
+		if err != nil {
+			return nil, err
+		}
+		if op == "**" {
+			p.leave()
+		}
+		left = &types.BinaryNode{Span: left.SourceSpan().To(right.SourceSpan()), Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (types.ASTNode, error) {
+	p.skipSpace()
+	if p.i >= len(p.text) || strings.IndexByte("-+~!", p.text[p.i]) < 0 {
+		return p.parsePrimary()
+	}
+	start := p.i
+	op := p.text[p.i : p.i+1]
+	if err := p.enter(p.spanOf(start, start+1)); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	p.i++
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &types.UnaryNode{Span: p.spanOf(start, start+1).To(operand.SourceSpan()), Op: op, Operand: operand}, nil
+}
+
+func (p *exprParser) parsePrimary() (types.ASTNode, error) {
+	p.skipSpace()
+	if p.i >= len(p.text) {
+		return nil, p.expected("operand")
+	}
+	start := p.i
+	rest := p.text[p.i:]
+
+	switch ch := rest[0]; {
+	case ch == '(':
+		if err := p.enter(p.spanOf(start, start+1)); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		p.i++
+		inner, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if p.skipSpace(); p.i >= len(p.text) || p.text[p.i] != ')' {
+			return nil, p.expected(") closing (")
+		}
+		p.i++
+		return inner, nil
+	case ch == '$':
+		end := 1
+		var name string
+		if strings.HasPrefix(rest, "${") {
+			closing := strings.IndexByte(rest, '}')
+			if closing < 0 {
+				return nil, &ParseError{Span: p.spanOf(start, len(p.text)), Expected: "} closing ${", Found: "end of expression"}
+			}
+			name, end = rest[2:closing], closing+1
+		} else if end = scanVarName(rest, 1); end > 1 {
+			name = rest[1:end]
+		} else {
+			p.i++
+			return nil, p.expected("variable name after $")
+		}
+		p.i += end
+		return &types.VarNode{Span: p.spanOf(start, p.i), Name: name}, nil
+	case ch == '[':
+		end, closed, depth := lexer.ScanBracketedDepth(rest, 0)
+		if !closed {
+			return nil, &ParseError{Span: p.spanOf(start, start+1), Expected: "] closing [", Found: "end of expression"}
+		}
+		if err := p.fits(p.spanOf(start, start+1), depth); err != nil {
+			return nil, err
+		}
+		p.i += end
+		node, err := parseCommandSubstitution(rest[1:end-1], p.start.Advance(p.text[:start+1]), p.spanOf(start, p.i), &p.nesting)
+		if err != nil {
+			return nil, wrapParse("command substitution", err)
+		}
+		return node, nil
+	case ch == '"':
+		end, closed := lexer.ScanQuoted(rest, 0)
+		if !closed {
+			return nil, &ParseError{Span: p.spanOf(start, start+1), Expected: "closing double quote", Found: "end of expression"}
+		}
+		p.i += end
+		parts, substituted, err := parseSubstitutions(rest[1:end-1], p.start.Advance(p.text[:start+1]), &p.nesting)
+		if err != nil {
+			return nil, err
+		}
+		return quotedWord(parts, substituted, p.spanOf(start, p.i)), nil
+	case ch == '{':
+		end, closed := lexer.ScanBraced(rest, 0)
+		if !closed {
+			return nil, &ParseError{Span: p.spanOf(start, start+1), Expected: "} closing {", Found: "end of expression"}
+		}
+		p.i += end
+		return &types.BracedNode{Span: p.spanOf(start, p.i), Value: rest[1 : end-1]}, nil
+	case '0' <= ch && ch <= '9', ch == '.':
+		p.i += scanNumber(rest)
+		return parseNumber(p.text[start:p.i], p.spanOf(start, p.i))
+	case ch == '_', 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z':
+		p.i += scanIdentifier(rest, 0)
+		word := p.text[start:p.i]
+		if p.skipSpace(); p.i < len(p.text) && p.text[p.i] == '(' {
+			return p.parseFunc(word, start)
+		}
+		if slices.Contains(booleans, strings.ToLower(word)) {
+			return &types.StringNode{Span: p.spanOf(start, start+len(word)), Value: word}, nil
+		}
+		return nil, &ParseError{Span: p.spanOf(start, start+len(word)), Expected: "operand", Found: fmt.Sprintf("invalid bareword %q", word)}
+	default:
+		return nil, p.expected("operand")
+	}
+}
+
+// parseFunc parses the arguments of the math function name, whose call
+// starts at start, from the opening paren on.
+func (p *exprParser) parseFunc(name string, start int) (types.ASTNode, error) {
+	if err := p.enter(p.spanOf(start, p.i+1)); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	p.i++
+	args := make([]types.ASTNode, 0)
+	if p.skipSpace(); p.i < len(p.text) && p.text[p.i] == ')' {
+		p.i++
+		return &types.FuncNode{Span: p.spanOf(start, p.i), Name: name, Args: args}, nil
+	}
+	for {
+		arg, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.skipSpace(); p.i < len(p.text) && p.text[p.i] == ',' {
+			p.i++
+			continue
+		}
+		if p.i < len(p.text) && p.text[p.i] == ')' {
+			p.i++
+			return &types.FuncNode{Span: p.spanOf(start, p.i), Name: name, Args: args}, nil
+		}
+		return nil, p.expected(fmt.Sprintf(`"," or ")" in the arguments of %s`, name))
+	}
+}
+
+// scanIdentifier returns the index just past the letters, digits and
+// underscores starting at s[start].
+func scanIdentifier(s string, start int) int {
+	i := start
+	for i < len(s) {
+		ch := s[i]
+		if ch != '_' && !('a' <= ch && ch <= 'z') && !('A' <= ch && ch <= 'Z') && !('0' <= ch && ch <= '9') {
+			break
+		}
+		i++
+	}
+	return i
+}
+
+// scanNumber returns the length of the number literal s starts with. It
+// takes every letter and digit that follows, so a malformed literal like 1x
+// is reported by parseNumber rather than read as 1 followed by x.
+func scanNumber(s string) int {
+	hex := strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")
+	i := 0
+	for i < len(s) {
+		ch := s[i]
+		switch {
+		case ch == '.', ch == '_', 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9':
+			i++
+		case (ch == '+' || ch == '-') && !hex && i > 0 && (s[i-1] == 'e' || s[i-1] == 'E'):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
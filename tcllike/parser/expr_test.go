@@ -0,0 +1,79 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExprRules checks the operators, the integer arithmetic and the math
+// functions of expr against Tcl.
+func TestExprRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "multiplication before addition", src: `expr {1 + 2 * 3}`, want: "7"},
+		{name: "parentheses", src: `expr {(1 + 2) * 3}`, want: "9"},
+		{name: "subtraction is left-associative", src: `expr {10 - 4 - 3}`, want: "3"},
+		{name: "** is right-associative", src: `expr {2 ** 3 ** 2}`, want: "512"},
+		{name: "** binds tighter than unary minus", src: `expr {-2 ** 2}`, want: "4"},
+		{name: "comparison before equality", src: `expr {1 < 2 == 1}`, want: "1"},
+		{name: "&& before ||", src: `expr {1 || 0 && 0}`, want: "1"},
+		{name: "ternary", src: `expr {0 ? "a" : 1 ? "b" : "c"}`, want: "b"},
+		{name: "integer division rounds down", src: `expr {7 / -2}`, want: "-4"},
+		{name: "negative integer division", src: `expr {-7 / 2}`, want: "-4"},
+		{name: "remainder takes the sign of the divisor", src: `expr {-7 % 2}`, want: "1"},
+		{name: "negative divisor remainder", src: `expr {7 % -2}`, want: "-1"},
+		{name: "float division", src: `expr {7 / 2.0}`, want: "3.5"},
+		{name: "integral float keeps its point", src: `expr {1.0 + 1}`, want: "2.0"},
+		{name: "divide by zero", src: `expr {1 / 0}`, wantErr: "divide by zero"},
+		{name: "remainder by zero", src: `expr {1 % 0}`, wantErr: "divide by zero"},
+		{name: "addition overflow", src: `expr {9223372036854775807 + 1}`, wantErr: "integer overflow"},
+		{name: "multiplication overflow", src: `expr {4611686018427387904 * 2}`, wantErr: "integer overflow"},
+		{name: "negation overflow", src: `expr {-(-9223372036854775807 - 1)}`, wantErr: "integer overflow"},
+		{name: "power overflow", src: `expr {2 ** 63}`, wantErr: "integer overflow"},
+		{name: "largest integer", src: `expr {2 ** 62 - 1 + 2 ** 62}`, want: "9223372036854775807"},
+		{name: "&& skips its right operand", src: `set n 0; expr {0 && [incr n]}; set n`, want: "0"},
+		{name: "|| skips its right operand", src: `set n 0; expr {1 || [incr n]}; set n`, want: "0"},
+		{name: "&& runs its right operand", src: `set n 0; expr {1 && [incr n]}; set n`, want: "1"},
+		{name: "ternary skips the other branch", src: `set n 0; expr {1 ? 2 : [incr n]}; set n`, want: "0"},
+		{name: "in finds a list element", src: `expr {"b" in {a b c}}`, want: "1"},
+		{name: "in misses", src: `expr {"d" in {a b c}}`, want: "0"},
+		{name: "ni", src: `expr {"d" ni {a b c}}`, want: "1"},
+		{name: "eq compares strings", src: `expr {"1" eq "1.0"}`, want: "0"},
+		{name: "== compares numbers", src: `expr {"1" == "1.0"}`, want: "1"},
+		{name: "ne", src: `expr {"a" ne "b"}`, want: "1"},
+		{name: "string comparison", src: `expr {"abc" < "abd"}`, want: "1"},
+		{name: "abs", src: `expr {abs(-3)}`, want: "3"},
+		{name: "sqrt", src: `expr {sqrt(16)}`, want: "4.0"},
+		{name: "pow", src: `expr {pow(2, 10)}`, want: "1024.0"},
+		{name: "int truncates", src: `expr {int(-3.7)}`, want: "-3"},
+		{name: "double", src: `expr {double(3)}`, want: "3.0"},
+		{name: "round half away from zero", src: `expr {round(-2.5)}`, want: "-3"},
+		{name: "sqrt of a negative number", src: `expr {sqrt(-1)}`, wantErr: "domain error"},
+		{name: "unknown math function", src: `expr {nope(1)}`, wantErr: `unknown math function "nope"`},
+		{name: "too many arguments", src: `expr {abs(1, 2)}`, wantErr: `too many arguments for math function "abs"`},
+		{name: "non-numeric argument", src: `expr {abs("a")}`, wantErr: `expected number but got "a"`},
+		{name: "unbraced arguments are joined", src: `expr 1 + 2`, want: "3"},
+		{name: "missing operand", src: `expr {1 +}`, wantErr: "failed to parse expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%q: got %q, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q failed: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("%q = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
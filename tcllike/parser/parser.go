@@ -5,52 +5,158 @@ package parser
 import (
 	"errors"
 	"fmt"
-	"strconv"
 
+	"simlang/tcllike/lexer"
 	"simlang/tcllike/types"
+	"simlang/util"
 )
 
+// ParseError is returned for every input Parse cannot turn into an AST. It
+// names what the parser was looking for and what it found instead.
+type ParseError struct {
+	types.Span
+	Expected string
+	Found    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: expected %s but found %s", e.Start, e.Expected, e.Found)
+}
+
+func expectedAt(token types.Token, expected string) *ParseError {
+	return &ParseError{Span: token.Span, Expected: expected, Found: describeToken(token)}
+}
+
+// wrapParse adds that parsing what failed to err, unless err already points
+// at the offending input. Without that, an error deep in nested command
+// substitutions would carry a few prefixes for every level around it.
+func wrapParse(what string, err error) error {
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return err
+	}
+	return fmt.Errorf("failed to parse %s: %w", what, err)
+}
+
+func describeToken(token types.Token) string {
+	if token.Type == types.EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%s %q", token.Type, token.Value)
+}
+
+// MaxNestingDepth bounds how deeply command substitutions and expressions
+// may nest, like Tcl's default recursion limit, so that deep nesting is a
+// ParseError rather than a stack overflow. Each level of a substitution is
+// lexed again, so the depth of a substitution is checked as a whole before
+// any of it is lexed; that keeps a long run of brackets from taking
+// quadratic time.
+const MaxNestingDepth = 1000
+
+// nesting counts the command substitutions and expressions around the text
+// being parsed.
+type nesting struct {
+	depth int
+	// outermost spans the opener of the first of them
+	outermost types.Span
+}
+
+// enter starts parsing a construct opened by opener that may nest further
+// ones. Every successful enter must be paired with a leave.
+func (n *nesting) enter(opener types.Span) error {
+	if err := n.fits(opener, 1); err != nil {
+		return err
+	}
+	if n.depth == 0 {
+		n.outermost = opener
+	}
+	n.depth++
+	return nil
+}
+
+// fits checks that levels more levels, the first opened by opener, stay
+// within MaxNestingDepth.
+func (n *nesting) fits(opener types.Span, levels int) error {
+	if n.depth+levels <= MaxNestingDepth {
+		return nil
+	}
+	outermost := n.outermost
+	if n.depth == 0 {
+		outermost = opener
+	}
+	return &ParseError{Span: outermost, Expected: fmt.Sprintf("at most %d levels of nesting", MaxNestingDepth), Found: "more"}
+}
+
+func (n *nesting) leave() {
+	n.depth--
+}
+
 type ParsingContext struct {
 	tokens            []types.Token
 	currentTokenIndex int
+	nesting
 }
 
+// currentToken returns an EOF token once every token has been consumed, so
+// callers never index past the end of the input.
 func (p *ParsingContext) currentToken() types.Token {
+	if p.currentTokenIndex >= len(p.tokens) {
+		return p.eofToken()
+	}
 	return p.tokens[p.currentTokenIndex]
 }
 
+func (p *ParsingContext) eofToken() types.Token {
+	end := types.Pos{Offset: 0, Line: 1, Column: 1}
+	if len(p.tokens) > 0 {
+		end = p.tokens[len(p.tokens)-1].End
+	}
+	return types.Token{Type: types.EOF, Span: types.Span{Start: end, End: end}}
+}
+
 func (p *ParsingContext) hasNextToken() bool {
 	return p.currentTokenIndex < len(p.tokens)
 }
 
 func (p *ParsingContext) consume() (types.Token, error) {
-	if p.currentTokenIndex >= len(p.tokens) {
-		return types.Token{}, errors.New("unexpected end of input in consume")
-	}
 	token := p.currentToken()
 	p.currentTokenIndex++
+	if token.Type == types.EOF {
+		return token, expectedAt(token, "more input")
+	}
 	return token, nil
 }
 
 func (p *ParsingContext) back() {
-	if p.currentTokenIndex == 0 {
-		panic("unexpected end of input in back")
+	if p.currentTokenIndex > 0 {
+		p.currentTokenIndex--
 	}
-	p.currentTokenIndex--
 }
 
-func Parse(tokens []types.Token) (*types.AST, error) {
-	if len(tokens) == 0 {
-		return nil, errors.New("empty input")
+// withoutTrivia drops comments from tokens.
+func withoutTrivia(tokens []types.Token) []types.Token {
+	filtered := make([]types.Token, 0, len(tokens))
+	for _, token := range tokens {
+		if !token.Type.IsTrivia() {
+			filtered = append(filtered, token)
+		}
 	}
+	return filtered
+}
 
+// Parse turns tokens into an AST. It never panics: every malformed or
+// truncated input is reported as a *ParseError.
+func Parse(tokens []types.Token) (*types.AST, error) {
+	// an empty script (or one holding only comments) is valid and does nothing
+	tokens = withoutTrivia(tokens)
 	parsingContext := ParsingContext{tokens: tokens, currentTokenIndex: 0}
+
 	node, err := parseLines(&parsingContext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse lines: %w\n input was %v", err, tokens)
+		return nil, wrapParse("lines", err)
 	}
 	if parsingContext.hasNextToken() {
-		return nil, fmt.Errorf("node is parsed but tokens remains, node: %v", node)
+		return nil, expectedAt(parsingContext.currentToken(), "end of input")
 	}
 
 	return &types.AST{Root: node}, nil
@@ -62,49 +168,78 @@ func parseLines(parsingContext *ParsingContext) (*types.LinesNode, error) {
 	for parsingContext.hasNextToken() {
 		token, err := parsingContext.consume()
 		if err != nil {
-			return nil, fmt.Errorf("failed to consume", err)
+			return nil, fmt.Errorf("failed to consume: %w", err)
 		}
 
 		switch token.Type {
+		case types.LineEnd:
+			// blank line
+			continue
 		case types.Number:
-			num := parseFloat64(token.Value)
-			lines = append(lines, &types.NumberNode{Value: num})
-		case types.Atom:
-			nextToken, nextTokenErr := parsingContext.consume()
-			if nextTokenErr != nil {
-				return nil, fmt.Errorf("failed to consume next of atom", nextTokenErr)
+			num, err := parseNumber(token.Value, token.Span)
+			if err != nil {
+				return nil, err
 			}
-			if nextToken.Type == types.LineEnd {
-				parsingContext.back()
-				lines = append(lines, &types.SymbolNode{Name: token.Value})
+			lines = append(lines, num)
+		case types.String:
+			str, err := parseString(parsingContext, token)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, str)
+		case types.Braced:
+			braced, err := parseBraced(parsingContext, token)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, braced)
+		case types.Atom:
+			nextType := parsingContext.currentToken().Type
+			if nextType == types.LineEnd || nextType == types.EOF {
+				word, err := parseWord(parsingContext, token)
+				if err != nil {
+					return nil, err
+				}
+				lines = append(lines, word)
 			} else {
-				parsingContext.back()
 				parsingContext.back()
 				node, err := parseCall(parsingContext)
 				if err != nil {
-					return nil, fmt.Errorf("failed to parse call: %w", err)
+					return nil, wrapParse("call", err)
 				}
 				lines = append(lines, node)
 			}
 		default:
-			return nil, fmt.Errorf("unexpected token: %v", token)
+			return nil, expectedAt(token, "command")
 		}
 
 		if err := consumeLineEnd(parsingContext); err != nil {
-			return nil, fmt.Errorf("failed to parse line: %w", err)
+			return nil, wrapParse("line", err)
 		} // consumeLineEnd(parsingContext)
 	}
 
-	return &types.LinesNode{Lines: lines}, nil
+	linesNode := &types.LinesNode{Lines: lines}
+	if len(lines) > 0 {
+		linesNode.Span = lines[0].SourceSpan().To(lines[len(lines)-1].SourceSpan())
+	}
+	return linesNode, nil
 }
 
-func parseCall(parsingContext *ParsingContext) (types.ASTNode, error) {
+func parseCall(parsingContext *ParsingContext) (*types.CallNode, error) {
 	funcToken, err := parsingContext.consume()
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse call(first function name): %w", err)
+		return nil, wrapParse("call(first function name)", err)
 	}
 	if funcToken.Type != types.Atom {
-		return nil, fmt.Errorf("failed to parse call invalid atom, there should be function call atom %v, ", funcToken.Type)
+		return nil, expectedAt(funcToken, "command name")
+	}
+	funcName, err := parseWord(parsingContext, funcToken)
+	if err != nil {
+		return nil, err
+	}
+	funcSymbol, ok := funcName.(*types.SymbolNode)
+	if !ok {
+		return nil, &ParseError{Span: funcToken.Span, Expected: "command name", Found: fmt.Sprintf("word with substitutions %q", funcToken.Value)}
 	}
 	args := make([]types.ASTNode, 0)
 
@@ -113,7 +248,7 @@ loop:
 	for parsingContext.hasNextToken() {
 		nextToken, err := parsingContext.consume()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse call(arg %d): %w", argIndex, err)
+			return nil, wrapParse(fmt.Sprintf("call(arg %d)", argIndex), err)
 		}
 		argIndex++
 		if nextToken.Type == types.LineEnd {
@@ -123,7 +258,7 @@ loop:
 		parsingContext.back()
 		arg, argErr := maybeParseValue(parsingContext)
 		if argErr != nil {
-			return nil, fmt.Errorf("failed to parse call arg %d: %w", argIndex, argErr)
+			return nil, wrapParse(fmt.Sprintf("call arg %d", argIndex), argErr)
 		}
 		if arg == nil {
 			break loop
@@ -131,14 +266,14 @@ loop:
 		args = append(args, arg)
 	}
 
-	return &types.CallNode{FuncName: funcToken.Value, Args: args}, nil
+	span := funcToken.Span
+	if len(args) > 0 {
+		span = span.To(args[len(args)-1].SourceSpan())
+	}
+	return &types.CallNode{Span: span, FuncName: funcSymbol.Name, Args: args}, nil
 }
 
 func maybeParseValue(parsingContext *ParsingContext) (types.ASTNode, error) {
-	if parsingContext.hasNextToken() == false {
-		return nil, fmt.Errorf("try to parse value but found EOF")
-	}
-
 	token, err := parsingContext.consume()
 	if err != nil {
 		return nil, fmt.Errorf("try to parse value but failed to consume token: %w", err)
@@ -146,103 +281,69 @@ func maybeParseValue(parsingContext *ParsingContext) (types.ASTNode, error) {
 
 	switch token.Type {
 	case types.Atom:
-		return &types.SymbolNode{Name: token.Value}, nil
+		return parseWord(parsingContext, token)
 	case types.Number:
-		return &types.NumberNode{Value: parseFloat64(token.Value)}, nil
-	case types.LBracket:
-		parsedCall, err := parseCall(parsingContext)
+		return parseNumber(token.Value, token.Span)
+	case types.String:
+		return parseString(parsingContext, token)
+	case types.Braced:
+		return parseBraced(parsingContext, token)
+	case types.Expand:
+		word, err := maybeParseValue(parsingContext)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse value: %w", err)
+			return nil, wrapParse("value", err)
 		}
-		consumeRBracket(parsingContext)
-		return parsedCall, nil
-	case types.LParen:
-		parsingContext.back()
-		parsedParen, err := parseParen(parsingContext)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse value: %w", err)
+		if word == nil {
+			return nil, expectedAt(parsingContext.currentToken(), "word after {*}")
+		}
+		if _, nested := word.(*types.ExpandNode); nested {
+			return nil, &ParseError{Span: word.SourceSpan(), Expected: "word after {*}", Found: "another {*}"}
 		}
-		return parsedParen, nil
+		return &types.ExpandNode{Span: token.To(word.SourceSpan()), Word: word}, nil
+	case types.Expr:
+		return parseParenExpr(parsingContext, token)
 	default:
 		parsingContext.back()
 		return nil, nil
 	}
 }
 
-func parseFloat64(value string) float64 {
-	if value == "" {
-		return 0
+// parseNumber parses the number literal spanning span. Integers that fit in
+// an int64 are kept exact.
+func parseNumber(literal string, span types.Span) (*types.NumberNode, error) {
+	number, numberErr := util.ParseNumber(literal)
+	if numberErr != nil {
+		start := span.Start.Advance(literal[:numberErr.Offset])
+		end := span.End
+		if numberErr.Offset < len(literal) {
+			end = start.Advance(literal[numberErr.Offset : numberErr.Offset+1])
+		}
+		return nil, &ParseError{Span: types.Span{Start: start, End: end}, Expected: numberErr.Expected, Found: numberErr.Found}
 	}
-	f, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		panic(err)
+	node := &types.NumberNode{Span: span, Value: number.Float}
+	if number.IsExact() && number.Exact.IsInt() && number.Exact.Num().IsInt64() {
+		node.Int, node.IsInt = number.Exact.Num().Int64(), true
 	}
-	return f
+	return node, nil
 }
 
-func parseParen(parsingContext *ParsingContext) (types.ASTNode, error) {
-	token, err := parsingContext.consume()
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse lparen: %w", err)
+// parseParenExpr parses a parenthesized expression used as a word.
+func parseParenExpr(parsingContext *ParsingContext, token types.Token) (types.ASTNode, error) {
+	if _, closed := lexer.ScanParen(token.Value, 0); !closed {
+		return nil, &ParseError{Span: types.Span{Start: token.Start, End: token.Start.Advance("(")}, Expected: ") closing (", Found: "end of input"}
 	}
-	if token.Type != types.LParen {
-		return nil, fmt.Errorf("expected lparen, got %v", token)
+	if err := checkWordEnd(parsingContext, token); err != nil {
+		return nil, err
 	}
-
-	elements := make([]types.ASTNode, 0)
-loop:
-	for parsingContext.hasNextToken() {
-		token, err := parsingContext.consume()
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse lparen: %w", err)
-		}
-
-		switch token.Type {
-		case types.RParen:
-			break loop
-		case types.LParen:
-			parsingContext.back()
-			inner, err := parseParen(parsingContext)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse lparen: %w", err)
-			}
-			elements = append(elements, inner)
-		case types.Atom:
-			elements = append(elements, &types.SymbolNode{Name: token.Value})
-		case types.Number:
-			elements = append(elements, &types.NumberNode{Value: parseFloat64(token.Value)})
-		}
+	if err := parsingContext.enter(types.Span{Start: token.Start, End: token.Start.Advance("(")}); err != nil {
+		return nil, err
 	}
-
-	if len(elements) == 0 {
-		return nil, errors.New("empty elements")
-	}
-
-	if len(elements) != 3 {
-		return nil, fmt.Errorf("expected 3 elements in paren, got %d", len(elements))
-	}
-
-	leftArg := elements[0]
-	operator := elements[1]
-	rightArg := elements[2]
-
-	operatorSymbol, ok := operator.(*types.SymbolNode)
-	if !ok {
-		return nil, fmt.Errorf("expected operator to be symbol node, got %T", operator)
-	}
-
-	return &types.CallNode{FuncName: operatorSymbol.Name, Args: []types.ASTNode{leftArg, rightArg}}, nil
-}
-
-func consumeRBracket(parsingContext *ParsingContext) error {
-	token, err := parsingContext.consume()
+	defer parsingContext.leave()
+	expr, err := parseExpr(token.Value[1:len(token.Value)-1], token.Start.Advance("("), parsingContext.nesting)
 	if err != nil {
-		return fmt.Errorf("failed to parse rbracket: %w", err)
+		return nil, wrapParse("expression", err)
 	}
-	if token.Type != types.RBracket {
-		return fmt.Errorf("expected rbracket, got %v", token)
-	}
-	return nil
+	return expr, nil
 }
 
 func consumeLineEnd(parsingContext *ParsingContext) error {
@@ -252,11 +353,11 @@ func consumeLineEnd(parsingContext *ParsingContext) error {
 	}
 	token, err := parsingContext.consume()
 	if err != nil {
-		return fmt.Errorf("failed to parse line end: %w", err)
+		return wrapParse("line end", err)
 	}
 
 	if token.Type != types.LineEnd {
-		return fmt.Errorf("expected line end, got %v", token)
+		return expectedAt(token, "line end")
 	}
 	return nil
 }
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/types"
+)
+
+// FuzzParse checks that Parse and ParseExpr are total: whatever the input,
+// they return an AST or a *ParseError and never panic.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"print 3",
+		"print [+ 1 2]",
+		"print [+ 1",
+		"print (1 + (2 + 3))",
+		"print (1 +",
+		"set x 3; print [+ $x 1]",
+		"print ${x} ${",
+		`print "a $x [+ 1 2] \n"`,
+		`print "unterminated`,
+		"proc add {a {b 10}} {\n  return [+ $a $b]\n}",
+		"proc f {a} {",
+		"print {*}{1 2 3} {*}",
+		"print {a}b",
+		"line \\\n  continued",
+		"# comment\nprint 1",
+		"if {$x < 1 && $y ne {a b}} {print yes} else {print no}",
+		"expr {2 ** -1 ? sqrt(4) : abs(-1, 2)}",
+		"print 0x_ff 1.5e3 1e",
+		"print é a\\é",
+		strings.Repeat("(", 100_000),
+		"print " + strings.Repeat("[", 100_000) + strings.Repeat("]", 100_000),
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		var parseErr *ParseError
+		if _, err := Parse(lexer.Tokenize(input)); err != nil && !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) returned %T, want a *ParseError: %v", input, err, err)
+		}
+		if _, err := ParseExpr(input, types.Pos{Offset: 0, Line: 1, Column: 1}); err != nil && !errors.As(err, &parseErr) {
+			t.Errorf("ParseExpr(%q) returned %T, want a *ParseError: %v", input, err, err)
+		}
+	})
+}
+
+// TestNestingDepth checks that command substitutions and expressions nested
+// deeper than MaxNestingDepth are a *ParseError at the first unclosed
+// opener, returned without a prefix for every level, and that nesting just
+// within the limit still parses.
+func TestNestingDepth(t *testing.T) {
+	tooDeep := MaxNestingDepth + 1
+	tests := []struct {
+		name       string
+		src        string
+		wantOffset int
+	}{
+		{name: "substitutions", src: "print " + strings.Repeat("[f ", tooDeep) + strings.Repeat("]", tooDeep), wantOffset: 6},
+		{name: "a million substitutions", src: "print " + strings.Repeat("[", 1_000_000) + strings.Repeat("]", 1_000_000), wantOffset: 6},
+		{name: "quoted substitutions", src: "print " + strings.Repeat(`["`, tooDeep) + strings.Repeat(`"]`, tooDeep), wantOffset: 6},
+		{name: "parenthesized word", src: "print " + strings.Repeat("(", tooDeep) + "1" + strings.Repeat(")", tooDeep), wantOffset: 6},
+		{name: "parens", src: "expr {" + strings.Repeat("(", 3_000_000) + "}", wantOffset: 6},
+		{name: "unary operators", src: "expr {" + strings.Repeat("-", tooDeep) + "1}", wantOffset: 6},
+		{name: "exponents", src: "expr {" + strings.Repeat("2**", tooDeep) + "1}", wantOffset: 7},
+		{name: "ternaries", src: "expr {" + strings.Repeat("1?1:", tooDeep) + "1}", wantOffset: 7},
+		{name: "math functions", src: "expr {" + strings.Repeat("abs(", tooDeep) + "1" + strings.Repeat(")", tooDeep) + "}", wantOffset: 6},
+		{name: "expression in substitutions", src: "print [f [g " + strings.Repeat("(", tooDeep), wantOffset: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if script, isExpr := strings.CutPrefix(tt.src, "expr {"); isExpr {
+				start := types.Pos{Offset: 0, Line: 1, Column: 1}.Advance("expr {")
+				_, err = ParseExpr(strings.TrimSuffix(script, "}"), start)
+			} else {
+				_, err = Parse(lexer.Tokenize(tt.src))
+			}
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("got %T, want a bare *ParseError: %.200v", err, err)
+			}
+			if parseErr.Start.Offset != tt.wantOffset {
+				t.Errorf("error at offset %d, want %d: %v", parseErr.Start.Offset, tt.wantOffset, err)
+			}
+		})
+	}
+
+	depth := MaxNestingDepth - 1
+	src := "print " + strings.Repeat("[f ", depth) + "(1)" + strings.Repeat("]", depth)
+	if _, err := Parse(lexer.Tokenize(src)); err != nil {
+		t.Errorf("%d nested substitutions failed to parse: %.200v", depth, err)
+	}
+}
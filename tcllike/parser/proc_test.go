@@ -0,0 +1,69 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestProcs checks proc parameters, return and the scope of proc variables
+// against Tcl.
+func TestProcs(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "parameters", src: `proc add {a b} {return [expr {$a + $b}]}; add 1 2`, want: "3"},
+		{name: "result of the last command", src: `proc f {} {set x 5}; f`, want: "5"},
+		{name: "default used", src: `proc f {a {b 10}} {expr {$a + $b}}; f 1`, want: "11"},
+		{name: "default overridden", src: `proc f {a {b 10}} {expr {$a + $b}}; f 1 2`, want: "3"},
+		{name: "only defaults", src: `proc greet {{name world}} {return "hello $name"}; greet`, want: "hello world"},
+		{name: "args collects the rest", src: `proc f {a args} {words $a $args}; f 1 2 3`, want: "1|2 3"},
+		{name: "args may be empty", src: `proc f {a args} {words $a $args}; f 1`, want: "1|"},
+		{name: "args is a proper list", src: `proc f args {return $args}; f a {b c}`, want: "a {b c}"},
+		{name: "args after a default", src: `proc f {{a 1} args} {words $a $args}; f`, want: "1|"},
+		{name: "too few arguments", src: `proc f {a b} {}; f 1`, wantErr: `wrong # args: should be "f a b"`},
+		{name: "too many arguments", src: `proc f {a b} {}; f 1 2 3`, wantErr: `wrong # args: should be "f a b"`},
+		{name: "usage shows defaults", src: `proc f {a {b 1}} {}; f`, wantErr: `wrong # args: should be "f a ?b?"`},
+		{name: "usage shows args", src: `proc f {a args} {}; f`, wantErr: `wrong # args: should be "f a ?arg ...?"`},
+		{name: "return ends the proc", src: `proc f {} {return 1; return 2}; f`, want: "1"},
+		{name: "return without a value", src: `proc f {} {set x 1; return}; f`, want: ""},
+		{
+			name: "return from inside a loop",
+			src:  `proc f {} {foreach x {1 2 3} {if {$x == 2} {return $x}}; return none}; f`,
+			want: "2",
+		},
+		{name: "return from a nested script", src: `proc f {} {if 1 {return a}; return b}; f`, want: "a"},
+		{name: "return at top level ends the script", src: `return 7; words unreachable`, want: "7"},
+		{name: "return wrong # args", src: `return 1 2`, wantErr: `wrong # args: should be "return ?value?"`},
+		{
+			name: "recursion",
+			src:  `proc fact n {if {$n <= 1} {return 1}; expr {$n * [fact [expr {$n - 1}]]}}; fact 10`,
+			want: "3628800",
+		},
+		{name: "variables are local", src: `set x 1; proc f {} {set x 2}; f; set x`, want: "1"},
+		{name: "globals are not visible", src: `set x 1; proc f {} {return $x}; f`, wantErr: `can't read "x": no such variable`},
+		{name: "redefinition", src: `proc f {} {return 1}; proc f {} {return 2}; f`, want: "2"},
+		{name: "proc gives nothing", src: `proc f {} {}`, want: ""},
+		{name: "proc wrong # args", src: `proc f {}`, wantErr: `wrong # args: should be "proc name args body"`},
+		{name: "bad parameter", src: `proc f {{a 1 2}} {}`, wantErr: "too many fields in argument specifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%q: got %q, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q failed: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("%q = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestVariables checks variable substitution and set, unset, incr and append
+// against Tcl.
+func TestVariables(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{name: "set gives the value", src: `set x 5`, want: "5"},
+		{name: "set reads", src: `set x 5; set x`, want: "5"},
+		{name: "dollar substitution", src: `set x 5; words $x`, want: "5"},
+		{name: "braced substitution", src: `set x 5; words ${x}`, want: "5"},
+		{name: "braced name ends the name", src: `set x 5; words ${x}y`, want: "5y"},
+		{name: "name ends at a non-word character", src: `set x 3; words x$x.y`, want: "x3.y"},
+		{name: "adjacent substitutions", src: `set a 1; set b 2; words $a$b`, want: "12"},
+		{name: "lone dollar", src: `words $ a$`, want: "$|a$"},
+		{name: "float keeps its form", src: `set x 2.0; words $x`, want: "2.0"},
+		{name: "substitution is one word", src: `set x "a b"; words $x`, want: "a b"},
+		{name: "unset variable", src: `words $nope`, wantErr: `can't read "nope": no such variable`},
+		{name: "set of an unset variable", src: `set nope`, wantErr: `can't read "nope": no such variable`},
+		{name: "unset", src: `set x 1; unset x; set x`, wantErr: `can't read "x": no such variable`},
+		{name: "unset several", src: `set a 1; set b 2; unset a b; set b`, wantErr: `can't read "b": no such variable`},
+		{name: "unset of an unset variable", src: `unset nope`, wantErr: `can't unset "nope": no such variable`},
+		{name: "unset gives nothing", src: `set x 1; unset x`, want: ""},
+		{name: "incr by one", src: `set x 5; incr x`, want: "6"},
+		{name: "incr stores", src: `set x 5; incr x; set x`, want: "6"},
+		{name: "incr by an amount", src: `set x 5; incr x -7`, want: "-2"},
+		{name: "incr of an unset variable", src: `incr x 10`, want: "10"},
+		{name: "incr of a string integer", src: `set x "0x10"; incr x`, want: "17"},
+		{name: "incr of a float", src: `set x 1.5; incr x`, wantErr: `expected integer but got "1.5"`},
+		{name: "non-integer increment", src: `set x 1; incr x 1.5`, wantErr: `expected integer but got "1.5"`},
+		{name: "non-numeric increment", src: `set x 1; incr x abc`, wantErr: `expected integer but got "abc"`},
+		{name: "incr overflow", src: `set x 9223372036854775807; incr x`, wantErr: "integer overflow"},
+		{name: "incr wrong # args", src: `incr`, wantErr: `wrong # args: should be "incr varName ?increment?"`},
+		{name: "append", src: `append s a b c`, want: "abc"},
+		{name: "append stores", src: `set s x; append s 1 2.0; set s`, want: "x12.0"},
+		{name: "append nothing", src: `set s x; append s`, want: "x"},
+		{name: "append then incr", src: `set n 1; append n 2; incr n`, want: "13"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%q: got %q, %v, want an error containing %q", tt.src, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q failed: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("%q = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"simlang/tcllike/lexer"
+	"simlang/tcllike/types"
+)
+
+// parseWord parses a bare word. Its substitutions are those of Tcl: $name
+// substitutes the variable name, made of letters (any Unicode letter, like
+// in Tcl), digits, underscores and ::,
+// ${name} the variable named by anything up to the closing brace, [script]
+// the result of script, and a backslash sequence the character it stands for.
+// A $ followed by no name is kept as is.
+func parseWord(parsingContext *ParsingContext, token types.Token) (types.ASTNode, error) {
+	parts, substituted, err := parseSubstitutions(token.Value, token.Start, &parsingContext.nesting)
+	if err != nil {
+		return nil, err
+	}
+	if !substituted {
+		return &types.SymbolNode{Span: token.Span, Name: literalValue(parts)}, nil
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return &types.WordNode{Span: token.Span, Parts: parts}, nil
+}
+
+// parseString parses a word in double quotes, which gets the substitutions of
+// a bare word but may hold whitespace and semicolons.
+func parseString(parsingContext *ParsingContext, token types.Token) (types.ASTNode, error) {
+	if _, closed := lexer.ScanQuoted(token.Value, 0); !closed {
+		return nil, &ParseError{Span: types.Span{Start: token.Start, End: token.Start.Advance(`"`)}, Expected: "closing double quote", Found: "end of input"}
+	}
+	if err := checkWordEnd(parsingContext, token); err != nil {
+		return nil, err
+	}
+
+	parts, substituted, err := parseSubstitutions(token.Value[1:len(token.Value)-1], token.Start.Advance(`"`), &parsingContext.nesting)
+	if err != nil {
+		return nil, err
+	}
+	return quotedWord(parts, substituted, token.Span), nil
+}
+
+// quotedWord builds the node of a quoted word spanning span from its parts.
+func quotedWord(parts []types.ASTNode, substituted bool, span types.Span) types.ASTNode {
+	if !substituted {
+		return &types.StringNode{Span: span, Value: literalValue(parts)}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return &types.WordNode{Span: span, Parts: parts}
+}
+
+// parseBraced parses a word in braces into its literal content. Unlike in
+// Tcl, a backslash-newline in braces is kept as is, so the positions in a
+// braced proc body stay those of the source; it still continues the line
+// when the body is run.
+func parseBraced(parsingContext *ParsingContext, token types.Token) (*types.BracedNode, error) {
+	if _, closed := lexer.ScanBraced(token.Value, 0); !closed {
+		// point at the brace left open, the end of input says little
+		return nil, &ParseError{Span: types.Span{Start: token.Start, End: token.Start.Advance("{")}, Expected: "} closing {", Found: "end of input"}
+	}
+	if err := checkWordEnd(parsingContext, token); err != nil {
+		return nil, err
+	}
+	return &types.BracedNode{Span: token.Span, Value: token.Value[1 : len(token.Value)-1]}, nil
+}
+
+// checkWordEnd rejects a quoted, braced or parenthesized word followed by
+// more of the same word, like "a"b, as Tcl does.
+func checkWordEnd(parsingContext *ParsingContext, token types.Token) error {
+	if !parsingContext.hasNextToken() {
+		return nil
+	}
+	next := parsingContext.currentToken()
+	if next.Start != token.End || next.Type == types.LineEnd {
+		return nil
+	}
+	closing := "close-quote"
+	switch token.Type {
+	case types.Braced:
+		closing = "close-brace"
+	case types.Expr:
+		closing = "close-paren"
+	}
+	return &ParseError{Span: next.Span, Expected: "space after " + closing, Found: describeToken(next)}
+}
+
+// parseSubstitutions splits text, which starts at start in the source, into
+// literal StringNodes, with backslash sequences decoded, and the VarNodes and
+// command substitutions between them, which nest within outer. substituted
+// is false when there are only literals.
+func parseSubstitutions(text string, start types.Pos, outer *nesting) (parts []types.ASTNode, substituted bool, err error) {
+	spanOf := func(from, to int) types.Span {
+		begin := start.Advance(text[:from])
+		return types.Span{Start: begin, End: begin.Advance(text[from:to])}
+	}
+
+	parts = make([]types.ASTNode, 0)
+	var literal strings.Builder
+	literalStart := 0
+	flushLiteral := func(end int) {
+		if literal.Len() > 0 {
+			parts = append(parts, &types.StringNode{Span: spanOf(literalStart, end), Value: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		var part types.ASTNode
+		end := i
+		switch text[i] {
+		case '\\':
+			decoded, next := backslash(text, i)
+			literal.WriteString(decoded)
+			i = next
+			continue
+		case '$':
+			if strings.HasPrefix(text[i+1:], "{") {
+				closing := strings.IndexByte(text[i+1:], '}')
+				if closing < 0 {
+					return nil, false, &ParseError{Span: spanOf(i, len(text)), Expected: "} closing ${", Found: "end of word"}
+				}
+				end = i + 1 + closing + 1
+				part = &types.VarNode{Span: spanOf(i, end), Name: text[i+2 : end-1]}
+			} else if end = scanVarName(text, i+1); end > i+1 {
+				part = &types.VarNode{Span: spanOf(i, end), Name: text[i+1 : end]}
+			} else {
+				literal.WriteByte('$')
+				i++
+				continue
+			}
+		case '[':
+			closed, depth := false, 0
+			if end, closed, depth = lexer.ScanBracketedDepth(text, i); !closed {
+				return nil, false, &ParseError{Span: spanOf(i, i+1), Expected: "] closing [", Found: "end of input"}
+			}
+			if err := outer.fits(spanOf(i, i+1), depth); err != nil {
+				return nil, false, err
+			}
+			part, err = parseCommandSubstitution(text[i+1:end-1], start.Advance(text[:i+1]), spanOf(i, end), outer)
+			if err != nil {
+				return nil, false, wrapParse("command substitution", err)
+			}
+		default:
+			literal.WriteByte(text[i])
+			i++
+			continue
+		}
+
+		flushLiteral(i)
+		parts = append(parts, part)
+		substituted = true
+		literalStart, i = end, end
+	}
+	flushLiteral(len(text))
+	return parts, substituted, nil
+}
+
+// literalValue joins parts that are all literals.
+func literalValue(parts []types.ASTNode) string {
+	var builder strings.Builder
+	for _, part := range parts {
+		builder.WriteString(part.(*types.StringNode).Value)
+	}
+	return builder.String()
+}
+
+// scanVarName returns the index just past the variable name starting at
+// word[start].
+func scanVarName(word string, start int) int {
+	i := start
+	for i < len(word) {
+		ch, size := utf8.DecodeRuneInString(word[i:])
+		switch {
+		case ch == '_', unicode.IsLetter(ch), unicode.IsDigit(ch):
+			i += size
+		case strings.HasPrefix(word[i:], "::"):
+			i += 2
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// parseCommandSubstitution parses script, the text between the brackets of a
+// command substitution that spans span and nests within outer. A single
+// command becomes a CallNode covering the brackets.
+func parseCommandSubstitution(script string, start types.Pos, span types.Span, outer *nesting) (types.ASTNode, error) {
+	if err := outer.enter(types.Span{Start: span.Start, End: span.Start.Advance("[")}); err != nil {
+		return nil, err
+	}
+	defer outer.leave()
+
+	parsingContext := ParsingContext{tokens: withoutTrivia(lexer.TokenizeAt(script, start)), currentTokenIndex: 0, nesting: *outer}
+	lines, err := parseLines(&parsingContext)
+	if err != nil {
+		return nil, wrapParse("lines", err)
+	}
+
+	if len(lines.Lines) == 1 {
+		switch line := lines.Lines[0].(type) {
+		case *types.CallNode:
+			line.Span = span
+			return line, nil
+		case *types.SymbolNode:
+			return &types.CallNode{Span: span, FuncName: line.Name}, nil
+		}
+	}
+	return &types.ScriptNode{Span: span, Script: lines}, nil
+}
+
+// backslash decodes the backslash sequence at s[i] like Tcl and returns the
+// index just past it: \a, \b, \f, \n, \r, \t and \v, \ooo in octal, \xhh,
+// \uhhhh and \Uhhhhhhhh in hex, and a backslash-newline with the blanks
+// after it, which becomes a space. \u{hex} is read as in the Lisp dialect.
+// Any other character is taken literally.
+func backslash(s string, i int) (string, int) {
+	if i+1 >= len(s) {
+		return `\`, i + 1
+	}
+	switch ch := s[i+1]; ch {
+	case 'a':
+		return "\a", i + 2
+	case 'b':
+		return "\b", i + 2
+	case 'f':
+		return "\f", i + 2
+	case 'n':
+		return "\n", i + 2
+	case 'r':
+		return "\r", i + 2
+	case 't':
+		return "\t", i + 2
+	case 'v':
+		return "\v", i + 2
+	case '\n':
+		end := i + 2
+		for end < len(s) && (s[end] == ' ' || s[end] == '\t') {
+			end++
+		}
+		return " ", end
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		return codePoint(s, i+1, 3, 8)
+	case 'x':
+		return codePoint(s, i+2, 2, 16)
+	case 'u':
+		if strings.HasPrefix(s[i+2:], "{") {
+			if closing := strings.IndexByte(s[i+2:], '}'); closing > 0 {
+				if code, err := strconv.ParseUint(s[i+3:i+2+closing], 16, 32); err == nil && utf8.ValidRune(rune(code)) {
+					return string(rune(code)), i + 2 + closing + 1
+				}
+			}
+		}
+		return codePoint(s, i+2, 4, 16)
+	case 'U':
+		return codePoint(s, i+2, 8, 16)
+	default:
+		_, size := utf8.DecodeRuneInString(s[i+1:])
+		return s[i+1 : i+1+size], i + 1 + size
+	}
+}
+
+// codePoint reads up to maxDigits digits in base from s[start] as a code
+// point. Without any digits, the letter before start is taken literally,
+// like Tcl reads \x not followed by hex digits as x.
+func codePoint(s string, start, maxDigits, base int) (string, int) {
+	end := start
+	for end < len(s) && end-start < maxDigits && isDigitIn(s[end], base) {
+		end++
+	}
+	if end == start {
+		return s[start-1 : start], start
+	}
+	code, _ := strconv.ParseUint(s[start:end], base, 32)
+	return string(rune(code)), end
+}
+
+func isDigitIn(ch byte, base int) bool {
+	switch {
+	case '0' <= ch && ch <= '7':
+		return true
+	case ch == '8' || ch == '9':
+		return base >= 10
+	case 'a' <= ch && ch <= 'f', 'A' <= ch && ch <= 'F':
+		return base == 16
+	default:
+		return false
+	}
+}
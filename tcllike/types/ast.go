@@ -3,9 +3,12 @@ package types
 import (
 	"fmt"
 	"strings"
+
+	"simlang/util"
 )
 
 type ASTNode interface {
+	Spanned
 	astNode()
 	String() string
 }
@@ -19,26 +22,112 @@ func (ast *AST) String() string {
 }
 
 type LinesNode struct {
+	Span
 	Lines []ASTNode
 }
 
 type SymbolNode struct {
+	Span
 	Name string
 }
 
+// NumberNode is a number literal. Integer literals that fit in an int64
+// have IsInt set and their value in Int; Value holds every literal as a
+// float64, rounded if need be.
 type NumberNode struct {
+	Span
 	Value float64
+	Int   int64
+	IsInt bool
+}
+
+type StringNode struct {
+	Span
+	Value string
 }
 
 type CallNode struct {
+	Span
 	FuncName string
 	Args     []ASTNode
 }
 
-func (n *LinesNode) astNode()  {}
-func (n *SymbolNode) astNode() {}
-func (n *NumberNode) astNode() {}
-func (n *CallNode) astNode()   {}
+// BracedNode is a word in braces. Like in Tcl, Value is the text between the
+// braces, taken literally: nothing in it is substituted.
+type BracedNode struct {
+	Span
+	Value string
+}
+
+// ScriptNode is a command substitution, [script], of anything but a single
+// command, which is a CallNode. It evaluates to the result of the last
+// command of Script.
+type ScriptNode struct {
+	Span
+	Script *LinesNode
+}
+
+// ExpandNode is a word prefixed with {*}: the elements of the list Word
+// evaluates to become separate arguments.
+type ExpandNode struct {
+	Span
+	Word ASTNode
+}
+
+// BinaryNode is an operator applied to two operands in an expression.
+type BinaryNode struct {
+	Span
+	Op          string
+	Left, Right ASTNode
+}
+
+// UnaryNode is an operator applied to one operand in an expression.
+type UnaryNode struct {
+	Span
+	Op      string
+	Operand ASTNode
+}
+
+// TernaryNode is cond ? then : else in an expression.
+type TernaryNode struct {
+	Span
+	Cond, Then, Else ASTNode
+}
+
+// FuncNode is a call of a math function in an expression, like sqrt(2).
+type FuncNode struct {
+	Span
+	Name string
+	Args []ASTNode
+}
+
+// VarNode is a variable substitution, $name or ${name}.
+type VarNode struct {
+	Span
+	Name string
+}
+
+// WordNode is a word built from several parts, like file$i.txt: the values of
+// Parts are concatenated.
+type WordNode struct {
+	Span
+	Parts []ASTNode
+}
+
+func (n *LinesNode) astNode()   {}
+func (n *SymbolNode) astNode()  {}
+func (n *NumberNode) astNode()  {}
+func (n *StringNode) astNode()  {}
+func (n *CallNode) astNode()    {}
+func (n *BracedNode) astNode()  {}
+func (n *ScriptNode) astNode()  {}
+func (n *ExpandNode) astNode()  {}
+func (n *BinaryNode) astNode()  {}
+func (n *UnaryNode) astNode()   {}
+func (n *TernaryNode) astNode() {}
+func (n *FuncNode) astNode()    {}
+func (n *VarNode) astNode()     {}
+func (n *WordNode) astNode()    {}
 
 func (n *LinesNode) String() string {
 	lines := make([]string, len(n.Lines))
@@ -53,9 +142,16 @@ func (n *SymbolNode) String() string {
 }
 
 func (n *NumberNode) String() string {
+	if n.IsInt {
+		return fmt.Sprintf("%d", n.Int)
+	}
 	return fmt.Sprintf("%f", n.Value)
 }
 
+func (n *StringNode) String() string {
+	return util.Quote(n.Value)
+}
+
 func (n *CallNode) String() string {
 	args := make([]string, len(n.Args))
 	for i, arg := range n.Args {
@@ -63,3 +159,51 @@ func (n *CallNode) String() string {
 	}
 	return fmt.Sprintf("%s(%s)", n.FuncName, strings.Join(args, ", "))
 }
+
+func (n *BracedNode) String() string {
+	return "{" + n.Value + "}"
+}
+
+func (n *ScriptNode) String() string {
+	lines := make([]string, len(n.Script.Lines))
+	for i, line := range n.Script.Lines {
+		lines[i] = line.String()
+	}
+	return fmt.Sprintf("Script(%s)", strings.Join(lines, "; "))
+}
+
+func (n *ExpandNode) String() string {
+	return "{*}" + n.Word.String()
+}
+
+func (n *BinaryNode) String() string {
+	return fmt.Sprintf("(%s %s %s)", n.Left, n.Op, n.Right)
+}
+
+func (n *UnaryNode) String() string {
+	return fmt.Sprintf("(%s%s)", n.Op, n.Operand)
+}
+
+func (n *TernaryNode) String() string {
+	return fmt.Sprintf("(%s ? %s : %s)", n.Cond, n.Then, n.Else)
+}
+
+func (n *FuncNode) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+}
+
+func (n *VarNode) String() string {
+	return "$" + n.Name
+}
+
+func (n *WordNode) String() string {
+	parts := make([]string, len(n.Parts))
+	for i, part := range n.Parts {
+		parts[i] = part.String()
+	}
+	return fmt.Sprintf("Word(%s)", strings.Join(parts, ", "))
+}
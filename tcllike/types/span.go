@@ -0,0 +1,11 @@
+package types
+
+import "simlang/types"
+
+// The tcllike dialect shares source positions with the Lisp dialect so both
+// front ends report errors through the same diagnostic printer.
+type (
+	Pos     = types.Pos
+	Span    = types.Span
+	Spanned = types.Spanned
+)
@@ -4,18 +4,27 @@ package types
 type Token struct {
 	Type  TokenType
 	Value string
+	Span
 }
 
 type TokenType int
 
+// IsTrivia reports whether tokens of this type carry no meaning for the
+// parser. The lexer keeps them so tools like a formatter can see them.
+func (t TokenType) IsTrivia() bool {
+	return t == Comment
+}
+
 const (
-	Atom TokenType = iota
+	Atom TokenType = iota // raw bare word, substituted by the parser
 	Number
-	LParen
-	RParen
-	LineEnd
-	LBracket
-	RBracket
+	String  // raw "..." word, substituted by the parser
+	Expr    // raw (...) expression, parsed by parser.ParseExpr
+	LineEnd // newline or ;
+	Comment // # comment in command position
+	Braced  // raw {...} word, kept without substitution
+	Expand  // {*} before a word
+	EOF     // end of input, never produced by the lexer
 )
 
 func (t TokenType) String() string {
@@ -24,16 +33,20 @@ func (t TokenType) String() string {
 		return "ATOM"
 	case Number:
 		return "NUMBER"
-	case LParen:
-		return "LPAREN"
-	case RParen:
-		return "RPAREN"
+	case String:
+		return "STRING"
+	case Expr:
+		return "EXPR"
 	case LineEnd:
 		return "LineEnd"
-	case LBracket:
-		return "LBracket"
-	case RBracket:
-		return "RBracket"
+	case Comment:
+		return "Comment"
+	case Braced:
+		return "Braced"
+	case Expand:
+		return "Expand"
+	case EOF:
+		return "EOF"
 	default:
 		return "UNKNOWN"
 	}
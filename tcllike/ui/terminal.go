@@ -3,7 +3,10 @@ package ui
 import (
 	"fmt"
 	"strings"
+
 	"github.com/charmbracelet/lipgloss"
+
+	"simlang/util"
 )
 
 var (
@@ -20,8 +23,13 @@ func PrintResult(result string) {
 	fmt.Println(resultStyle.Render("=> " + result))
 }
 
-func PrintError(err string) {
-	fmt.Println(errorStyle.Render("✗ " + err))
+// PrintError prints err and, when it carries a source position, the
+// offending line of source with a caret underline.
+func PrintError(source string, err error) {
+	fmt.Println(errorStyle.Render("✗ " + err.Error()))
+	if excerpt := util.Diagnostic(source, err); excerpt != "" {
+		fmt.Println(errorStyle.Render(excerpt))
+	}
 }
 
 func PrintWelcome() {
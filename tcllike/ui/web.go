@@ -1,18 +1,62 @@
 package ui
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sync"
+	"time"
 
 	"simlang/tcllike/evaluator"
 	"simlang/tcllike/lexer"
 	"simlang/tcllike/parser"
+	"simlang/tcllike/types"
+	"simlang/util"
 )
 
+const (
+	interpCookieName = "tcllike-session"
+	// interpreters nobody used for this long are dropped
+	interpIdleTimeout = 30 * time.Minute
+	// at most this many interpreters are kept, so clients that drop the
+	// cookie cannot grow the map without bound
+	maxInterps = 1000
+	// requests carrying more code than this are refused before parsing
+	maxRequestBytes = 1 << 20
+)
+
+// DefaultEvalTimeout is how long the web REPL lets one evaluation run.
+const DefaultEvalTimeout = 5 * time.Second
+
+// DefaultWebLimits keeps one request from using up the server.
+var DefaultWebLimits = evaluator.Limits{
+	MaxSteps:      50_000_000,
+	MaxDepth:      10_000,
+	MaxAllocBytes: 256 << 20,
+}
+
 type WebUI struct {
 	tmpl *template.Template
+
+	// Timeout bounds each evaluation on top of the request's own context,
+	// and Limits its steps, depth and allocation.
+	Timeout time.Duration
+	Limits  evaluator.Limits
+
+	mu      sync.Mutex
+	interps map[string]*webInterp
+}
+
+// webInterp is the interpreter of one browser, identified by a cookie, so
+// variables set by one request are visible to the next.
+type webInterp struct {
+	interp   *evaluator.Interp
+	lastUsed time.Time
 }
 
 func NewWebUI() *WebUI {
@@ -28,7 +72,7 @@ func NewWebUI() *WebUI {
 			button { padding: 5px 15px; }
 			.prompt { color: #0099cc; font-weight: bold; }
 			.result { color: #00cc99; }
-			.error { color: #ff3333; font-weight: bold; }
+			.error { color: #ff3333; font-weight: bold; white-space: pre-wrap; }
 		</style>
 	</head>
 	<body>
@@ -65,6 +109,9 @@ func NewWebUI() *WebUI {
 				if (result.error) {
 					output.className = 'error';
 					output.textContent = '✗ ' + result.error;
+					if (result.excerpt) {
+						output.textContent += '\n' + result.excerpt;
+					}
 				} else {
 					output.className = 'result';
 					let outputText = '=> ' + result.output;
@@ -81,7 +128,47 @@ func NewWebUI() *WebUI {
 	</body>
 	</html>
 	`))
-	return &WebUI{tmpl: tmpl}
+	return &WebUI{tmpl: tmpl, Timeout: DefaultEvalTimeout, Limits: DefaultWebLimits, interps: make(map[string]*webInterp)}
+}
+
+// interpFor returns the interpreter of the client sending req, creating one
+// (and its cookie) for new clients.
+func (w *WebUI) interpFor(res http.ResponseWriter, req *http.Request) *evaluator.Interp {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if cookie, err := req.Cookie(interpCookieName); err == nil {
+		if existing, ok := w.interps[cookie.Value]; ok {
+			existing.lastUsed = now
+			return existing.interp
+		}
+	}
+
+	var oldestID string
+	for id, existing := range w.interps {
+		if now.Sub(existing.lastUsed) > interpIdleTimeout {
+			delete(w.interps, id)
+		} else if oldestID == "" || existing.lastUsed.Before(w.interps[oldestID].lastUsed) {
+			oldestID = id
+		}
+	}
+	if len(w.interps) >= maxInterps {
+		// make room by dropping the least recently used one
+		delete(w.interps, oldestID)
+	}
+
+	id := newInterpID()
+	created := &webInterp{interp: evaluator.NewInterp(), lastUsed: now}
+	w.interps[id] = created
+	http.SetCookie(res, &http.Cookie{Name: interpCookieName, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	return created.interp
+}
+
+func newInterpID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
 
 func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
@@ -92,25 +179,29 @@ func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		var data struct {
 			Code string `json:"code"`
 		}
-		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+		if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxRequestBytes)).Decode(&data); err != nil {
+			status := http.StatusBadRequest
+			if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(res, err.Error(), status)
 			return
 		}
 
-		ast, err := parser.Parse(lexer.Tokenize(data.Code))
+		ctx, cancel := context.WithTimeout(req.Context(), w.Timeout)
+		defer cancel()
+		tokens, ast, err := parseWithin(ctx, data.Code)
 		if err != nil {
-			json.NewEncoder(res).Encode(map[string]string{"error": err.Error()})
+			encodeError(res, data.Code, err)
 			return
 		}
-
-		result, err := evaluator.Eval(ast)
+		result, err := w.interpFor(res, req).EvalWithOptions(ctx, ast, w.Limits)
 		if err != nil {
-			json.NewEncoder(res).Encode(map[string]string{"error": err.Error()})
+			encodeError(res, data.Code, err)
 			return
 		}
 
 		// 토큰화 결과 생성
-		tokens := lexer.Tokenize(data.Code)
 		tokenStrs := make([]string, len(tokens))
 		for i, token := range tokens {
 			tokenStrs[i] = fmt.Sprintf("%s: %q", token.Type, token.Value)
@@ -118,7 +209,7 @@ func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 
 		// 응답 데이터 구조 확장
 		response := map[string]interface{}{
-			"output": fmt.Sprintf("%v", result),
+			"output": evaluator.Repr(result),
 			"tokens": tokenStrs,
 			"ast":    ast.String(),
 		}
@@ -127,3 +218,37 @@ func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		http.NotFound(res, req)
 	}
 }
+
+// parseWithin lexes and parses code, giving up when ctx is done first. The
+// parser cannot be interrupted, so it still runs to the end in the
+// background, but maxRequestBytes keeps that short.
+func parseWithin(ctx context.Context, code string) ([]types.Token, *types.AST, error) {
+	type parsed struct {
+		tokens []types.Token
+		ast    *types.AST
+		err    error
+	}
+	done := make(chan parsed, 1)
+	go func() {
+		tokens := lexer.Tokenize(code)
+		ast, err := parser.Parse(tokens)
+		done <- parsed{tokens, ast, err}
+	}()
+	select {
+	case result := <-done:
+		return result.tokens, result.ast, result.err
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("failed to parse: %w", ctx.Err())
+	}
+}
+
+// encodeError writes err as JSON. Errors with a source position also get the
+// position and a caret excerpt of the offending line.
+func encodeError(res http.ResponseWriter, source string, err error) {
+	response := map[string]string{"error": err.Error()}
+	if span, ok := util.ErrorSpan(err); ok {
+		response["position"] = span.Start.String()
+		response["excerpt"] = util.Diagnostic(source, err)
+	}
+	json.NewEncoder(res).Encode(response)
+}
@@ -2,10 +2,12 @@ package types
 
 import (
 	"fmt"
+	"math/big"
 	"strings"
 )
 
 type ASTNode interface {
+	Spanned
 	astNode()
 	String() string
 }
@@ -14,38 +16,202 @@ type AST struct {
 	Root ASTNode
 }
 
+// Program is a whole source file: top-level forms in source order.
+type Program struct {
+	Forms []ASTNode
+}
+
+// NumberNode is a number literal. Integer and ratio literals are exact and
+// keep their full value in Exact; Value is the nearest float64 either way.
 type NumberNode struct {
+	Span
 	Value float64
+	Exact *big.Rat
+}
+
+type BoolNode struct {
+	Span
+	Value bool
+}
+
+type StringNode struct {
+	Span
+	Value string
 }
 
 type SymbolNode struct {
+	Span
 	Name string
 }
 
 type CallNode struct {
+	Span
 	Function ASTNode
 	Args     []ASTNode
 }
 
+// LetNode is a let, let* or letrec expression. Bindings keep their source
+// order, which let* and letrec rely on.
 type LetNode struct {
-	LetEnv map[string]ASTNode
-	Body   ASTNode
+	Span
+	Kind     TokenType // LET, LETSTAR or LETREC
+	Bindings []LetBinding
+	Body     ASTNode
+}
+
+type LetBinding struct {
+	Name  *SymbolNode
+	Value ASTNode
+}
+
+// IfNode is (if cond then else). Else is nil when the else branch is omitted.
+type IfNode struct {
+	Span
+	Cond ASTNode
+	Then ASTNode
+	Else ASTNode
+}
+
+// CondNode is (cond (test body)... (else body)). Else is nil without an else clause.
+type CondNode struct {
+	Span
+	Clauses []CondClause
+	Else    ASTNode
+}
+
+type CondClause struct {
+	Test ASTNode
+	Body ASTNode
+}
+
+// LogicalNode is a short-circuiting (and ...) or (or ...).
+type LogicalNode struct {
+	Span
+	Op   TokenType // AND or OR
+	Args []ASTNode
+}
+
+// DefineNode binds Name in the environment it is evaluated in. The
+// (define (f args...) body) shorthand is parsed into a LambdaNode value.
+type DefineNode struct {
+	Span
+	Name  *SymbolNode
+	Value ASTNode
+}
+
+// SetNode is (set! name value). It changes the binding of Name, which must
+// already exist, instead of making a new one like DefineNode.
+type SetNode struct {
+	Span
+	Name  *SymbolNode
+	Value ASTNode
+}
+
+// BeginNode is (begin body...). It evaluates Body in order and gives the
+// value of the last expression, or none if Body is empty.
+type BeginNode struct {
+	Span
+	Body []ASTNode
 }
 
 type LambdaNode struct {
+	Span
 	Args []*SymbolNode
 	Body ASTNode
 }
 
-func (n *NumberNode) astNode() {}
-func (n *SymbolNode) astNode() {}
-func (n *CallNode) astNode()   {}
-func (n *LetNode) astNode()    {}
-func (n *LambdaNode) astNode() {}
+// QuoteNode is (quote datum) or 'datum. Datum is not code: it is built from
+// SymbolNode, NumberNode, StringNode, BoolNode and ListNode only.
+type QuoteNode struct {
+	Span
+	Datum ASTNode
+}
+
+// ListNode is a parenthesized list inside a quoted datum. Tail is the datum
+// after the dot of an improper list like (1 . 2), and nil otherwise.
+type ListNode struct {
+	Span
+	Elements []ASTNode
+	Tail     ASTNode
+}
+
+// QuasiquoteNode is (quasiquote template) or `template. Template is a datum
+// like the one of QuoteNode, except that it may hold UnquoteNodes.
+type QuasiquoteNode struct {
+	Span
+	Template ASTNode
+}
+
+// UnquoteNode is ,expr or ,@expr inside a quasiquote template. Expr is code.
+// With Splicing, Expr must evaluate to a list whose elements are spliced into
+// the surrounding list.
+type UnquoteNode struct {
+	Span
+	Expr     ASTNode
+	Splicing bool
+}
+
+// DefmacroNode is (defmacro name (params...) body). Body computes, from the
+// unevaluated arguments of a macro use, the code that replaces it.
+type DefmacroNode struct {
+	Span
+	Name   *SymbolNode
+	Params []*SymbolNode
+	Body   ASTNode
+}
+
+// DefineSyntaxNode is
+// (define-syntax name (syntax-rules (literals...) (pattern template)...)).
+// A macro use is replaced by the template of the first rule whose pattern
+// matches it; Literals are the symbols that patterns match literally.
+type DefineSyntaxNode struct {
+	Span
+	Name     *SymbolNode
+	Literals []*SymbolNode
+	Rules    []SyntaxRule
+}
+
+// SyntaxRule is one (pattern template) of syntax-rules. Both are data, like
+// the Datum of QuoteNode.
+type SyntaxRule struct {
+	Pattern  ASTNode
+	Template ASTNode
+}
+
+func (n *NumberNode) astNode()       {}
+func (n *BoolNode) astNode()         {}
+func (n *StringNode) astNode()       {}
+func (n *SymbolNode) astNode()       {}
+func (n *CallNode) astNode()         {}
+func (n *LetNode) astNode()          {}
+func (n *IfNode) astNode()           {}
+func (n *CondNode) astNode()         {}
+func (n *LogicalNode) astNode()      {}
+func (n *DefineNode) astNode()       {}
+func (n *SetNode) astNode()          {}
+func (n *BeginNode) astNode()        {}
+func (n *LambdaNode) astNode()       {}
+func (n *QuoteNode) astNode()        {}
+func (n *ListNode) astNode()         {}
+func (n *QuasiquoteNode) astNode()   {}
+func (n *UnquoteNode) astNode()      {}
+func (n *DefmacroNode) astNode()     {}
+func (n *DefineSyntaxNode) astNode() {}
 
 func (n *NumberNode) String() string {
+	if n.Exact != nil {
+		return fmt.Sprintf("Number(%s)", n.Exact.RatString())
+	}
 	return fmt.Sprintf("Number(%f)", n.Value)
 }
+func (n *BoolNode) String() string {
+	return fmt.Sprintf("Bool(%t)", n.Value)
+}
+
+func (n *StringNode) String() string {
+	return fmt.Sprintf("String(%q)", n.Value)
+}
+
 func (n *SymbolNode) String() string {
 	return fmt.Sprintf("Symbol(%s)", n.Name)
 }
@@ -67,9 +233,108 @@ func (n *CallNode) Push(arg ASTNode) {
 }
 
 func (n *LetNode) String() string {
-	return fmt.Sprintf("Let(%s, %s)", n.LetEnv, n.Body.String())
+	bindings := make([]string, len(n.Bindings))
+	for i, binding := range n.Bindings {
+		bindings[i] = fmt.Sprintf("%s=%s", binding.Name.Name, binding.Value)
+	}
+	return fmt.Sprintf("%s([%s], %s)", n.kindName(), strings.Join(bindings, ", "), n.Body.String())
+}
+
+func (n *LetNode) kindName() string {
+	switch n.Kind {
+	case LETSTAR:
+		return "LetStar"
+	case LETREC:
+		return "LetRec"
+	default:
+		return "Let"
+	}
+}
+
+func (n *IfNode) String() string {
+	if n.Else == nil {
+		return fmt.Sprintf("If(%s, %s)", n.Cond, n.Then)
+	}
+	return fmt.Sprintf("If(%s, %s, %s)", n.Cond, n.Then, n.Else)
+}
+
+func (n *CondNode) String() string {
+	clauses := make([]string, 0, len(n.Clauses)+1)
+	for _, clause := range n.Clauses {
+		clauses = append(clauses, fmt.Sprintf("%s => %s", clause.Test, clause.Body))
+	}
+	if n.Else != nil {
+		clauses = append(clauses, fmt.Sprintf("else => %s", n.Else))
+	}
+	return fmt.Sprintf("Cond(%s)", strings.Join(clauses, ", "))
+}
+
+func (n *LogicalNode) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	name := "And"
+	if n.Op == OR {
+		name = "Or"
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+func (n *DefineNode) String() string {
+	return fmt.Sprintf("Define(%s, %s)", n.Name.Name, n.Value)
+}
+
+func (n *SetNode) String() string {
+	return fmt.Sprintf("Set(%s, %s)", n.Name.Name, n.Value)
+}
+
+func (n *BeginNode) String() string {
+	body := make([]string, len(n.Body))
+	for i, expr := range n.Body {
+		body[i] = expr.String()
+	}
+	return fmt.Sprintf("Begin(%s)", strings.Join(body, ", "))
 }
 
 func (n *LambdaNode) String() string {
 	return fmt.Sprintf("Lambda(%s, %s)", n.Args, n.Body.String())
 }
+
+func (n *QuoteNode) String() string {
+	return fmt.Sprintf("Quote(%s)", n.Datum)
+}
+
+func (n *ListNode) String() string {
+	elements := make([]string, len(n.Elements))
+	for i, element := range n.Elements {
+		elements[i] = element.String()
+	}
+	if n.Tail != nil {
+		return fmt.Sprintf("List(%s . %s)", strings.Join(elements, ", "), n.Tail)
+	}
+	return fmt.Sprintf("List(%s)", strings.Join(elements, ", "))
+}
+
+func (n *QuasiquoteNode) String() string {
+	return fmt.Sprintf("Quasiquote(%s)", n.Template)
+}
+
+func (n *UnquoteNode) String() string {
+	if n.Splicing {
+		return fmt.Sprintf("UnquoteSplicing(%s)", n.Expr)
+	}
+	return fmt.Sprintf("Unquote(%s)", n.Expr)
+}
+
+func (n *DefmacroNode) String() string {
+	return fmt.Sprintf("Defmacro(%s, %s, %s)", n.Name.Name, n.Params, n.Body)
+}
+
+func (n *DefineSyntaxNode) String() string {
+	rules := make([]string, len(n.Rules))
+	for i, rule := range n.Rules {
+		rules[i] = fmt.Sprintf("%s => %s", rule.Pattern, rule.Template)
+	}
+	return fmt.Sprintf("DefineSyntax(%s, %s, %s)", n.Name.Name, n.Literals, strings.Join(rules, ", "))
+}
@@ -0,0 +1,50 @@
+package types
+
+import "fmt"
+
+// Pos is a location in the source text. Line and Column are 1-based (Column
+// counts runes), Offset is the 0-based byte offset.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Advance returns the position reached after reading text starting at p.
+func (p Pos) Advance(text string) Pos {
+	for _, ch := range text {
+		if ch == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+	p.Offset += len(text)
+	return p
+}
+
+// Span is the half-open source range [Start, End) covered by a token or node.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+func (s Span) SourceSpan() Span {
+	return s
+}
+
+// Spanned is implemented by tokens, AST nodes and errors that know where in
+// the source they come from.
+type Spanned interface {
+	SourceSpan() Span
+}
+
+// To returns the span that starts at s and ends where other ends.
+func (s Span) To(other Span) Span {
+	return Span{Start: s.Start, End: other.End}
+}
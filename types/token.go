@@ -4,18 +4,46 @@ package types
 type Token struct {
 	Type  TokenType
 	Value string
+	Span
 }
 
 type TokenType int
 
+// IsTrivia reports whether tokens of this type carry no meaning for the
+// parser. The lexer keeps them so tools like a formatter can see them.
+func (t TokenType) IsTrivia() bool {
+	return t == COMMENT
+}
+
 const (
 	LPAREN TokenType = iota
 	RPAREN
 	ATOM
 	NUMBER
+	BOOLEAN // #t or #f
+	STRING  // raw "..." literal, escapes are decoded by the parser
 	LET
+	LETSTAR // let*
+	LETREC
 	IN // let in
 	LAMBDA
+	DEFINE
+	IF
+	COND
+	AND
+	OR
+	COMMENT // ; line comment, #| block comment |# or #! shebang line
+	QUOTE   // ' before a datum, short for (quote datum)
+	// ` , and ,@ before a datum, short for (quasiquote datum), (unquote
+	// datum) and (unquote-splicing datum)
+	QUASIQUOTE
+	UNQUOTE
+	UNQUOTE_SPLICING
+	DEFMACRO
+	DEFINE_SYNTAX
+	SET // set!
+	BEGIN
+	EOF // end of input, never produced by the lexer
 )
 
 func (t TokenType) String() string {
@@ -28,12 +56,50 @@ func (t TokenType) String() string {
 		return "ATOM"
 	case NUMBER:
 		return "NUMBER"
+	case BOOLEAN:
+		return "BOOLEAN"
+	case STRING:
+		return "STRING"
 	case LET:
 		return "LET"
+	case LETSTAR:
+		return "LETSTAR"
+	case LETREC:
+		return "LETREC"
 	case IN:
 		return "IN"
 	case LAMBDA:
 		return "LAMBDA"
+	case DEFINE:
+		return "DEFINE"
+	case IF:
+		return "IF"
+	case COND:
+		return "COND"
+	case AND:
+		return "AND"
+	case OR:
+		return "OR"
+	case COMMENT:
+		return "COMMENT"
+	case QUOTE:
+		return "QUOTE"
+	case QUASIQUOTE:
+		return "QUASIQUOTE"
+	case UNQUOTE:
+		return "UNQUOTE"
+	case UNQUOTE_SPLICING:
+		return "UNQUOTE_SPLICING"
+	case DEFMACRO:
+		return "DEFMACRO"
+	case DEFINE_SYNTAX:
+		return "DEFINE_SYNTAX"
+	case SET:
+		return "SET"
+	case BEGIN:
+		return "BEGIN"
+	case EOF:
+		return "EOF"
 	default:
 		return "UNKNOWN"
 	}
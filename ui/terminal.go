@@ -2,8 +2,13 @@ package ui
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
+
 	"github.com/charmbracelet/lipgloss"
+
+	"simlang/util"
 )
 
 var (
@@ -20,8 +25,18 @@ func PrintResult(result string) {
 	fmt.Println(resultStyle.Render("=> " + result))
 }
 
-func PrintError(err string) {
-	fmt.Println(errorStyle.Render("✗ " + err))
+// PrintError prints err and, when it carries a source position, the
+// offending line of source with a caret underline.
+func PrintError(source string, err error) {
+	FprintError(os.Stdout, source, err)
+}
+
+// FprintError is PrintError writing to w.
+func FprintError(w io.Writer, source string, err error) {
+	fmt.Fprintln(w, errorStyle.Render("✗ "+err.Error()))
+	if excerpt := util.Diagnostic(source, err); excerpt != "" {
+		fmt.Fprintln(w, errorStyle.Render(excerpt))
+	}
 }
 
 func PrintWelcome() {
@@ -35,5 +50,6 @@ func PrintWelcome() {
 `
 	fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("99")).Render(welcome))
 	fmt.Println("간단한 Lisp 인터프리터 (종료하려면 exit 입력)")
+	fmt.Println(":expand <식> 으로 매크로 전개 결과를 볼 수 있습니다")
 	fmt.Println(strings.Repeat("=", 40))
 }
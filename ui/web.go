@@ -1,17 +1,61 @@
 package ui
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"sync"
+	"time"
+
 	"simlang/evaluator"
 	"simlang/lexer"
 	"simlang/parser"
+	"simlang/types"
+	"simlang/util"
+)
+
+const (
+	sessionCookieName = "simlang-session"
+	// sessions nobody used for this long are dropped
+	sessionIdleTimeout = 30 * time.Minute
+	// at most this many sessions are kept, so clients that drop the
+	// cookie cannot grow the map without bound
+	maxSessions = 1000
+	// requests carrying more code than this are refused before parsing
+	maxRequestBytes = 1 << 20
 )
 
+// DefaultEvalTimeout is how long the web REPL lets one evaluation run.
+const DefaultEvalTimeout = 5 * time.Second
+
+// DefaultWebLimits keeps one request from using up the server.
+var DefaultWebLimits = evaluator.Limits{
+	MaxSteps:      50_000_000,
+	MaxDepth:      10_000,
+	MaxAllocBytes: 256 << 20,
+}
+
 type WebUI struct {
 	tmpl *template.Template
+
+	// Timeout bounds each evaluation on top of the request's own context,
+	// and Limits its steps, depth and allocation.
+	Timeout time.Duration
+	Limits  evaluator.Limits
+
+	mu       sync.Mutex
+	sessions map[string]*webSession
+}
+
+// webSession is the evaluator session of one browser, identified by a cookie.
+type webSession struct {
+	session  *evaluator.Session
+	lastUsed time.Time
 }
 
 func NewWebUI() *WebUI {
@@ -27,7 +71,7 @@ func NewWebUI() *WebUI {
 			button { padding: 5px 15px; }
 			.prompt { color: #0099cc; font-weight: bold; }
 			.result { color: #00cc99; }
-			.error { color: #ff3333; font-weight: bold; }
+			.error { color: #ff3333; font-weight: bold; white-space: pre-wrap; }
 		</style>
 	</head>
 	<body>
@@ -66,9 +110,12 @@ func NewWebUI() *WebUI {
 				if (result.error) {
 					output.className = 'error';
 					output.textContent = '✗ ' + result.error;
+					if (result.excerpt) {
+						output.textContent += '\n' + result.excerpt;
+					}
 				} else {
 					output.className = 'result';
-					output.textContent = '=> ' + result.output;
+					output.textContent = result.output ? '=> ' + result.output : '';
 				}
 				
 				repl.appendChild(output);
@@ -79,7 +126,47 @@ func NewWebUI() *WebUI {
 	</body>
 	</html>
 	`))
-	return &WebUI{tmpl: tmpl}
+	return &WebUI{tmpl: tmpl, Timeout: DefaultEvalTimeout, Limits: DefaultWebLimits, sessions: make(map[string]*webSession)}
+}
+
+// sessionFor returns the session of the client sending req, creating one (and
+// its cookie) for new clients.
+func (w *WebUI) sessionFor(res http.ResponseWriter, req *http.Request) *evaluator.Session {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if cookie, err := req.Cookie(sessionCookieName); err == nil {
+		if existing, ok := w.sessions[cookie.Value]; ok {
+			existing.lastUsed = now
+			return existing.session
+		}
+	}
+
+	var oldestID string
+	for id, existing := range w.sessions {
+		if now.Sub(existing.lastUsed) > sessionIdleTimeout {
+			delete(w.sessions, id)
+		} else if oldestID == "" || existing.lastUsed.Before(w.sessions[oldestID].lastUsed) {
+			oldestID = id
+		}
+	}
+	if len(w.sessions) >= maxSessions {
+		// make room by dropping the least recently used one
+		delete(w.sessions, oldestID)
+	}
+
+	id := newSessionID()
+	created := &webSession{session: evaluator.NewSession(), lastUsed: now}
+	w.sessions[id] = created
+	http.SetCookie(res, &http.Cookie{Name: sessionCookieName, Value: id, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	return created.session
+}
+
+func newSessionID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
 }
 
 func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
@@ -90,25 +177,66 @@ func (w *WebUI) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		var data struct {
 			Code string `json:"code"`
 		}
-		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
-			http.Error(res, err.Error(), http.StatusBadRequest)
+		if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxRequestBytes)).Decode(&data); err != nil {
+			status := http.StatusBadRequest
+			if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(res, err.Error(), status)
 			return
 		}
 
-		ast, err := parser.Parse(lexer.Toknize(data.Code))
+		ctx, cancel := context.WithTimeout(req.Context(), w.Timeout)
+		defer cancel()
+		ast, err := parseWithin(ctx, data.Code)
 		if err != nil {
-			json.NewEncoder(res).Encode(map[string]string{"error": err.Error()})
+			encodeError(res, data.Code, err)
 			return
 		}
-
-		result, err := evaluator.Eval(ast)
+		result, err := w.sessionFor(res, req).EvalWithOptions(ctx, ast, w.Limits)
 		if err != nil {
-			json.NewEncoder(res).Encode(map[string]string{"error": err.Error()})
+			encodeError(res, data.Code, err)
 			return
 		}
 
-		json.NewEncoder(res).Encode(map[string]string{"output": fmt.Sprintf("%v", result)})
+		output := ""
+		if _, ok := result.(evaluator.Void); !ok {
+			output = result.String()
+		}
+		json.NewEncoder(res).Encode(map[string]string{"output": output})
 	default:
 		http.NotFound(res, req)
 	}
 }
+
+// parseWithin parses code, giving up when ctx is done first. The parser
+// cannot be interrupted, so it still runs to the end in the background, but
+// maxRequestBytes keeps that short.
+func parseWithin(ctx context.Context, code string) (*types.AST, error) {
+	type parsed struct {
+		ast *types.AST
+		err error
+	}
+	done := make(chan parsed, 1)
+	go func() {
+		ast, err := parser.Parse(lexer.Toknize(code))
+		done <- parsed{ast, err}
+	}()
+	select {
+	case result := <-done:
+		return result.ast, result.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to parse: %w", ctx.Err())
+	}
+}
+
+// encodeError writes err as JSON. Errors with a source position also get the
+// position and a caret excerpt of the offending line.
+func encodeError(res http.ResponseWriter, source string, err error) {
+	response := map[string]string{"error": err.Error()}
+	if span, ok := util.ErrorSpan(err); ok {
+		response["position"] = span.Start.String()
+		response["excerpt"] = util.Diagnostic(source, err)
+	}
+	json.NewEncoder(res).Encode(response)
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"simlang/types"
+)
+
+// ErrorSpan finds the source span attached to err, if any.
+func ErrorSpan(err error) (types.Span, bool) {
+	var spanned types.Spanned
+	if !errors.As(err, &spanned) {
+		return types.Span{}, false
+	}
+	return spanned.SourceSpan(), true
+}
+
+// Diagnostic renders the source line err points at with a caret underline,
+// or returns "" when err carries no position.
+func Diagnostic(source string, err error) string {
+	span, ok := ErrorSpan(err)
+	if !ok {
+		return ""
+	}
+
+	width := 1
+	if span.End.Line != span.Start.Line {
+		// only underline up to the end of the first line
+		width = len(source)
+	} else if 0 <= span.Start.Offset && span.Start.Offset <= span.End.Offset && span.End.Offset <= len(source) {
+		width = utf8.RuneCountInString(source[span.Start.Offset:span.End.Offset])
+	}
+	return SourceExcerpt(source, span.Start.Line, span.Start.Column, width)
+}
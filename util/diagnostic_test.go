@@ -0,0 +1,25 @@
+package util
+
+import (
+	"testing"
+
+	"simlang/types"
+)
+
+type spannedError struct{ span types.Span }
+
+func (e spannedError) Error() string          { return "bad" }
+func (e spannedError) SourceSpan() types.Span { return e.span }
+
+// TestDiagnosticCountsRunes checks that the caret starts under the right
+// character and is as wide as the span when the line holds non-ASCII text.
+func TestDiagnosticCountsRunes(t *testing.T) {
+	source := `(f "ü→" wörd)`
+	start := types.Pos{Offset: 0, Line: 1, Column: 1}.Advance(`(f "ü→" `)
+	end := start.Advance("wörd")
+	got := Diagnostic(source, spannedError{types.Span{Start: start, End: end}})
+	want := "1 | (f \"ü→\" wörd)\n  |         ^^^^"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
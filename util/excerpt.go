@@ -0,0 +1,45 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceExcerpt renders the given 1-based source line with a caret underline
+// of width runes starting at column, e.g.
+//
+//	1 | (+ 1 x)
+//	  |      ^
+func SourceExcerpt(source string, line, column, width int) string {
+	lines := strings.Split(source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	text := []rune(strings.TrimRight(lines[line-1], "\r"))
+
+	if column < 1 {
+		column = 1
+	}
+	if column > len(text)+1 {
+		column = len(text) + 1
+	}
+	if width < 1 {
+		width = 1
+	}
+	if column+width-1 > len(text) && column <= len(text) {
+		width = len(text) - column + 1
+	}
+
+	// tabs keep their width so the caret lines up with the source above it
+	var padding strings.Builder
+	for _, ch := range text[:column-1] {
+		if ch == '\t' {
+			padding.WriteByte('\t')
+		} else {
+			padding.WriteByte(' ')
+		}
+	}
+
+	gutter := fmt.Sprintf("%d", line)
+	return fmt.Sprintf("%s | %s\n%s | %s%s", gutter, string(text), strings.Repeat(" ", len(gutter)), padding.String(), strings.Repeat("^", width))
+}
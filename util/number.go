@@ -0,0 +1,192 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// NumberError reports a malformed number literal. Offset is the byte offset
+// of the problem within the literal.
+type NumberError struct {
+	Offset   int
+	Expected string
+	Found    string
+}
+
+func (e *NumberError) Error() string {
+	return fmt.Sprintf("expected %s but found %s", e.Expected, e.Found)
+}
+
+// LooksLikeNumber reports whether a word should be read as a number literal:
+// an optional sign, an optional '.', then a digit. Words like 1.2.3 look like
+// numbers and are then rejected by ParseNumber with a precise error.
+func LooksLikeNumber(s string) bool {
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+	}
+	return i < len(s) && isDecimalDigit(s[i])
+}
+
+// NumberLiteral is the value of a number literal. Integer and ratio literals
+// are exact and also have Exact set; Float is always set, possibly rounded.
+type NumberLiteral struct {
+	Exact *big.Rat
+	Float float64
+}
+
+// IsExact reports whether the literal was an integer or ratio literal.
+func (n NumberLiteral) IsExact() bool {
+	return n.Exact != nil
+}
+
+// ParseNumber parses a number literal:
+//
+//	number   = [sign] (hex | ratio | decimal)
+//	hex      = "0" ("x" | "X") hexdigits
+//	ratio    = digits "/" digits
+//	decimal  = digits ["." [digits]] [exponent] | "." digits [exponent]
+//	exponent = ("e" | "E") [sign] digits
+//
+// where digits may be separated by single underscores, e.g. 1_000 or 0xff_ff.
+func ParseNumber(literal string) (NumberLiteral, *NumberError) {
+	i := 0
+	negative := false
+	if i < len(literal) && (literal[i] == '+' || literal[i] == '-') {
+		negative = literal[i] == '-'
+		i++
+	}
+
+	if strings.HasPrefix(literal[i:], "0x") || strings.HasPrefix(literal[i:], "0X") {
+		end, err := scanDigits(literal, i+2, isHexDigit, "hex digits")
+		if err != nil {
+			return NumberLiteral{}, err
+		}
+		if end != len(literal) {
+			return NumberLiteral{}, unexpectedInNumber(literal, end)
+		}
+		value, _ := new(big.Int).SetString(strings.ReplaceAll(literal[i+2:end], "_", ""), 16)
+		if negative {
+			value.Neg(value)
+		}
+		return exactLiteral(new(big.Rat).SetInt(value)), nil
+	}
+
+	end := i
+	exact := true
+	if end < len(literal) && literal[end] != '.' {
+		var err *NumberError
+		if end, err = scanDigits(literal, end, isDecimalDigit, "digits"); err != nil {
+			return NumberLiteral{}, err
+		}
+	}
+	if end < len(literal) && literal[end] == '/' && end > i {
+		denominatorStart := end + 1
+		end, err := scanDigits(literal, denominatorStart, isDecimalDigit, "denominator digits")
+		if err != nil {
+			return NumberLiteral{}, err
+		}
+		if end != len(literal) {
+			return NumberLiteral{}, unexpectedInNumber(literal, end)
+		}
+		value, _ := new(big.Rat).SetString(strings.ReplaceAll(literal, "_", ""))
+		if value == nil {
+			return NumberLiteral{}, &NumberError{Offset: denominatorStart, Expected: "nonzero denominator", Found: literal[denominatorStart:]}
+		}
+		return exactLiteral(value), nil
+	}
+	if end < len(literal) && literal[end] == '.' {
+		exact = false
+		end++
+		// digits after the point are optional when there were some before it
+		if end < len(literal) && isDecimalDigit(literal[end]) || end-1 == i {
+			var err *NumberError
+			if end, err = scanDigits(literal, end, isDecimalDigit, "digits after '.'"); err != nil {
+				return NumberLiteral{}, err
+			}
+		}
+	}
+	if end < len(literal) && (literal[end] == 'e' || literal[end] == 'E') {
+		exact = false
+		end++
+		if end < len(literal) && (literal[end] == '+' || literal[end] == '-') {
+			end++
+		}
+		var err *NumberError
+		if end, err = scanDigits(literal, end, isDecimalDigit, "exponent digits"); err != nil {
+			return NumberLiteral{}, err
+		}
+	}
+	if end != len(literal) {
+		return NumberLiteral{}, unexpectedInNumber(literal, end)
+	}
+
+	digits := strings.ReplaceAll(literal, "_", "")
+	if exact {
+		value, _ := new(big.Rat).SetString(digits)
+		return exactLiteral(value), nil
+	}
+
+	f, parseErr := strconv.ParseFloat(digits, 64)
+	if parseErr != nil || math.IsInf(f, 0) {
+		return NumberLiteral{}, &NumberError{Offset: 0, Expected: "number in float64 range", Found: literal}
+	}
+	return NumberLiteral{Float: f}, nil
+}
+
+func exactLiteral(value *big.Rat) NumberLiteral {
+	f, _ := value.Float64()
+	return NumberLiteral{Exact: value, Float: f}
+}
+
+// scanDigits scans at least one digit starting at start, allowing single
+// underscores between digits, and returns the index after the last digit.
+func scanDigits(literal string, start int, isDigit func(byte) bool, what string) (int, *NumberError) {
+	if start >= len(literal) {
+		return 0, &NumberError{Offset: start, Expected: what, Found: "end of number literal"}
+	}
+	if !isDigit(literal[start]) {
+		return 0, &NumberError{Offset: start, Expected: what, Found: fmt.Sprintf("%q", literal[start])}
+	}
+
+	i := start
+	for i < len(literal) {
+		switch {
+		case isDigit(literal[i]):
+			i++
+		case literal[i] == '_':
+			if i+1 >= len(literal) || !isDigit(literal[i+1]) {
+				return 0, &NumberError{Offset: i, Expected: "digit after '_'", Found: describeNumberByte(literal, i+1)}
+			}
+			i++
+		default:
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+func unexpectedInNumber(literal string, offset int) *NumberError {
+	return &NumberError{Offset: offset, Expected: "end of number literal", Found: describeNumberByte(literal, offset)}
+}
+
+func describeNumberByte(literal string, offset int) string {
+	if offset >= len(literal) {
+		return "end of number literal"
+	}
+	return fmt.Sprintf("%q", literal[offset])
+}
+
+func isDecimalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDecimalDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
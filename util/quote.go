@@ -0,0 +1,120 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// QuoteError reports a malformed string literal. Offset is the byte offset of
+// the problem within the literal, including its opening quote.
+type QuoteError struct {
+	Offset   int
+	Expected string
+	Found    string
+}
+
+func (e *QuoteError) Error() string {
+	return fmt.Sprintf("expected %s but found %s", e.Expected, e.Found)
+}
+
+// Unquote decodes a double quoted string literal as written in source. It
+// understands the escapes \n, \t, \", \\ and \u{hex}.
+func Unquote(literal string) (string, *QuoteError) {
+	if !strings.HasPrefix(literal, `"`) {
+		return "", &QuoteError{Offset: 0, Expected: "opening double quote", Found: literal}
+	}
+
+	var sb strings.Builder
+	for i := 1; i < len(literal); i++ {
+		ch := literal[i]
+		switch ch {
+		case '"':
+			if i != len(literal)-1 {
+				return "", &QuoteError{Offset: i + 1, Expected: "end of string literal", Found: literal[i+1:]}
+			}
+			return sb.String(), nil
+		case '\\':
+			if i+1 >= len(literal) {
+				return "", &QuoteError{Offset: i, Expected: "escape sequence", Found: "end of input"}
+			}
+			switch literal[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '"':
+				sb.WriteByte('"')
+			case '\\':
+				sb.WriteByte('\\')
+			case 'u':
+				r, length, err := unquoteUnicode(literal[i:])
+				if err != nil {
+					return "", &QuoteError{Offset: i, Expected: err.Error(), Found: literal[i:min(i+length, len(literal))]}
+				}
+				sb.WriteRune(r)
+				i += length - 2
+			default:
+				return "", &QuoteError{Offset: i, Expected: `escape sequence \n, \t, \", \\ or \u{hex}`, Found: literal[i : i+2]}
+			}
+			i++
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+
+	return "", &QuoteError{Offset: len(literal), Expected: "closing double quote", Found: "end of input"}
+}
+
+// unquoteUnicode decodes a leading \u{hex} escape and reports how many bytes
+// it used. On failure the error says what was expected and the length covers
+// the malformed escape.
+func unquoteUnicode(s string) (rune, int, error) {
+	if len(s) < 3 || s[2] != '{' {
+		return 0, 2, fmt.Errorf("\\u{hex} escape")
+	}
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return 0, len(s), fmt.Errorf("} closing the \\u{hex} escape")
+	}
+	hex := s[3:end]
+	if hex == "" || len(hex) > 6 {
+		return 0, end + 1, fmt.Errorf("1 to 6 hex digits in \\u{}")
+	}
+	code, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, end + 1, fmt.Errorf("hex digits in \\u{}")
+	}
+	r := rune(code)
+	if !utf8.ValidRune(r) {
+		return 0, end + 1, fmt.Errorf("valid unicode code point in \\u{}")
+	}
+	return r, end + 1, nil
+}
+
+// Quote is the inverse of Unquote: it writes s as a double quoted literal.
+func Quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u{%x}`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+package util
+
+// EditDistance returns the Levenshtein distance between a and b counted in
+// runes: the number of single-character insertions, deletions and
+// substitutions that turn a into b.
+func EditDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+// Suggest picks the candidate closest to name for a "did you mean" hint.
+// Only candidates within a third of name's length count as close, so short
+// names get no suggestion. Ties go to the alphabetically first candidate.
+func Suggest(name string, candidates []string) (string, bool) {
+	limit := len([]rune(name)) / 3
+	best, bestDistance := "", limit+1
+	for _, candidate := range candidates {
+		distance := EditDistance(name, candidate)
+		if distance < bestDistance || distance == bestDistance && candidate < best {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best, best != "" && bestDistance > 0
+}